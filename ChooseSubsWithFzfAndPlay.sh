@@ -3,11 +3,10 @@
 # URL base do servidor
 BASE_URL="http://localhost:8080"
 
-# Pega a lista JSON de legendas e extrai nomes com jq
-subs=$(curl -s "$BASE_URL/subs/" | jq -r '.[]')
-echo $subs
-# Escolhe legenda com fzf
-chosen_sub=$(echo "$subs" | fzf --prompt="Escolha a legenda: ")
+# Pega a lista JSON de legendas e monta "nome<TAB>idioma - fonte - nome" com jq
+subs=$(curl -s "$BASE_URL/subs/" | jq -r '.[] | "\(.name)\t\(.lang // "?") - \(.provider // "local")\(if .hearing_impaired then " (HI)" else "" end) - \(.name)"')
+# Escolhe legenda com fzf (mostra só a descrição, devolve o nome)
+chosen_sub=$(echo "$subs" | fzf --prompt="Escolha a legenda: " --delimiter="\t" --with-nth=2 | cut -f1)
 
 if [ -z "$chosen_sub" ]; then
     echo "Nenhuma legenda selecionada. Tocando sem legenda."
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"go-watch-something/internal/subtitles"
+)
+
+// defaultCacheTTL is how long fetched subtitles stay cached.
+const defaultCacheTTL = 30 * 24 * time.Hour
+
+// runCache is the "cache" subcommand, for looking at and clearing the
+// subtitle cache:
+//
+//	go-watch-something cache list
+//	go-watch-something cache clear [info-hash]
+func runCache(args []string) error {
+	fs := flag.NewFlagSet("cache", flag.ExitOnError)
+	ttl := fs.Duration("ttl", defaultCacheTTL, "Entries older than this are shown as expired.")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: go-watch-something cache [-ttl d] list | clear [info-hash]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	dir, err := subtitles.DefaultCacheDir()
+	if err != nil {
+		return err
+	}
+	c := subtitles.Cache{Dir: dir, TTL: *ttl}
+
+	switch fs.Arg(0) {
+	case "list", "":
+		entries, err := c.Entries()
+		if err != nil {
+			return err
+		}
+		if len(entries) == 0 {
+			fmt.Printf("No cached subtitles in %s.\n", dir)
+			return nil
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "INFO-HASH\tFILE\tLANG\tPROVIDER\tAGE")
+		for _, e := range entries {
+			lang := e.Lang
+			if e.HearingImpaired {
+				lang += " (HI)"
+			}
+			age := time.Since(e.StoredAt).Round(time.Minute).String()
+			if c.Expired(e) {
+				age += " (expired)"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", e.InfoHash, e.File, lang, e.Provider, age)
+		}
+		return w.Flush()
+	case "clear":
+		n, err := c.Clear(fs.Arg(1))
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Removed %d cached subtitle(s).\n", n)
+		return nil
+	default:
+		fs.Usage()
+		return fmt.Errorf("unknown cache command %q", fs.Arg(0))
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+
+	"go-watch-something/internal/history"
+)
+
+// historySaveInterval is how often a session's progress is written to
+// the history while it runs.
+const historySaveInterval = 15 * time.Second
+
+// runHistory is the "history" subcommand:
+//
+//	go-watch-something history [list]
+//	go-watch-something history clear
+func runHistory(args []string) error {
+	fs := flag.NewFlagSet("history", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: go-watch-something history [list | clear]")
+	}
+	fs.Parse(args)
+
+	path, err := history.DefaultPath()
+	if err != nil {
+		return err
+	}
+	store := history.Store{Path: path}
+
+	switch fs.Arg(0) {
+	case "list", "":
+		entries, err := store.Entries()
+		if err != nil {
+			return err
+		}
+		if len(entries) == 0 {
+			fmt.Println("Nothing watched yet.")
+			return nil
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "#\tWATCHED\tAT\tNAME\tFILE")
+		for i, e := range entries {
+			fmt.Fprintf(w, "%d\t%s ago\t%s\t%s\t%s\n", i+1, time.Since(e.Updated).Round(time.Minute), progress(e), e.Name, e.File)
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+		fmt.Println("\nPick one up again with: go-watch-something resume <#, info-hash or name>")
+		return nil
+	case "clear":
+		if err := store.Clear(); err != nil {
+			return err
+		}
+		fmt.Println("History cleared.")
+		return nil
+	default:
+		fs.Usage()
+		return fmt.Errorf("unknown history command %q", fs.Arg(0))
+	}
+}
+
+// progress is how far into e playback got, as the history lists it.
+func progress(e history.Entry) string {
+	switch {
+	case e.Position > 0 && e.Duration > 0:
+		return clock(e.Position) + " / " + clock(e.Duration)
+	case e.Position > 0:
+		return clock(e.Position)
+	case e.Offset > 0 && e.Length > 0:
+		return fmt.Sprintf("%.0f%% read", float64(e.Offset)/float64(e.Length)*100)
+	}
+	return "-"
+}
+
+// clock formats d as h:mm:ss.
+func clock(d time.Duration) string {
+	s := int64(d.Round(time.Second) / time.Second)
+	return fmt.Sprintf("%d:%02d:%02d", s/3600, s/60%60, s%60)
+}
+
+// resumeArgs turns "resume [entry] [flags]" into the flags for picking
+// the entry up again: the same magnet, file and subtitles, starting
+// where playback got to, with -autoplay. Flags given after the entry
+// follow, so they win.
+func resumeArgs(args []string) ([]string, error) {
+	var ref string
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		ref, args = args[0], args[1:]
+	}
+	path, err := history.DefaultPath()
+	if err != nil {
+		return nil, err
+	}
+	e, err := history.Store{Path: path}.Find(ref)
+	if errors.Is(err, history.ErrNotFound) {
+		return nil, fmt.Errorf("%w -- see go-watch-something history", err)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	out := []string{"-magnet", e.Magnet, "-file", e.File, "-autoplay"}
+	at := e.ResumeAt()
+	if at > 0 {
+		out = append(out, "-start", at.String())
+	}
+	if e.SubLangs != nil {
+		out = append(out, "-subs", "-sub-langs", strings.Join(e.SubLangs, ","))
+	}
+	for _, f := range e.SubFiles {
+		if _, err := os.Stat(f); err != nil {
+			log.Printf("Subtitle file from last time is gone, skipping it: %v", err)
+			continue
+		}
+		out = append(out, "-sub-file", f)
+	}
+	fmt.Printf("Resuming %s at %s.\n", e.Name, clock(at))
+	return append(out, args...), nil
+}
+
+// recorder keeps a session's history entry up to date: it's saved at
+// the start, every historySaveInterval and on the way out.
+type recorder struct {
+	store  history.Store
+	offset atomic.Int64 // fed by the HTTP server
+
+	mu    sync.Mutex
+	entry history.Entry
+	// seededAt is the byte offset e.Position was started at (with
+	// -start, on resume), -1 once the player or the reads have moved
+	// on from it.
+	seededAt int64
+}
+
+// newRecorder starts recording e. A Position it comes with is where
+// playback starts, at byte startOffset; it's kept only until reads get
+// past that, so a player that can't report its position doesn't
+// resume from the same spot every time.
+func newRecorder(store history.Store, e history.Entry, startOffset int64) *recorder {
+	r := &recorder{store: store, entry: e, seededAt: -1}
+	if e.Position > 0 {
+		r.seededAt = startOffset
+	}
+	r.save()
+	go func() {
+		for range time.Tick(historySaveInterval) {
+			r.save()
+		}
+	}()
+	return r
+}
+
+// setPlayback records where the player says playback is.
+func (r *recorder) setPlayback(pos, dur time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.entry.Position, r.entry.Duration = pos, dur
+	r.seededAt = -1
+}
+
+// setDuration records how long the video plays, unless the player has
+// already said.
+func (r *recorder) setDuration(d time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.entry.Duration == 0 {
+		r.entry.Duration = d
+	}
+}
+
+func (r *recorder) save() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if off := r.offset.Load(); off > 0 {
+		r.entry.Offset = off
+		if r.seededAt >= 0 && off > r.seededAt {
+			r.entry.Position, r.seededAt = 0, -1
+		}
+	}
+	if err := r.store.Save(r.entry); err != nil {
+		log.Printf("Failed to save watch history: %v", err)
+	}
+}
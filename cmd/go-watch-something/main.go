@@ -1,15 +1,27 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
+	"net"
+	"net/url"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"strings"
 	"syscall"
+	"time"
 
+	"github.com/anacrolix/torrent"
+
+	"go-watch-something/internal/config"
+	"go-watch-something/internal/history"
+	"go-watch-something/internal/lang"
+	"go-watch-something/internal/nfo"
 	"go-watch-something/internal/player"
+	"go-watch-something/internal/release"
 	"go-watch-something/internal/streamer"
 	"go-watch-something/internal/subtitles"
 	"go-watch-something/internal/trackers"
@@ -17,7 +29,28 @@ import (
 )
 
 func main() {
-	serveBufAtFlag := flag.Float64("serve_at", 0.02, "Float of range [0,1].")
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "cache":
+			if err := runCache(os.Args[2:]); err != nil {
+				log.Fatal(err)
+			}
+			return
+		case "history":
+			if err := runHistory(os.Args[2:]); err != nil {
+				log.Fatal(err)
+			}
+			return
+		case "resume":
+			args, err := resumeArgs(os.Args[2:])
+			if err != nil {
+				log.Fatal(err)
+			}
+			os.Args = append(os.Args[:1], args...)
+		}
+	}
+
+	serveBufAtFlag := flag.Float64("serve_at", 0.02, "Fraction of the file to buffer, from where playback starts, before serving it. Float of range [0,1].")
 	portFlag := flag.Uint("port", 8080, "Port to serve content on.")
 	hostFlag := flag.String("host", "127.0.0.1", "Host to bind the server to. Use 0.0.0.0 to allow LAN access.")
 	var inMemory bool
@@ -27,26 +60,92 @@ func main() {
 	var autoplay bool
 	flag.BoolVar(&autoplay, "autoplay", false, "Launch a video player automatically once buffering completes.")
 	var playerOverride string
-	flag.StringVar(&playerOverride, "player", "", "Command to launch for -autoplay. Empty auto-detects xdg-open, then mpv, then vlc.")
+	flag.StringVar(&playerOverride, "player", "", "Player for -autoplay: a profile (mpv, vlc, celluloid, mplayer, or one from the config file), a command, a command line with {url}, {sub}, ... placeholders, or a Kodi box as kodi://[user:password@]host[:port]. Empty auto-detects xdg-open, then mpv, then vlc.")
+	var exitOnPlayerClose bool
+	flag.BoolVar(&exitOnPlayerClose, "exit-on-player-close", false, "With -autoplay, clean up and exit when the player closes, with its exit status.")
+	var playerIPC bool
+	flag.BoolVar(&playerIPC, "player-ipc", true, "Control the player -- mpv over its IPC socket, VLC over its HTTP interface, Kodi over JSON-RPC: download what's about to play, pause when the buffer runs dry, and load subtitles fetched after launch.")
+	var start time.Duration
+	flag.DurationVar(&start, "start", 0, "Start playback this far in (1h12m): buffer from there, and pass it to players whose profile takes it.")
+	var playerCache time.Duration
+	flag.DurationVar(&playerCache, "player-cache", 0, "How far ahead the player should buffer, for players whose profile takes it. 0 leaves it to the player.")
 	var wantSubs bool
 	flag.BoolVar(&wantSubs, "subs", false, "Fetch subtitles (tries subliminal, then the OpenSubtitles API).")
+	var subsAll bool
+	flag.BoolVar(&subsAll, "subs-all", false, "Query every subtitle provider concurrently and keep all results, instead of stopping at the first that succeeds.")
+	var subsTimeout time.Duration
+	flag.DurationVar(&subsTimeout, "subs-timeout", 60*time.Second, "Per-provider time limit with -subs-all.")
+	var subsWait time.Duration
+	flag.DurationVar(&subsWait, "subs-wait", 10*time.Second, "With -autoplay -subs, how long to wait for subtitles before launching the player anyway.")
+	var subFiles []string
+	flag.Func("sub-file", "Local subtitle file to serve alongside any fetched ones. Repeatable.", func(path string) error {
+		subFiles = append(subFiles, path)
+		return nil
+	})
+	var subLibrary []string
+	flag.Func("sub-library", "Folder of previously downloaded subtitles to search first, and to store new ones in (the first given). Repeatable.", func(dir string) error {
+		subLibrary = append(subLibrary, dir)
+		return nil
+	})
+	var subsCacheTTL time.Duration
+	flag.DurationVar(&subsCacheTTL, "subs-cache-ttl", defaultCacheTTL, "How long fetched subtitles stay cached for the same torrent file. 0 keeps them forever.")
+	var noSubsCache bool
+	flag.BoolVar(&noSubsCache, "no-subs-cache", false, "Neither use nor fill the subtitle cache.")
 	var subLangs string
-	flag.StringVar(&subLangs, "sub-langs", "en", "Comma-separated subtitle langs: en,pt-BR,...")
+	flag.StringVar(&subLangs, "sub-langs", "", "Comma-separated subtitle langs: en,pt-BR,por,Portuguese,... Empty uses the locale's ($LANG), or en.")
 	var magnet string
 	flag.StringVar(&magnet, "magnet", "", "Magnet link to stream.")
+	var filePath string
+	flag.StringVar(&filePath, "file", "", "Path of the video to play within the torrent, or an episode (S02E05) in a season pack. Empty picks the largest video that isn't a sample.")
+	var readTimeout time.Duration
+	flag.DurationVar(&readTimeout, "read-timeout", 0, "End a response whose read has waited this long on pieces that haven't downloaded. 0 waits as long as it takes.")
+	var busyAfter time.Duration
+	flag.DurationVar(&busyAfter, "busy-after", 0, "Answer a request whose first bytes haven't downloaded after this long with 503 and Retry-After, instead of holding it open. 0 turns this off.")
+	var noHistory bool
+	flag.BoolVar(&noHistory, "no-history", false, "Don't record this session in the watch history.")
+	var configPath string
+	flag.StringVar(&configPath, "config", "", "Config file. Empty uses go-watch-something/config.json in the user config dir, if it exists.")
 	flag.Parse()
 
+	if configPath == "" {
+		if path, err := config.DefaultPath(); err == nil {
+			configPath = path
+		}
+	}
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	if *serveBufAtFlag < 0 || *serveBufAtFlag > 1 {
 		log.Fatal("Flag serve_at must be in range [0,1].")
 	}
 	if *portFlag > 65535 {
 		log.Fatal("Flag port must be in range [0, 65535].")
 	}
+	if exitOnPlayerClose && !autoplay {
+		log.Fatal("Flag exit-on-player-close needs -autoplay.")
+	}
+	streamHost := *hostFlag
+	if player.IsKodi(playerOverride) {
+		if streamHost, err = kodiStreamHost(playerOverride, *hostFlag); err != nil {
+			log.Fatalf("-player: %v", err)
+		}
+	}
+	langs := []string{lang.FromEnv()}
+	if subLangs != "" {
+		if langs, err = lang.Parse(subLangs); err != nil {
+			log.Fatalf("-sub-langs: %v", err)
+		}
+	}
 	if magnet == "" || !utils.IsValidMagnetLink(magnet) {
 		log.Fatal("Valid magnet link is required.")
 	}
 
-	magnet, err := trackers.AddTrackers(magnet, trackersSource)
+	displayName := utils.MagnetDisplayName(magnet)
+	givenMagnet := magnet
+
+	magnet, err = trackers.AddTrackers(magnet, trackersSource)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -54,35 +153,335 @@ func main() {
 	tmpDir, client, t := streamer.SetupTorrentClient(magnet, inMemory)
 	defer streamer.CleanUp(tmpDir, client)
 
-	largestFile := streamer.SelectLargestVideo(t)
-	fmt.Printf("Selected file: %s\n", largestFile.Path())
+	largestFile := streamer.SelectVideo(t)
+	if filePath != "" {
+		if largestFile = streamer.FindFile(t, filePath); largestFile == nil {
+			log.Fatalf("-file: no %s in the torrent.", filePath)
+		}
+	}
+	fmt.Printf("Selected file: %s (%s)\n", largestFile.Path(), release.Parse(largestFile.Path()))
 
+	// Subtitles live next to the video, where subliminal expects them;
+	// -sub-file and uploads to /subs/ land there too. With in-memory
+	// storage nothing else creates the directory.
+	videoPath := filepath.Join(tmpDir, largestFile.Path())
+	subsDir := filepath.Dir(videoPath)
+	if err := os.MkdirAll(subsDir, 0o755); err != nil {
+		log.Fatalf("Creating subtitle dir: %v", err)
+	}
+	var userSubs []subtitles.Subtitle
+	for _, path := range subFiles {
+		sub, err := subtitles.AddFile(subsDir, path, "")
+		if err != nil {
+			log.Fatalf("-sub-file: %v", err)
+		}
+		userSubs = append(userSubs, sub)
+	}
+
+	// Subtitles are fetched in the background so a slow provider
+	// doesn't hold up buffering; /subs/ lists files as they land.
+	var subsJob *subtitles.Job
 	if wantSubs {
-		langs := utils.ParseLangs(subLangs)
-		videoPath := filepath.Join(tmpDir, largestFile.Path())
-		videoDir := filepath.Dir(videoPath)
-		providers := []subtitles.Provider{subtitles.Subliminal{}, subtitles.NewOpenSubtitles()}
-		if err := subtitles.FetchWithFallback(providers, videoDir, langs); err != nil {
-			log.Printf("Failed to fetch subtitles: %v\nContinuing without subtitles.", err)
-			wantSubs = false
+		video := subtitles.Video{
+			Dir:         subsDir,
+			Name:        filepath.Base(videoPath),
+			Size:        largestFile.Length(),
+			DisplayName: displayName,
+			InfoHash:    t.InfoHash().HexString(),
+			TorrentFile: largestFile.Path(),
 		}
+		if video.DisplayName == "" {
+			video.DisplayName = t.Name()
+		}
+		providers := []subtitles.Provider{cfg.Subliminal, subtitles.NewOpenSubtitles()}
+		for _, p := range subtitles.FindPlugins(cfg.SubtitlePlugins) {
+			providers = append(providers, p)
+		}
+		subsJob = subtitles.Start(func() ([]subtitles.Subtitle, error) {
+			ids := nfo.ParseAll(streamer.ReadNFOs(t))
+			video.IMDbID, video.TMDbID = ids.IMDb, ids.TMDb
+			if !ids.Empty() {
+				fmt.Printf("Found IDs in .nfo: %+v\n", ids)
+			}
+			// Offline providers run first; the network is only asked
+			// for languages they don't have.
+			var local []subtitles.Provider
+			if !noSubsCache {
+				if dir, err := subtitles.DefaultCacheDir(); err != nil {
+					log.Printf("Subtitle cache unavailable: %v", err)
+				} else {
+					local = append(local, subtitles.Cache{Dir: dir, TTL: subsCacheTTL})
+				}
+			}
+			if len(subLibrary) > 0 {
+				hash, err := streamer.MovieHash(largestFile)
+				if err != nil {
+					log.Printf("Movie hash unavailable, matching the library by name only: %v", err)
+				}
+				video.Hash = hash
+				local = append(local, subtitles.Library{Dirs: subLibrary})
+			}
+			return subtitles.FetchLocalFirst(context.Background(), local, video, langs, func(langs []string) ([]subtitles.Subtitle, error) {
+				return fetchSubtitles(providers, video, langs, subsAll, subsTimeout)
+			})
+		})
 	}
 
-	streamer.StartDownload(t, largestFile)
+	// With -start, buffer from where playback starts rather than the
+	// beginning.
+	var startOffset int64
+	if start > 0 {
+		var via string
+		startOffset, via = streamer.StartOffset(largestFile, start)
+		fmt.Printf("Starting at %s: byte %d of %d (%s).\n", clock(start), startOffset, largestFile.Length(), via)
+	}
+
+	var rec *recorder
+	if !noHistory {
+		if path, err := history.DefaultPath(); err != nil {
+			log.Printf("Watch history unavailable: %v", err)
+		} else {
+			entry := history.Entry{
+				InfoHash: t.InfoHash().HexString(),
+				Name:     displayName,
+				Magnet:   givenMagnet,
+				File:     largestFile.Path(),
+				Length:   largestFile.Length(),
+				Position: start,
+			}
+			if entry.Name == "" {
+				entry.Name = t.Name()
+			}
+			if wantSubs {
+				entry.SubLangs = langs
+			}
+			for _, f := range subFiles {
+				if abs, err := filepath.Abs(f); err == nil {
+					entry.SubFiles = append(entry.SubFiles, abs)
+				}
+			}
+			rec = newRecorder(history.Store{Path: path}, entry, startOffset)
+			defer rec.save()
+		}
+	}
+
+	buffer := []streamer.Span{{Offset: startOffset, Size: int64(*serveBufAtFlag * float64(largestFile.Length()))}}
+	// Players read the container's index before they start or seek;
+	// fetch it with the buffer.
+	if index, what, err := streamer.IndexSpans(largestFile); err != nil {
+		log.Printf("Couldn't find the container's index, buffering without it: %v", err)
+	} else if len(index) > 0 {
+		var size int64
+		for _, s := range index {
+			size += s.Size
+		}
+		fmt.Printf("Fetching the %s (%d bytes) with the buffer.\n", what, size)
+		buffer = append(buffer, index...)
+	}
+	streamer.StartDownload(t, largestFile, buffer...)
+	// Players without a control channel never say how long the video
+	// is, and resuming them goes by the read offset's share of it.
+	if rec != nil {
+		rec.setDuration(streamer.MediaDuration(largestFile))
+	}
 
 	// Serve HTTP endpoints
-	streamer.StartHTTPServer(*hostFlag, *portFlag, largestFile, tmpDir, wantSubs)
+	server := streamer.ServerConfig{
+		Host:     *hostFlag,
+		Port:     *portFlag,
+		File:     largestFile,
+		SubsDir:  subsDir,
+		Subs:     subsJob,
+		UserSubs: userSubs,
+
+		ReadTimeout: readTimeout,
+		BusyAfter:   busyAfter,
+	}
+	if rec != nil {
+		server.ReadOffset = &rec.offset
+	}
+	streamer.StartHTTPServer(server)
 
+	var playerHandle *player.Handle
 	if autoplay {
-		streamURL := fmt.Sprintf("http://%s:%d/movie", *hostFlag, *portFlag)
-		if err := player.Launch(streamURL, playerOverride); err != nil {
+		subsReady := subsJob == nil || subsJob.Wait(subsWait)
+		if !subsReady {
+			fmt.Println("Subtitles not ready yet; starting playback without waiting. They'll show up at /subs/ once fetched.")
+		}
+		base := fmt.Sprintf("http://%s:%d", streamHost, *portFlag)
+		subURL := func(s subtitles.Subtitle) string {
+			return base + "/subs/" + url.PathEscape(filepath.Base(s.Path))
+		}
+		var subURLs []string
+		for _, s := range userSubs {
+			subURLs = append(subURLs, subURL(s))
+		}
+		if subsJob != nil {
+			for _, s := range subsJob.Subtitles() {
+				subURLs = append(subURLs, subURL(s))
+			}
+		}
+		media := player.Media{
+			URL:   base + "/movie",
+			Title: mediaTitle(largestFile.Path(), displayName),
+			Subs:  subURLs,
+			Start: start,
+			Cache: playerCache,
+		}
+		opts := player.Options{Override: playerOverride, Profiles: cfg.Players, Wait: exitOnPlayerClose, IPC: playerIPC}
+		h, err := player.Launch(media, opts)
+		if err == nil && h.Controllable() {
+			var late *subtitles.Job
+			if !subsReady {
+				late = subsJob
+			}
+			go controlPlayer(h, largestFile, late, subURL, rec)
+		}
+		switch {
+		case err != nil:
 			log.Printf("Autoplay failed: %v\nOpen the URL above manually.", err)
+		case exitOnPlayerClose && h.Detached():
+			log.Printf("%s hands the stream to another program, so there's no telling when playback ends; -exit-on-player-close is off. Pass -player=mpv (or vlc, ...) to use it.", h.Command)
+		case exitOnPlayerClose:
+			playerHandle = h
 		}
 	}
 
-	// Handle interrupts
+	// Handle interrupts -- and, with -exit-on-player-close, the player
+	// closing, whose exit status becomes ours.
 	sigs := make(chan os.Signal, 1)
 	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
-	<-sigs
-	fmt.Println("\nInterrupt received. Exiting.")
+	var playerDone <-chan struct{}
+	if playerHandle != nil {
+		playerDone = playerHandle.Done()
+	}
+	select {
+	case <-sigs:
+		fmt.Println("\nInterrupt received. Exiting.")
+	case <-playerDone:
+		code := playerHandle.ExitCode()
+		fmt.Printf("Player closed (exit status %d). Exiting.\n", code)
+		// os.Exit skips the deferred CleanUp and history save.
+		if rec != nil {
+			rec.save()
+		}
+		streamer.CleanUp(tmpDir, client)
+		os.Exit(code)
+	}
+}
+
+// controlPlayer drives a player that can be controlled -- mpv with a
+// control socket, VLC over its HTTP interface, or Kodi -- until it
+// exits: FollowPlayback keeps the download on what's about to play,
+// subtitles from late -- a fetch still running at launch -- are loaded
+// into the player as they arrive, and the playback position goes to
+// rec for the watch history (unless it's nil).
+func controlPlayer(h *player.Handle, f *torrent.File, late *subtitles.Job, subURL func(subtitles.Subtitle) string, rec *recorder) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-h.Done()
+		cancel()
+	}()
+	ctl, err := h.Control(ctx)
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Printf("Couldn't take control of the player: %v", err)
+		}
+		return
+	}
+	defer ctl.Close()
+	if late != nil {
+		go func() {
+			select {
+			case <-late.Done():
+			case <-ctx.Done():
+				return
+			}
+			for _, s := range late.Subtitles() {
+				if err := ctl.AddSubtitle(subURL(s)); err != nil {
+					log.Printf("Couldn't load %s into the player: %v", filepath.Base(s.Path), err)
+					continue
+				}
+				fmt.Printf("Loaded subtitle into the player: %s\n", filepath.Base(s.Path))
+			}
+		}()
+	}
+	if rec != nil {
+		go func() {
+			tick := time.NewTicker(5 * time.Second)
+			defer tick.Stop()
+			for {
+				if pos, err := ctl.Position(); err == nil {
+					dur, _ := ctl.Duration()
+					rec.setPlayback(pos, dur)
+				}
+				select {
+				case <-ctx.Done():
+					return
+				case <-tick.C:
+				}
+			}
+		}()
+	}
+	streamer.FollowPlayback(ctx, ctl, f)
+}
+
+// kodiStreamHost is the host to put in stream URLs for the Kodi box
+// named by override. It fetches the stream itself, so a loopback bind
+// won't do unless Kodi runs here too, and an every-interface bind needs
+// this machine's address on the way to it.
+func kodiStreamHost(override, host string) (string, error) {
+	k, err := player.ParseKodi(override)
+	if err != nil {
+		return "", err
+	}
+	kodiHost, _, _ := net.SplitHostPort(k.Addr())
+	local := func(h string) bool {
+		ip := net.ParseIP(h)
+		return h == "localhost" || ip != nil && ip.IsLoopback()
+	}
+	switch ip := net.ParseIP(host); {
+	case local(host) && !local(kodiHost):
+		return "", fmt.Errorf("Kodi at %s fetches the stream itself and can't reach %s; pass -host=0.0.0.0 (or this machine's LAN address)", k.Addr(), host)
+	case ip != nil && ip.IsUnspecified():
+		return k.LocalIP()
+	}
+	return host, nil
+}
+
+// fetchSubtitles runs the configured subtitle providers for video,
+// logging the outcome -- it runs in the background, so nothing else
+// will.
+func fetchSubtitles(providers []subtitles.Provider, video subtitles.Video, langs []string, all bool, timeout time.Duration) ([]subtitles.Subtitle, error) {
+	if all {
+		res, err := subtitles.FetchAll(context.Background(), providers, video, langs, timeout)
+		if err != nil {
+			log.Printf("Failed to fetch subtitles: %v\nContinuing without subtitles.", err)
+			return nil, err
+		}
+		if len(res.Missing) > 0 {
+			log.Printf("No subtitles found for: %s", strings.Join(res.Missing, ", "))
+		}
+		return res.Subtitles, nil
+	}
+	subs, err := subtitles.FetchWithFallback(context.Background(), providers, video, langs)
+	if err != nil {
+		log.Printf("Failed to fetch subtitles: %v\nContinuing without subtitles.", err)
+	}
+	return subs, err
+}
+
+// mediaTitle is what the player shows as the title: the parsed release
+// name ("Show S02E05 (2024)"), from the torrent's display name if the
+// file name is obfuscated.
+func mediaTitle(path, displayName string) string {
+	name := filepath.Base(path)
+	if release.Obfuscated(name) && displayName != "" {
+		name = displayName
+	}
+	if title := release.Parse(name).String(); title != "" {
+		return title
+	}
+	return name
 }
@@ -0,0 +1,64 @@
+// Package avi is a minimal AVI (RIFF) reader: just enough to find the
+// header list and the idx1 index, which players read before they can
+// start or seek, so the streamer can fetch them first.
+//
+// Like packages mkv and mp4, it reads through an io.ReadSeeker and skips
+// what it doesn't need -- only chunk headers are read.
+package avi
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrNotAVI is returned when the input isn't a RIFF AVI file.
+var ErrNotAVI = errors.New("avi: not an AVI file")
+
+// Chunk is a chunk of the file's first RIFF list: its FourCC, a LIST's
+// list type ("hdrl", "movi", ...) and where it is in the file.
+type Chunk struct {
+	ID     string
+	List   string // for a LIST, "" otherwise
+	Offset int64  // of the header
+	Size   int64  // header and padding included
+}
+
+// Chunks lists the chunks in the file's first RIFF list, reading only
+// their headers. OpenDML files (over 1 GB) carry on in further AVIX
+// lists, which aren't read; their idx1, if any, only covers the first.
+func Chunks(rs io.ReadSeeker) ([]Chunk, error) {
+	var h [12]byte
+	if _, err := rs.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	if _, err := io.ReadFull(rs, h[:]); err != nil || string(h[:4]) != "RIFF" || string(h[8:]) != "AVI " {
+		return nil, ErrNotAVI
+	}
+	end := 8 + int64(binary.LittleEndian.Uint32(h[4:8]))
+
+	var chunks []Chunk
+	for off := int64(12); off+8 <= end; {
+		if _, err := rs.Seek(off, io.SeekStart); err != nil {
+			return chunks, err
+		}
+		if _, err := io.ReadFull(rs, h[:8]); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				break // a truncated file: the RIFF size ran past its end
+			}
+			return chunks, fmt.Errorf("avi: chunk header at %d: %w", off, err)
+		}
+		c := Chunk{ID: string(h[:4]), Offset: off, Size: 8 + int64(binary.LittleEndian.Uint32(h[4:8]))}
+		c.Size += c.Size & 1 // chunks are padded to an even size
+		if c.ID == "LIST" {
+			if _, err := io.ReadFull(rs, h[8:12]); err != nil {
+				return chunks, fmt.Errorf("avi: list type at %d: %w", off, err)
+			}
+			c.List = string(h[8:12])
+		}
+		chunks = append(chunks, c)
+		off += c.Size
+	}
+	return chunks, nil
+}
@@ -0,0 +1,63 @@
+package avi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+func chunk(id string, data []byte) []byte {
+	b := make([]byte, 8, 9+len(data))
+	copy(b, id)
+	binary.LittleEndian.PutUint32(b[4:], uint32(len(data)))
+	b = append(b, data...)
+	if len(data)%2 == 1 {
+		b = append(b, 0)
+	}
+	return b
+}
+
+func list(typ string, data []byte) []byte {
+	return chunk("LIST", append([]byte(typ), data...))
+}
+
+func TestChunks(t *testing.T) {
+	hdrl := list("hdrl", chunk("avih", make([]byte, 56)))
+	movi := list("movi", chunk("00dc", []byte("odd")))
+	idx1 := chunk("idx1", make([]byte, 16))
+	body := append(append(append([]byte("AVI "), hdrl...), movi...), idx1...)
+	file := chunk("RIFF", body)
+
+	chunks, err := Chunks(bytes.NewReader(file))
+	if err != nil {
+		t.Fatalf("Chunks: %v", err)
+	}
+	want := []Chunk{
+		{"LIST", "hdrl", 12, int64(len(hdrl))},
+		{"LIST", "movi", 12 + int64(len(hdrl)), int64(len(movi))},
+		{"idx1", "", 12 + int64(len(hdrl)+len(movi)), int64(len(idx1))},
+	}
+	if len(chunks) != len(want) {
+		t.Fatalf("Chunks = %+v, want %+v", chunks, want)
+	}
+	for i := range want {
+		if chunks[i] != want[i] {
+			t.Errorf("chunk %d = %+v, want %+v", i, chunks[i], want[i])
+		}
+	}
+
+	// Cut off in the middle of movi: what's there is still listed.
+	chunks, err = Chunks(bytes.NewReader(file[:12+len(hdrl)+len(movi)-2]))
+	if err != nil || len(chunks) != 2 {
+		t.Errorf("truncated: Chunks = %+v, %v; want hdrl and movi", chunks, err)
+	}
+}
+
+func TestChunks_NotAVI(t *testing.T) {
+	for _, file := range [][]byte{nil, []byte("RIFF\x04\x00\x00\x00WAVE"), []byte("\x1aE\xdf\xa3 matroska")} {
+		if _, err := Chunks(bytes.NewReader(file)); !errors.Is(err, ErrNotAVI) {
+			t.Errorf("Chunks(%q) error = %v, want ErrNotAVI", file, err)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+// Package config reads the optional user config file,
+// go-watch-something/config.json under the user's config dir
+// (~/.config on Linux). It holds what doesn't fit a flag -- lists of
+// things, mostly. A missing file is the same as an empty one.
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"go-watch-something/internal/player"
+	"go-watch-something/internal/subtitles"
+)
+
+// Config is the config file's contents.
+type Config struct {
+	// SubtitlePlugins are subtitle provider executables to run besides
+	// any gws-subs-* found on PATH (see subtitles.Plugin).
+	SubtitlePlugins []string `json:"subtitle_plugins,omitempty"`
+
+	// Subliminal holds subliminal's provider, credential and scoring
+	// options.
+	Subliminal subtitles.Subliminal `json:"subliminal"`
+
+	// Players are extra player profiles for -player, by name; a name
+	// that's also built in replaces the built-in one.
+	Players map[string]player.Profile `json:"players,omitempty"`
+}
+
+// DefaultPath is where the config file is looked for unless -config
+// says otherwise.
+func DefaultPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "go-watch-something", "config.json"), nil
+}
+
+// Load reads the config file at path. A file that doesn't exist gives
+// a zero Config and no error; one that doesn't parse is an error, so a
+// typo doesn't silently drop settings.
+func Load(path string) (Config, error) {
+	var c Config
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return c, nil
+	}
+	if err != nil {
+		return c, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&c); err != nil {
+		return c, fmt.Errorf("config %s: %w", path, err)
+	}
+	return c, nil
+}
@@ -0,0 +1,52 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"go-watch-something/internal/player"
+	"go-watch-something/internal/subtitles"
+)
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	cases := []struct {
+		name    string
+		path    string
+		want    Config
+		wantErr bool
+	}{
+		{"missing file", filepath.Join(dir, "nope.json"), Config{}, false},
+		{"plugins", write("ok.json", `{"subtitle_plugins": ["/opt/gws-subs-x"]}`), Config{SubtitlePlugins: []string{"/opt/gws-subs-x"}}, false},
+		{"subliminal", write("subliminal.json", `{"subliminal": {"providers": ["podnapisi"], "credentials": {"addic7ed": {"username": "me", "password": "pw"}}, "min_score": 60}}`),
+			Config{Subliminal: subtitles.Subliminal{
+				Providers:   []string{"podnapisi"},
+				Credentials: map[string]subtitles.Credential{"addic7ed": {Username: "me", Password: "pw"}},
+				MinScore:    60,
+			}}, false},
+		{"players", write("players.json", `{"players": {"iina": {"command": "iina-cli", "args": ["--mpv-start={start}", "{url}"]}}}`),
+			Config{Players: map[string]player.Profile{"iina": {Command: "iina-cli", Args: []string{"--mpv-start={start}", "{url}"}}}}, false},
+		{"bad json", write("bad.json", `{"subtitle_plugins": [`), Config{}, true},
+		{"unknown field", write("typo.json", `{"subtitle_plugin": []}`), Config{}, true},
+	}
+	for _, c := range cases {
+		got, err := Load(c.path)
+		if (err != nil) != c.wantErr {
+			t.Errorf("%s: err = %v, wantErr %v", c.name, err, c.wantErr)
+			continue
+		}
+		if !c.wantErr && !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: Load = %+v, want %+v", c.name, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+// Package history remembers what was watched and how far, so a stream
+// can be picked up again where it was left.
+package history
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrNotFound is returned by Find when no entry matches.
+var ErrNotFound = errors.New("history: no such entry")
+
+// Entry is one watched file: a torrent's info-hash and the file picked
+// in it, with where playback got to.
+type Entry struct {
+	InfoHash string `json:"info_hash"`
+	Name     string `json:"name"`   // the magnet's display name
+	Magnet   string `json:"magnet"` // as given, without the trackers added to it
+	File     string `json:"file"`   // path within the torrent
+	Length   int64  `json:"length"`
+
+	// Position and Duration come from the player, when it can be asked
+	// (mpv, VLC or Kodi); otherwise Duration is the container's, or a
+	// guess. Offset is how far into the file the last /movie read got,
+	// which any player leaves behind -- a little ahead of the picture,
+	// by whatever the player buffers.
+	Position time.Duration `json:"position,omitempty"`
+	Duration time.Duration `json:"duration,omitempty"`
+	Offset   int64         `json:"offset,omitempty"`
+
+	// SubLangs are the languages fetched with -subs, nil without it;
+	// SubFiles the -sub-file paths given.
+	SubLangs []string `json:"sub_langs,omitempty"`
+	SubFiles []string `json:"sub_files,omitempty"`
+
+	Started time.Time `json:"started"` // first watched
+	Updated time.Time `json:"updated"` // last watched
+}
+
+// ResumeAt is where to pick playback up again: the player's position
+// if it reported one, otherwise the read offset scaled by the duration
+// (a constant bitrate guess), otherwise 0.
+func (e Entry) ResumeAt() time.Duration {
+	switch {
+	case e.Position > 0:
+		return e.Position
+	case e.Offset > 0 && e.Length > 0 && e.Duration > 0:
+		return time.Duration(float64(e.Duration) * float64(e.Offset) / float64(e.Length)).Round(time.Second)
+	}
+	return 0
+}
+
+// Store is the history file: a JSON list of entries, most recently
+// watched first.
+type Store struct {
+	Path string
+}
+
+// DefaultPath is go-watch-something/history.json under the XDG data
+// dir ($XDG_DATA_HOME, or ~/.local/share).
+func DefaultPath() (string, error) {
+	dir := os.Getenv("XDG_DATA_HOME")
+	if dir == "" || !filepath.IsAbs(dir) {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		dir = filepath.Join(home, ".local", "share")
+	}
+	return filepath.Join(dir, "go-watch-something", "history.json"), nil
+}
+
+// Entries lists the history, most recently watched first. A missing
+// file is an empty history.
+func (s Store) Entries() ([]Entry, error) {
+	data, err := os.ReadFile(s.Path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var entries []Entry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("history: reading %s: %w", s.Path, err)
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Updated.After(entries[j].Updated) })
+	return entries, nil
+}
+
+// Save records e, replacing the entry for the same info-hash and file
+// (whose Started it keeps, unless e has one), and stamps it updated
+// now. The file is re-read first, so sessions running side by side
+// don't drop each other's entries.
+func (s Store) Save(e Entry) error {
+	entries, err := s.Entries()
+	if err != nil {
+		return err
+	}
+	e.Updated = time.Now()
+	kept := []Entry{e}
+	for _, old := range entries {
+		if strings.EqualFold(old.InfoHash, e.InfoHash) && old.File == e.File {
+			if e.Started.IsZero() {
+				e.Started = old.Started
+			}
+			continue
+		}
+		kept = append(kept, old)
+	}
+	if e.Started.IsZero() {
+		e.Started = e.Updated
+	}
+	kept[0] = e
+	return s.write(kept)
+}
+
+// Find looks an entry up by ref: its number in Entries' order counting
+// from 1 ("" is 1, the last watched), an info-hash prefix, or a piece
+// of its name or file name.
+func (s Store) Find(ref string) (Entry, error) {
+	entries, err := s.Entries()
+	if err != nil {
+		return Entry{}, err
+	}
+	if ref == "" {
+		ref = "1"
+	}
+	if n, err := strconv.Atoi(ref); err == nil {
+		if n < 1 || n > len(entries) {
+			return Entry{}, fmt.Errorf("%w: %d (there are %d)", ErrNotFound, n, len(entries))
+		}
+		return entries[n-1], nil
+	}
+	lower := strings.ToLower(ref)
+	for _, e := range entries {
+		if strings.HasPrefix(strings.ToLower(e.InfoHash), lower) {
+			return e, nil
+		}
+	}
+	for _, e := range entries {
+		if strings.Contains(strings.ToLower(e.Name), lower) || strings.Contains(strings.ToLower(e.File), lower) {
+			return e, nil
+		}
+	}
+	return Entry{}, fmt.Errorf("%w: %q", ErrNotFound, ref)
+}
+
+// Clear empties the history.
+func (s Store) Clear() error {
+	err := os.Remove(s.Path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+func (s Store) write(entries []Entry) error {
+	if err := os.MkdirAll(filepath.Dir(s.Path), 0o755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	// A temp file per writer: two sessions saving at once each rename
+	// a whole file into place.
+	tmp, err := os.CreateTemp(filepath.Dir(s.Path), "history-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.Path)
+}
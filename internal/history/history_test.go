@@ -0,0 +1,128 @@
+package history
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStore_SaveReplacesAndOrders(t *testing.T) {
+	s := Store{Path: filepath.Join(t.TempDir(), "sub", "history.json")}
+	if entries, err := s.Entries(); err != nil || len(entries) != 0 {
+		t.Fatalf("Entries of a missing file = %v, %v; want none", entries, err)
+	}
+
+	for _, e := range []Entry{
+		{InfoHash: "aaaa", Name: "Film", File: "film.mkv", Position: time.Minute},
+		{InfoHash: "bbbb", Name: "Show S01", File: "s01e01.mkv"},
+		{InfoHash: "bbbb", Name: "Show S01", File: "s01e02.mkv"},
+		{InfoHash: "AAAA", Name: "Film", File: "film.mkv", Position: time.Hour},
+	} {
+		if err := s.Save(e); err != nil {
+			t.Fatalf("Save: %v", err)
+		}
+		time.Sleep(time.Millisecond) // distinct Updated stamps
+	}
+
+	entries, err := s.Entries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, e := range entries {
+		got = append(got, e.File)
+	}
+	want := []string{"film.mkv", "s01e02.mkv", "s01e01.mkv"}
+	if len(got) != len(want) {
+		t.Fatalf("entries = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("entries = %v, want %v", got, want)
+		}
+	}
+	if entries[0].Position != time.Hour {
+		t.Errorf("film position = %v, want the latest save's 1h", entries[0].Position)
+	}
+	if entries[0].Started.IsZero() || !entries[0].Started.Before(entries[1].Started) {
+		t.Errorf("film started %v, want its first save's time, before the show's %v", entries[0].Started, entries[1].Started)
+	}
+	if tmps, _ := filepath.Glob(filepath.Join(filepath.Dir(s.Path), "*.tmp")); len(tmps) != 0 {
+		t.Errorf("temp files left behind: %v", tmps)
+	}
+}
+
+func TestStore_Find(t *testing.T) {
+	s := Store{Path: filepath.Join(t.TempDir(), "history.json")}
+	s.Save(Entry{InfoHash: "c0ffee", Name: "Some.Film.2019.1080p", File: "film.mkv"})
+	time.Sleep(time.Millisecond)
+	s.Save(Entry{InfoHash: "deadbeef", Name: "Show.S01", File: "Show/s01e03.mkv"})
+
+	cases := []struct {
+		ref, want string
+	}{
+		{"", "deadbeef"},
+		{"1", "deadbeef"},
+		{"2", "c0ffee"},
+		{"C0F", "c0ffee"},
+		{"some.film", "c0ffee"},
+		{"s01e03", "deadbeef"},
+	}
+	for _, c := range cases {
+		e, err := s.Find(c.ref)
+		if err != nil || e.InfoHash != c.want {
+			t.Errorf("Find(%q) = %q, %v; want %q", c.ref, e.InfoHash, err, c.want)
+		}
+	}
+	for _, ref := range []string{"0", "3", "nothing"} {
+		if _, err := s.Find(ref); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Find(%q) error = %v, want ErrNotFound", ref, err)
+		}
+	}
+}
+
+func TestEntry_ResumeAt(t *testing.T) {
+	cases := []struct {
+		name string
+		e    Entry
+		want time.Duration
+	}{
+		{"player position", Entry{Position: 42 * time.Minute, Offset: 10, Length: 100, Duration: time.Hour}, 42 * time.Minute},
+		{"offset over duration", Entry{Offset: 25, Length: 100, Duration: time.Hour}, 15 * time.Minute},
+		{"offset without duration", Entry{Offset: 25, Length: 100}, 0},
+		{"nothing", Entry{}, 0},
+	}
+	for _, c := range cases {
+		if got := c.e.ResumeAt(); got != c.want {
+			t.Errorf("%s: ResumeAt = %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestDefaultPath_UsesXDGDataHome(t *testing.T) {
+	t.Setenv("XDG_DATA_HOME", "/data")
+	if p, err := DefaultPath(); err != nil || p != "/data/go-watch-something/history.json" {
+		t.Errorf("DefaultPath = %q, %v", p, err)
+	}
+	t.Setenv("XDG_DATA_HOME", "relative")
+	t.Setenv("HOME", "/home/u")
+	if p, err := DefaultPath(); err != nil || p != "/home/u/.local/share/go-watch-something/history.json" {
+		t.Errorf("DefaultPath with a relative XDG_DATA_HOME = %q, %v", p, err)
+	}
+}
+
+func TestStore_Clear(t *testing.T) {
+	s := Store{Path: filepath.Join(t.TempDir(), "history.json")}
+	if err := s.Clear(); err != nil {
+		t.Errorf("Clear of a missing file: %v", err)
+	}
+	s.Save(Entry{InfoHash: "a"})
+	if err := s.Clear(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(s.Path); !os.IsNotExist(err) {
+		t.Errorf("history file still there after Clear: %v", err)
+	}
+}
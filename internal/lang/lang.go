@@ -0,0 +1,341 @@
+// Package lang normalizes the subtitle languages users ask for into
+// BCP-47 tags ("en", "pt-BR", "zh-TW") -- whatever form they were
+// given in: ISO 639-1 or 639-2 codes ("por", "fre"), POSIX locales
+// ("pt_BR"), English names ("Portuguese") and a few common aliases
+// ("pob", "farsi") -- and maps tags onto the codes each provider
+// expects.
+package lang
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// ErrUnknown is returned for a language that isn't in the table.
+var ErrUnknown = errors.New("unknown language")
+
+// language is one row of the table: ISO 639-1 (empty when there's
+// none), 639-2/B and 639-2/T codes (the same for most), and its
+// English name.
+type language struct {
+	alpha2, alpha3B, alpha3T, name string
+}
+
+// languages covers what subtitle providers actually carry; extend as
+// needed.
+var languages = []language{
+	{"af", "afr", "afr", "Afrikaans"},
+	{"ar", "ara", "ara", "Arabic"},
+	{"az", "aze", "aze", "Azerbaijani"},
+	{"be", "bel", "bel", "Belarusian"},
+	{"bg", "bul", "bul", "Bulgarian"},
+	{"bn", "ben", "ben", "Bengali"},
+	{"bs", "bos", "bos", "Bosnian"},
+	{"ca", "cat", "cat", "Catalan"},
+	{"cs", "cze", "ces", "Czech"},
+	{"cy", "wel", "cym", "Welsh"},
+	{"da", "dan", "dan", "Danish"},
+	{"de", "ger", "deu", "German"},
+	{"el", "gre", "ell", "Greek"},
+	{"en", "eng", "eng", "English"},
+	{"eo", "epo", "epo", "Esperanto"},
+	{"es", "spa", "spa", "Spanish"},
+	{"et", "est", "est", "Estonian"},
+	{"eu", "baq", "eus", "Basque"},
+	{"fa", "per", "fas", "Persian"},
+	{"fi", "fin", "fin", "Finnish"},
+	{"", "fil", "fil", "Filipino"},
+	{"fr", "fre", "fra", "French"},
+	{"ga", "gle", "gle", "Irish"},
+	{"gl", "glg", "glg", "Galician"},
+	{"he", "heb", "heb", "Hebrew"},
+	{"hi", "hin", "hin", "Hindi"},
+	{"hr", "hrv", "hrv", "Croatian"},
+	{"hu", "hun", "hun", "Hungarian"},
+	{"hy", "arm", "hye", "Armenian"},
+	{"id", "ind", "ind", "Indonesian"},
+	{"is", "ice", "isl", "Icelandic"},
+	{"it", "ita", "ita", "Italian"},
+	{"ja", "jpn", "jpn", "Japanese"},
+	{"ka", "geo", "kat", "Georgian"},
+	{"kk", "kaz", "kaz", "Kazakh"},
+	{"km", "khm", "khm", "Khmer"},
+	{"kn", "kan", "kan", "Kannada"},
+	{"ko", "kor", "kor", "Korean"},
+	{"la", "lat", "lat", "Latin"},
+	{"lt", "lit", "lit", "Lithuanian"},
+	{"lv", "lav", "lav", "Latvian"},
+	{"mk", "mac", "mkd", "Macedonian"},
+	{"ml", "mal", "mal", "Malayalam"},
+	{"mn", "mon", "mon", "Mongolian"},
+	{"mr", "mar", "mar", "Marathi"},
+	{"ms", "may", "msa", "Malay"},
+	{"my", "bur", "mya", "Burmese"},
+	{"nb", "nob", "nob", "Norwegian Bokmal"},
+	{"ne", "nep", "nep", "Nepali"},
+	{"nl", "dut", "nld", "Dutch"},
+	{"nn", "nno", "nno", "Norwegian Nynorsk"},
+	{"no", "nor", "nor", "Norwegian"},
+	{"pa", "pan", "pan", "Punjabi"},
+	{"pl", "pol", "pol", "Polish"},
+	{"pt", "por", "por", "Portuguese"},
+	{"ro", "rum", "ron", "Romanian"},
+	{"ru", "rus", "rus", "Russian"},
+	{"si", "sin", "sin", "Sinhala"},
+	{"sk", "slo", "slk", "Slovak"},
+	{"sl", "slv", "slv", "Slovenian"},
+	{"sq", "alb", "sqi", "Albanian"},
+	{"sr", "srp", "srp", "Serbian"},
+	{"sv", "swe", "swe", "Swedish"},
+	{"sw", "swa", "swa", "Swahili"},
+	{"ta", "tam", "tam", "Tamil"},
+	{"te", "tel", "tel", "Telugu"},
+	{"th", "tha", "tha", "Thai"},
+	{"tl", "tgl", "tgl", "Tagalog"},
+	{"tr", "tur", "tur", "Turkish"},
+	{"uk", "ukr", "ukr", "Ukrainian"},
+	{"ur", "urd", "urd", "Urdu"},
+	{"uz", "uzb", "uzb", "Uzbek"},
+	{"vi", "vie", "vie", "Vietnamese"},
+	{"zh", "chi", "zho", "Chinese"},
+}
+
+// aliases are names and codes people (and providers) use for a
+// language, or a regional variant of one, that the table can't
+// derive. Keys are lowercase.
+var aliases = map[string]string{
+	"pob":                      "pt-BR", // OpenSubtitles' legacy code
+	"pb":                       "pt-BR",
+	"brazilian":                "pt-BR",
+	"brazilian portuguese":     "pt-BR",
+	"portuguese (brazil)":      "pt-BR",
+	"european portuguese":      "pt-PT",
+	"farsi":                    "fa",
+	"ea":                       "es-419", // OpenSubtitles' Spanish (Latin America)
+	"spl":                      "es-419",
+	"latin american spanish":   "es-419",
+	"spanish (latin america)":  "es-419",
+	"zht":                      "zh-TW",
+	"traditional chinese":      "zh-TW",
+	"chinese (traditional)":    "zh-TW",
+	"simplified chinese":       "zh-CN",
+	"chinese (simplified)":     "zh-CN",
+	"scc":                      "sr", // retired 639-2 code
+	"norwegian bokmål":         "nb",
+	"portuguese (portugal)":    "pt-PT",
+	"spanish (spain)":          "es-ES",
+	"french (canada)":          "fr-CA",
+	"canadian french":          "fr-CA",
+	"english (united kingdom)": "en-GB",
+}
+
+// byCode indexes the table by every code and lowercase name.
+var byCode = func() map[string]language {
+	m := make(map[string]language)
+	for _, l := range languages {
+		for _, k := range []string{l.alpha2, l.alpha3B, l.alpha3T, strings.ToLower(l.name)} {
+			if k != "" {
+				m[k] = l
+			}
+		}
+	}
+	return m
+}()
+
+// Normalize turns s into a canonical BCP-47 tag: the primary language
+// as ISO 639-1 where it has a code there (639-2/T otherwise),
+// lowercase; a script subtag titlecased ("Hant"); a region uppercased
+// ("BR", or numeric like "419"). Anything the table doesn't know is
+// ErrUnknown.
+func Normalize(s string) (string, error) {
+	s = strings.TrimSpace(s)
+	key := strings.ToLower(s)
+	if tag, ok := aliases[key]; ok {
+		return tag, nil
+	}
+	if l, ok := byCode[key]; ok {
+		return l.code(), nil
+	}
+
+	parts := strings.Split(strings.ReplaceAll(s, "_", "-"), "-")
+	l, ok := byCode[strings.ToLower(parts[0])]
+	if !ok || len(parts[0]) > 3 {
+		return "", fmt.Errorf("%w: %q", ErrUnknown, s)
+	}
+	tag := []string{l.code()}
+	for _, p := range parts[1:] {
+		switch {
+		case len(p) == 4 && isAlpha(p):
+			tag = append(tag, strings.ToUpper(p[:1])+strings.ToLower(p[1:]))
+		case len(p) == 2 && isAlpha(p), len(p) == 3 && isDigits(p):
+			tag = append(tag, strings.ToUpper(p))
+		default:
+			return "", fmt.Errorf("%w: %q (bad subtag %q)", ErrUnknown, s, p)
+		}
+	}
+	return strings.Join(tag, "-"), nil
+}
+
+func (l language) code() string {
+	if l.alpha2 != "" {
+		return l.alpha2
+	}
+	return l.alpha3T
+}
+
+// Parse normalizes a comma-separated list, as -sub-langs takes it.
+// Blanks and duplicates are dropped; every unknown entry is reported
+// in one error.
+func Parse(list string) ([]string, error) {
+	var tags, unknown []string
+	seen := make(map[string]bool)
+	for _, s := range strings.Split(list, ",") {
+		if strings.TrimSpace(s) == "" {
+			continue
+		}
+		tag, err := Normalize(s)
+		if err != nil {
+			unknown = append(unknown, strings.TrimSpace(s))
+			continue
+		}
+		if !seen[tag] {
+			seen[tag] = true
+			tags = append(tags, tag)
+		}
+	}
+	if len(unknown) > 0 {
+		return tags, fmt.Errorf("%w: %s", ErrUnknown, strings.Join(unknown, ", "))
+	}
+	if len(tags) == 0 {
+		return nil, errors.New("no languages given")
+	}
+	return tags, nil
+}
+
+// FromEnv is the user's language from the locale environment --
+// $LANGUAGE, $LC_ALL, $LC_MESSAGES then $LANG, as gettext reads them
+// -- or "en" when none is set to a known language (or it's "C"). The
+// locale's country is dropped (see Base): en_US asks for "en", which
+// is how providers tag English, not "en-US".
+func FromEnv() string {
+	for _, name := range []string{"LANGUAGE", "LC_ALL", "LC_MESSAGES", "LANG"} {
+		v := os.Getenv(name)
+		// "pt_BR.UTF-8@euro"; $LANGUAGE can be a list, "pt_BR:en".
+		v, _, _ = strings.Cut(v, ":")
+		v, _, _ = strings.Cut(v, ".")
+		v, _, _ = strings.Cut(v, "@")
+		if v == "" || v == "C" || v == "POSIX" {
+			continue
+		}
+		if tag, err := Normalize(v); err == nil {
+			return Base(tag)
+		}
+	}
+	return "en"
+}
+
+// regional are the languages whose variants providers keep apart.
+var regional = map[string]bool{"pt": true, "zh": true}
+
+// Base is tag reduced to what providers tell apart: the bare language,
+// keeping the region or script only for Portuguese and Chinese
+// ("pt-BR", "zh-Hant"). A tag it can't normalize is returned as is.
+func Base(tag string) string {
+	norm, err := Normalize(tag)
+	if err != nil {
+		return tag
+	}
+	primary, _, _ := strings.Cut(norm, "-")
+	if regional[primary] {
+		return norm
+	}
+	return primary
+}
+
+// Equal reports whether a and b are the same language once normalized
+// ("eng" is "en"); tags that don't normalize are compared as given,
+// ignoring case.
+func Equal(a, b string) bool {
+	return strings.EqualFold(normalizeOrKeep(a), normalizeOrKeep(b))
+}
+
+// Match reports whether a subtitle tagged a serves a request for b:
+// Equal, or the same language with a region or script on only one
+// side -- "en" and "en-US" match, "pt-BR" and "pt-PT" don't.
+func Match(a, b string) bool {
+	a, b = normalizeOrKeep(a), normalizeOrKeep(b)
+	if strings.EqualFold(a, b) {
+		return true
+	}
+	pa, ra, _ := strings.Cut(a, "-")
+	pb, rb, _ := strings.Cut(b, "-")
+	return strings.EqualFold(pa, pb) && (ra == "" || rb == "")
+}
+
+func normalizeOrKeep(s string) string {
+	if tag, err := Normalize(s); err == nil {
+		return tag
+	}
+	return strings.TrimSpace(s)
+}
+
+// OpenSubtitles is tag as the OpenSubtitles API names it: the bare
+// language, except for the variants it keeps apart -- Portuguese is
+// always pt-PT or pt-BR, Chinese zh-CN or zh-TW, and Latin American
+// Spanish is "ea".
+func OpenSubtitles(tag string) string {
+	primary, rest, _ := strings.Cut(tag, "-")
+	switch primary {
+	case "pt":
+		if rest == "BR" {
+			return "pt-BR"
+		}
+		return "pt-PT"
+	case "zh":
+		if rest == "TW" || rest == "HK" || rest == "Hant" {
+			return "zh-TW"
+		}
+		return "zh-CN"
+	case "es":
+		if rest == "419" {
+			return "ea"
+		}
+	}
+	return primary
+}
+
+// Subliminal is tag as subliminal's -l takes it: an IETF tag, which it
+// parses itself, reduced to Base -- its providers tag English as plain
+// English, so asking for en-US finds nothing -- and minus what it
+// can't represent (numeric regions like es-419; it only knows country
+// codes).
+func Subliminal(tag string) string {
+	parts := strings.Split(Base(tag), "-")
+	kept := parts[:1]
+	for _, p := range parts[1:] {
+		if !isDigits(p) {
+			kept = append(kept, p)
+		}
+	}
+	return strings.Join(kept, "-")
+}
+
+func isAlpha(s string) bool {
+	for _, r := range s {
+		if (r < 'a' || r > 'z') && (r < 'A' || r > 'Z') {
+			return false
+		}
+	}
+	return true
+}
+
+func isDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
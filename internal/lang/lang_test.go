@@ -0,0 +1,135 @@
+package lang
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestNormalize(t *testing.T) {
+	cases := map[string]string{
+		"en":                   "en",
+		" EN ":                 "en",
+		"eng":                  "en",
+		"por":                  "pt",
+		"fre":                  "fr",
+		"fra":                  "fr",
+		"fil":                  "fil",
+		"Portuguese":           "pt",
+		"pt_BR":                "pt-BR",
+		"pt-br":                "pt-BR",
+		"por-BR":               "pt-BR",
+		"pob":                  "pt-BR",
+		"Brazilian Portuguese": "pt-BR",
+		"es-419":               "es-419",
+		"zh-hant":              "zh-Hant",
+		"zh_Hant_TW":           "zh-Hant-TW",
+		"farsi":                "fa",
+	}
+	for in, want := range cases {
+		if got, err := Normalize(in); err != nil || got != want {
+			t.Errorf("Normalize(%q) = %q, %v; want %q", in, got, err, want)
+		}
+	}
+
+	for _, in := range []string{"", "xx", "klingon", "english-US", "pt-BRAZIL", "en-1"} {
+		if got, err := Normalize(in); !errors.Is(err, ErrUnknown) {
+			t.Errorf("Normalize(%q) = %q, %v; want ErrUnknown", in, got, err)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	cases := map[string][]string{
+		"en":                  {"en"},
+		"en,pt-BR":            {"en", "pt-BR"},
+		"en,pt-BR,fr":         {"en", "pt-BR", "fr"},
+		" en , por,pt_BR,,en": {"en", "pt", "pt-BR"},
+	}
+	for in, want := range cases {
+		if got, err := Parse(in); err != nil || !reflect.DeepEqual(got, want) {
+			t.Errorf("Parse(%q) = %v, %v; want %v", in, got, err, want)
+		}
+	}
+
+	if _, err := Parse("en,xx,yy"); !errors.Is(err, ErrUnknown) || err.Error() != "unknown language: xx, yy" {
+		t.Errorf("Parse with unknowns = %v, want both reported", err)
+	}
+	if _, err := Parse(" , "); err == nil {
+		t.Error("Parse of nothing = nil error")
+	}
+}
+
+func TestFromEnv(t *testing.T) {
+	cases := []struct {
+		env  map[string]string
+		want string
+	}{
+		{map[string]string{"LANG": "pt_BR.UTF-8"}, "pt-BR"},
+		{map[string]string{"LANG": "de_DE@euro"}, "de"},
+		{map[string]string{"LANG": "en_US.UTF-8"}, "en"},
+		{map[string]string{"LANG": "zh_TW.UTF-8"}, "zh-TW"},
+		{map[string]string{"LANGUAGE": "fr:en", "LANG": "pt_BR.UTF-8"}, "fr"},
+		{map[string]string{"LC_ALL": "C", "LANG": "es_ES.UTF-8"}, "es"},
+		{map[string]string{"LANG": "C.UTF-8"}, "en"},
+		{map[string]string{}, "en"},
+	}
+	for _, c := range cases {
+		for _, name := range []string{"LANGUAGE", "LC_ALL", "LC_MESSAGES", "LANG"} {
+			t.Setenv(name, c.env[name])
+		}
+		if got := FromEnv(); got != c.want {
+			t.Errorf("FromEnv with %v = %q, want %q", c.env, got, c.want)
+		}
+	}
+}
+
+func TestProviderCodes(t *testing.T) {
+	cases := []struct {
+		tag, opensubtitles, subliminal string
+	}{
+		{"en", "en", "en"},
+		{"en-GB", "en", "en"},
+		{"en-US", "en", "en"},
+		{"pt", "pt-PT", "pt"},
+		{"pt-BR", "pt-BR", "pt-BR"},
+		{"zh", "zh-CN", "zh"},
+		{"zh-Hant", "zh-TW", "zh-Hant"},
+		{"es-419", "ea", "es"},
+	}
+	for _, c := range cases {
+		if got := OpenSubtitles(c.tag); got != c.opensubtitles {
+			t.Errorf("OpenSubtitles(%q) = %q, want %q", c.tag, got, c.opensubtitles)
+		}
+		if got := Subliminal(c.tag); got != c.subliminal {
+			t.Errorf("Subliminal(%q) = %q, want %q", c.tag, got, c.subliminal)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	cases := []struct {
+		a, b  string
+		match bool
+		equal bool
+	}{
+		{"en", "en", true, true},
+		{"eng", "en", true, true},
+		{"English", "EN", true, true},
+		{"en-US", "en", true, false},
+		{"en", "en-GB", true, false},
+		{"pt-BR", "pob", true, true},
+		{"pt-BR", "pt-PT", false, false},
+		{"pt-BR", "pt", true, false},
+		{"en", "fr", false, false},
+		{"klingon", "KLINGON", true, true},
+	}
+	for _, c := range cases {
+		if got := Match(c.a, c.b); got != c.match {
+			t.Errorf("Match(%q, %q) = %v, want %v", c.a, c.b, got, c.match)
+		}
+		if got := Equal(c.a, c.b); got != c.equal {
+			t.Errorf("Equal(%q, %q) = %v, want %v", c.a, c.b, got, c.equal)
+		}
+	}
+}
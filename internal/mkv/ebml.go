@@ -0,0 +1,213 @@
+package mkv
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Element IDs, kept with their VINT marker bits as the Matroska spec
+// writes them.
+const (
+	idEBML                = 0x1A45DFA3
+	idSegment             = 0x18538067
+	idSeekHead            = 0x114D9B74
+	idInfo                = 0x1549A966
+	idTimecodeScale       = 0x2AD7B1
+	idTracks              = 0x1654AE6B
+	idTrackEntry          = 0xAE
+	idTrackNumber         = 0xD7
+	idTrackType           = 0x83
+	idCodecID             = 0x86
+	idCodecPrivate        = 0x63A2
+	idLanguage            = 0x22B59C
+	idLanguageIETF        = 0x22B59D
+	idName                = 0x536E
+	idFlagDefault         = 0x88
+	idFlagForced          = 0x55AA
+	idContentEncodings    = 0x6D80
+	idContentEncoding     = 0x6240
+	idContentCompression  = 0x5034
+	idContentCompAlgo     = 0x4254
+	idContentCompSettings = 0x4255
+	idCluster             = 0x1F43B675
+	idTimecode            = 0xE7
+	idSimpleBlock         = 0xA3
+	idBlockGroup          = 0xA0
+	idBlock               = 0xA1
+	idBlockDuration       = 0x9B
+	idCues                = 0x1C53BB6B
+	idChapters            = 0x1043A770
+	idTags                = 0x1254C367
+	idAttachments         = 0x1941A469
+)
+
+// unknownSize marks an element whose size field is all ones -- allowed
+// for Segment and Cluster in live-muxed files, where the muxer didn't
+// know the size up front.
+const unknownSize = -1
+
+// vintUnknown is what vint returns for an all-ones size.
+const vintUnknown = ^uint64(0)
+
+// maxElementRead caps how much a single element read will allocate, so
+// a corrupt size field can't ask for gigabytes.
+const maxElementRead = 16 << 20
+
+var errTooLarge = errors.New("mkv: element too large")
+
+// isTopLevel reports whether id is a direct child of Segment, which is
+// what ends an unknown-size Cluster.
+func isTopLevel(id uint64) bool {
+	switch id {
+	case idSeekHead, idInfo, idTracks, idCluster, idCues, idChapters, idTags, idAttachments:
+		return true
+	}
+	return false
+}
+
+// reader walks EBML elements over an io.ReadSeeker, tracking the
+// absolute offset itself so callers never have to ask the underlying
+// reader (a torrent.Reader Seek is cheap, but not free).
+type reader struct {
+	r   io.ReadSeeker
+	pos int64
+	buf [8]byte
+}
+
+func (r *reader) readFull(b []byte) error {
+	n, err := io.ReadFull(r.r, b)
+	r.pos += int64(n)
+	return err
+}
+
+// vint reads a variable-length integer. IDs keep their marker bits,
+// sizes don't; an all-ones size comes back as vintUnknown.
+func (r *reader) vint(keepMarker bool) (uint64, error) {
+	if err := r.readFull(r.buf[:1]); err != nil {
+		return 0, err
+	}
+	first := r.buf[0]
+	if first == 0 {
+		return 0, fmt.Errorf("mkv: invalid vint at offset %d", r.pos-1)
+	}
+	length := 1
+	for mask := byte(0x80); first&mask == 0; mask >>= 1 {
+		length++
+	}
+	v := uint64(first)
+	if !keepMarker {
+		v &= uint64(0xFF >> length)
+	}
+	allOnes := v == uint64(0xFF>>length)
+	if length > 1 {
+		if err := r.readFull(r.buf[1:length]); err != nil {
+			return 0, err
+		}
+		for _, b := range r.buf[1:length] {
+			v = v<<8 | uint64(b)
+			allOnes = allOnes && b == 0xFF
+		}
+	}
+	if !keepMarker && allOnes {
+		return vintUnknown, nil
+	}
+	return v, nil
+}
+
+// header reads an element ID and its data size. size is unknownSize
+// (as an int64) for elements written without one.
+func (r *reader) header() (id uint64, size int64, err error) {
+	if id, err = r.vint(true); err != nil {
+		return 0, 0, err
+	}
+	s, err := r.vint(false)
+	if err != nil {
+		return 0, 0, noEOF(err)
+	}
+	if s == vintUnknown {
+		return id, unknownSize, nil
+	}
+	return id, int64(s), nil
+}
+
+func (r *reader) seek(abs int64) error {
+	if _, err := r.r.Seek(abs, io.SeekStart); err != nil {
+		return err
+	}
+	r.pos = abs
+	return nil
+}
+
+func (r *reader) skip(n int64) error {
+	if n < 0 {
+		return fmt.Errorf("mkv: cannot skip element of unknown size at offset %d", r.pos)
+	}
+	return r.seek(r.pos + n)
+}
+
+func (r *reader) bytes(n int64) ([]byte, error) {
+	if n < 0 || n > maxElementRead {
+		return nil, errTooLarge
+	}
+	b := make([]byte, n)
+	return b, noEOF(r.readFull(b))
+}
+
+// uint reads an n-byte big-endian unsigned integer element body.
+func (r *reader) uint(n int64) (uint64, error) {
+	if n > 8 {
+		return 0, fmt.Errorf("mkv: %d-byte unsigned integer at offset %d", n, r.pos)
+	}
+	b := r.buf[:n]
+	if err := r.readFull(b); err != nil {
+		return 0, noEOF(err)
+	}
+	var v uint64
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+	return v, nil
+}
+
+// int16 reads the signed relative timecode in a block header.
+func (r *reader) int16() (int16, error) {
+	if err := r.readFull(r.buf[:2]); err != nil {
+		return 0, noEOF(err)
+	}
+	return int16(binary.BigEndian.Uint16(r.buf[:2])), nil
+}
+
+// segment positions r at the first child of the Segment element and
+// returns the offset its data ends at (unknownSize if unknown).
+func (r *reader) segment() (end int64, err error) {
+	for {
+		id, size, err := r.header()
+		if err != nil {
+			return 0, fmt.Errorf("mkv: reading header: %w", noEOF(err))
+		}
+		switch id {
+		case idEBML:
+			if err := r.skip(size); err != nil {
+				return 0, err
+			}
+		case idSegment:
+			if size == unknownSize {
+				return unknownSize, nil
+			}
+			return r.pos + size, nil
+		default:
+			return 0, ErrNotMatroska
+		}
+	}
+}
+
+// noEOF turns a bare io.EOF in the middle of an element into
+// io.ErrUnexpectedEOF, so it isn't mistaken for a clean end of file.
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,373 @@
+package mkv
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"sort"
+	"time"
+)
+
+// More element IDs, for the seek index.
+const (
+	idSeek                = 0x4DBB
+	idSeekID              = 0x53AB
+	idSeekPosition        = 0x53AC
+	idDuration            = 0x4489
+	idCuePoint            = 0xBB
+	idCueTime             = 0xB3
+	idCueTrackPositions   = 0xB7
+	idCueTrack            = 0xF7
+	idCueClusterPosition  = 0xF1
+	idCueRelativePosition = 0xF0
+)
+
+// ErrNoCues is returned by ReadIndex for a file without Cues -- some
+// muxers leave them out, and live-muxed files can't have them.
+var ErrNoCues = errors.New("mkv: no cues")
+
+// Index is a file's seek index: where in the file playback times are.
+type Index struct {
+	Duration time.Duration // from Info, 0 if the muxer didn't say
+	Cues     []CuePoint    // by Time
+}
+
+// CuePoint is one Cues entry: the cluster holding the keyframe at Time
+// starts Offset bytes into the file.
+type CuePoint struct {
+	Time   time.Duration
+	Offset int64
+}
+
+// ReadIndex reads the Info and Cues elements. Cues usually sit after
+// the clusters, at the end of the file; the SeekHead at the front says
+// where, so only the pieces holding the header and the cues are read.
+// Duration is filled in even when it fails with ErrNoCues.
+func ReadIndex(rs io.ReadSeeker) (*Index, error) {
+	r := &reader{r: rs}
+	h, err := scanHeader(r)
+	if err != nil {
+		return nil, err
+	}
+	ix := &Index{Duration: time.Duration(h.duration * float64(h.scale))}
+	size, err := h.seekCues(r)
+	if err != nil {
+		return ix, err
+	}
+	data, err := r.bytes(size)
+	if err != nil {
+		return ix, fmt.Errorf("mkv: reading cues: %w", err)
+	}
+	if ix.Cues, err = parseCues(data, h.scale, h.segStart); err != nil {
+		return ix, err
+	}
+	if len(ix.Cues) == 0 {
+		return ix, ErrNoCues
+	}
+	return ix, nil
+}
+
+// Span is a stretch of the file: Size bytes from Offset.
+type Span struct {
+	Offset, Size int64
+}
+
+// IndexSpans finds what a player reads before it can start or seek:
+// the header (everything before the first cluster) and the Cues. Only
+// element headers are read, so the pieces can be fetched before anyone
+// waits on them. The header is filled in even when it fails with
+// ErrNoCues.
+func IndexSpans(rs io.ReadSeeker) (header, cues Span, err error) {
+	r := &reader{r: rs}
+	h, err := scanHeader(r)
+	if err != nil {
+		return header, cues, err
+	}
+	header = Span{0, h.end}
+	size, err := h.seekCues(r)
+	if err != nil {
+		return header, cues, err
+	}
+	return header, Span{h.cuesAt, r.pos - h.cuesAt + size}, nil
+}
+
+// header is what scanHeader finds out.
+type header struct {
+	segStart int64 // positions in SeekHead and Cues count from here
+	segEnd   int64 // unknownSize if the segment doesn't say
+	end      int64 // where the first cluster (or the Cues) starts
+	scale    int64
+	duration float64 // in scale units
+	cuesAt   int64   // -1 if unknown
+}
+
+// scanHeader reads the segment's header: everything up to the first
+// cluster.
+func scanHeader(r *reader) (header, error) {
+	end, err := r.segment()
+	if err != nil {
+		return header{}, err
+	}
+	h := header{segStart: r.pos, segEnd: end, scale: defaultTimecodeScale, cuesAt: -1}
+	for end == unknownSize || r.pos < end {
+		start := r.pos
+		id, size, err := r.header()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return h, err
+		}
+		switch id {
+		case idInfo:
+			data, err := r.bytes(size)
+			if err != nil {
+				return h, err
+			}
+			if err := walk(data, func(r *reader, id uint64, size int64) error {
+				switch id {
+				case idTimecodeScale:
+					v, err := r.uint(size)
+					if v > 0 {
+						h.scale = int64(v)
+					}
+					return err
+				case idDuration:
+					h.duration, err = r.float(size)
+					return err
+				}
+				return r.skip(size)
+			}); err != nil {
+				return h, err
+			}
+		case idSeekHead:
+			data, err := r.bytes(size)
+			if err != nil {
+				return h, err
+			}
+			pos, ok, err := seekPosition(data, idCues)
+			if err != nil {
+				return h, err
+			}
+			if ok {
+				h.cuesAt = h.segStart + pos
+			}
+		case idCues:
+			h.cuesAt, h.end = start, start
+			return h, nil
+		case idCluster:
+			h.end = start
+			return h, nil
+		default:
+			if err := r.skip(size); err != nil {
+				return h, err
+			}
+		}
+	}
+	h.end = r.pos
+	return h, nil
+}
+
+// seekCues moves r to the body of the Cues element, returning its
+// size.
+func (h header) seekCues(r *reader) (int64, error) {
+	if h.cuesAt < 0 {
+		return 0, ErrNoCues
+	}
+	if err := r.seek(h.cuesAt); err != nil {
+		return 0, err
+	}
+	id, size, err := r.header()
+	if err != nil {
+		return 0, fmt.Errorf("mkv: reading cues: %w", noEOF(err))
+	}
+	if id != idCues {
+		return 0, fmt.Errorf("mkv: SeekHead points at %x, not Cues", id)
+	}
+	return size, nil
+}
+
+// Offset is where to start reading to play from at: the cluster of the
+// last cue at or before it (the first one if at is before them all).
+func (ix *Index) Offset(at time.Duration) int64 {
+	if len(ix.Cues) == 0 {
+		return 0
+	}
+	i := sort.Search(len(ix.Cues), func(i int) bool { return ix.Cues[i].Time > at })
+	return ix.Cues[max(i-1, 0)].Offset
+}
+
+// seekPosition finds the SeekHead entry for element id.
+func seekPosition(data []byte, id uint64) (pos int64, ok bool, err error) {
+	err = walk(data, func(r *reader, eid uint64, size int64) error {
+		if eid != idSeek {
+			return r.skip(size)
+		}
+		body, err := r.bytes(size)
+		if err != nil {
+			return err
+		}
+		var target []byte
+		var p uint64
+		if err := walk(body, func(r *reader, eid uint64, size int64) error {
+			switch eid {
+			case idSeekID:
+				target, err = r.bytes(size)
+				return err
+			case idSeekPosition:
+				p, err = r.uint(size)
+				return err
+			}
+			return r.skip(size)
+		}); err != nil {
+			return err
+		}
+		if bytes.Equal(target, encodeID(id)) && !ok {
+			pos, ok = int64(p), true
+		}
+		return nil
+	})
+	return pos, ok, err
+}
+
+// parseCues reads CuePoints, taking each one's first track position.
+func parseCues(data []byte, scale, segStart int64) ([]CuePoint, error) {
+	var cues []CuePoint
+	err := walk(data, func(r *reader, id uint64, size int64) error {
+		if id != idCuePoint {
+			return r.skip(size)
+		}
+		body, err := r.bytes(size)
+		if err != nil {
+			return err
+		}
+		var t uint64
+		cluster := int64(-1)
+		if err := walk(body, func(r *reader, id uint64, size int64) error {
+			switch id {
+			case idCueTime:
+				t, err = r.uint(size)
+				return err
+			case idCueTrackPositions:
+				pos, err := r.bytes(size)
+				if err != nil || cluster >= 0 {
+					return err
+				}
+				return walk(pos, func(r *reader, id uint64, size int64) error {
+					if id != idCueClusterPosition {
+						return r.skip(size)
+					}
+					v, err := r.uint(size)
+					cluster = int64(v)
+					return err
+				})
+			}
+			return r.skip(size)
+		}); err != nil {
+			return err
+		}
+		if cluster >= 0 {
+			cues = append(cues, CuePoint{Time: time.Duration(int64(t) * scale), Offset: segStart + cluster})
+		}
+		return nil
+	})
+	sort.SliceStable(cues, func(i, j int) bool { return cues[i].Time < cues[j].Time })
+	return cues, err
+}
+
+// blockRef is where the Cues say one of a track's blocks is: in the
+// cluster at cluster (an absolute offset), relative bytes into its
+// body -- or -1 if the muxer didn't say, leaving the whole cluster to
+// search.
+type blockRef struct {
+	cluster, relative int64
+}
+
+// parseBlockRefs reads the Cues' positions for track, in file order.
+// mkvmerge indexes every block of a subtitle track, so for its files
+// these are all of them; most other muxers only index video.
+func parseBlockRefs(data []byte, track uint64, segStart int64) ([]blockRef, error) {
+	var refs []blockRef
+	err := walk(data, func(r *reader, id uint64, size int64) error {
+		if id != idCuePoint {
+			return r.skip(size)
+		}
+		body, err := r.bytes(size)
+		if err != nil {
+			return err
+		}
+		return walk(body, func(r *reader, id uint64, size int64) error {
+			if id != idCueTrackPositions {
+				return r.skip(size)
+			}
+			pos, err := r.bytes(size)
+			if err != nil {
+				return err
+			}
+			var t uint64
+			ref := blockRef{cluster: -1, relative: -1}
+			if err := walk(pos, func(r *reader, id uint64, size int64) error {
+				var v uint64
+				var err error
+				switch id {
+				case idCueTrack:
+					t, err = r.uint(size)
+				case idCueClusterPosition:
+					v, err = r.uint(size)
+					ref.cluster = segStart + int64(v)
+				case idCueRelativePosition:
+					v, err = r.uint(size)
+					ref.relative = int64(v)
+				default:
+					err = r.skip(size)
+				}
+				return err
+			}); err != nil {
+				return err
+			}
+			if t == track && ref.cluster >= 0 {
+				refs = append(refs, ref)
+			}
+			return nil
+		})
+	})
+	sort.Slice(refs, func(i, j int) bool {
+		if refs[i].cluster != refs[j].cluster {
+			return refs[i].cluster < refs[j].cluster
+		}
+		return refs[i].relative < refs[j].relative
+	})
+	return slices.Compact(refs), err
+}
+
+// encodeID is id as it's written in the file.
+func encodeID(id uint64) []byte {
+	var b []byte
+	for ; id > 0; id >>= 8 {
+		b = append([]byte{byte(id)}, b...)
+	}
+	return b
+}
+
+// float reads a 4- or 8-byte float element body.
+func (r *reader) float(n int64) (float64, error) {
+	switch n {
+	case 0:
+		return 0, nil
+	case 4, 8:
+	default:
+		return 0, fmt.Errorf("mkv: %d-byte float at offset %d", n, r.pos)
+	}
+	b := r.buf[:n]
+	if err := r.readFull(b); err != nil {
+		return 0, noEOF(err)
+	}
+	if n == 4 {
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), nil
+	}
+	return math.Float64frombits(binary.BigEndian.Uint64(b)), nil
+}
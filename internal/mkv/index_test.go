@@ -0,0 +1,206 @@
+package mkv
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func floatEl(id uint64, v float64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, math.Float64bits(v))
+	return el(id, b)
+}
+
+func cuePointEl(t, cluster uint64) []byte {
+	return el(idCuePoint, uintEl(idCueTime, t), el(idCueTrackPositions, uintEl(0xF7, 1), uintEl(idCueClusterPosition, cluster)))
+}
+
+// indexedFile is an MKV with a SeekHead pointing at Cues after two
+// clusters, as muxers write them. It returns the file and the
+// clusters' absolute offsets.
+func indexedFile() ([]byte, [2]int64) {
+	header := el(idEBML, strEl(0x4282, "matroska"))
+	info := el(idInfo, uintEl(idTimecodeScale, 1000000), floatEl(idDuration, 7_200_000))
+	tracks := el(idTracks, el(idTrackEntry, uintEl(idTrackNumber, 1), uintEl(idTrackType, 1)))
+	cluster1 := el(idCluster, uintEl(idTimecode, 0), el(idSimpleBlock, blockBody(1, 0, "frame")))
+	cluster2 := el(idCluster, uintEl(idTimecode, 60_000), el(idSimpleBlock, blockBody(1, 0, "frame")))
+	seekHead := func(cuesPos uint64) []byte {
+		return el(idSeekHead,
+			el(idSeek, el(idSeekID, encodeID(idInfo)), uintEl(idSeekPosition, 0)),
+			el(idSeek, el(idSeekID, encodeID(idCues)), uintEl(idSeekPosition, cuesPos)),
+		)
+	}
+	// Segment data starts after the Segment ID (4 bytes) and size (8).
+	segStart := int64(len(header)) + 12
+	c1 := int64(len(seekHead(0)) + len(info) + len(tracks))
+	c2 := c1 + int64(len(cluster1))
+	cuesPos := c2 + int64(len(cluster2))
+	cues := el(idCues, cuePointEl(60_000, uint64(c2)), cuePointEl(0, uint64(c1)))
+	segment := el(idSegment, seekHead(uint64(cuesPos)), info, tracks, cluster1, cluster2, cues)
+	return append(header, segment...), [2]int64{segStart + c1, segStart + c2}
+}
+
+func TestReadIndex_FollowsSeekHeadToCues(t *testing.T) {
+	file, clusters := indexedFile()
+	ix, err := ReadIndex(bytes.NewReader(file))
+	if err != nil {
+		t.Fatalf("ReadIndex: %v", err)
+	}
+	if ix.Duration != 2*time.Hour {
+		t.Errorf("Duration = %v, want 2h", ix.Duration)
+	}
+	want := []CuePoint{{0, clusters[0]}, {time.Minute, clusters[1]}}
+	if len(ix.Cues) != 2 || ix.Cues[0] != want[0] || ix.Cues[1] != want[1] {
+		t.Fatalf("Cues = %+v, want %+v", ix.Cues, want)
+	}
+	if got := file[clusters[1] : clusters[1]+4]; !bytes.Equal(got, encodeID(idCluster)) {
+		t.Fatalf("cue offset %d doesn't point at a cluster: % x", clusters[1], got)
+	}
+
+	cases := []struct {
+		at   time.Duration
+		want int64
+	}{
+		{0, clusters[0]},
+		{59 * time.Second, clusters[0]},
+		{time.Minute, clusters[1]},
+		{time.Hour, clusters[1]},
+	}
+	for _, c := range cases {
+		if got := ix.Offset(c.at); got != c.want {
+			t.Errorf("Offset(%v) = %d, want %d", c.at, got, c.want)
+		}
+	}
+}
+
+func TestReadIndex_NoCues(t *testing.T) {
+	file := testFile(el(idTracks), el(idCluster, uintEl(idTimecode, 0)))
+	ix, err := ReadIndex(bytes.NewReader(file))
+	if !errors.Is(err, ErrNoCues) {
+		t.Fatalf("ReadIndex error = %v, want ErrNoCues", err)
+	}
+	if ix == nil || ix.Offset(time.Minute) != 0 {
+		t.Errorf("index without cues = %+v", ix)
+	}
+}
+
+func TestIndexSpans(t *testing.T) {
+	file, clusters := indexedFile()
+	header, cues, err := IndexSpans(bytes.NewReader(file))
+	if err != nil {
+		t.Fatalf("IndexSpans: %v", err)
+	}
+	if header != (Span{0, clusters[0]}) {
+		t.Errorf("header = %+v, want everything before the first cluster at %d", header, clusters[0])
+	}
+	// The Cues are the last thing in the file.
+	if cues.Offset+cues.Size != int64(len(file)) || !bytes.HasPrefix(file[cues.Offset:], encodeID(idCues)) {
+		t.Errorf("cues = %+v, want the Cues element ending the %d-byte file", cues, len(file))
+	}
+}
+
+// readLog is a ReadSeeker recording which bytes were read.
+type readLog struct {
+	*bytes.Reader
+	reads []Span
+}
+
+func (l *readLog) Read(b []byte) (int, error) {
+	off, _ := l.Reader.Seek(0, io.SeekCurrent)
+	n, err := l.Reader.Read(b)
+	l.reads = append(l.reads, Span{off, int64(n)})
+	return n, err
+}
+
+func (l *readLog) touched(s Span) bool {
+	for _, r := range l.reads {
+		if r.Offset < s.Offset+s.Size && s.Offset < r.Offset+r.Size {
+			return true
+		}
+	}
+	return false
+}
+
+func TestExtractSubtitles_ReadsOnlyIndexedBlocks(t *testing.T) {
+	header := el(idEBML, strEl(0x4282, "matroska"))
+	info := el(idInfo, uintEl(idTimecodeScale, 1000000))
+	tracks := el(idTracks,
+		el(idTrackEntry, uintEl(idTrackNumber, 1), uintEl(idTrackType, 1)),
+		el(idTrackEntry, uintEl(idTrackNumber, 2), uintEl(idTrackType, TypeSubtitle), strEl(idCodecID, "S_TEXT/UTF8")),
+	)
+	frame := el(idSimpleBlock, blockBody(1, 0, string(make([]byte, 1000))))
+	cluster1 := el(idCluster, uintEl(idTimecode, 0), frame)
+	tc2 := uintEl(idTimecode, 60_000)
+	simple := el(idSimpleBlock, blockBody(2, 500, "Indexed"))
+	group := el(idBlockGroup, el(idBlock, blockBody(2, 1000, "Grouped")), uintEl(idBlockDuration, 2000))
+	cluster2 := el(idCluster, tc2, frame, simple, group)
+	cluster3 := el(idCluster, uintEl(idTimecode, 120_000), frame, el(idSimpleBlock, blockBody(2, 0, "Whole cluster")))
+
+	seekHead := func(cuesPos uint64) []byte {
+		return el(idSeekHead, el(idSeek, el(idSeekID, encodeID(idCues)), uintEl(idSeekPosition, cuesPos)))
+	}
+	c1 := uint64(len(seekHead(0)) + len(info) + len(tracks))
+	c2 := c1 + uint64(len(cluster1))
+	c3 := c2 + uint64(len(cluster2))
+	cuesPos := c3 + uint64(len(cluster3))
+	trackPos := func(track, cluster uint64, relative ...uint64) []byte {
+		body := [][]byte{uintEl(idCueTrack, track), uintEl(idCueClusterPosition, cluster)}
+		for _, r := range relative {
+			body = append(body, uintEl(idCueRelativePosition, r))
+		}
+		return el(idCueTrackPositions, body...)
+	}
+	cues := el(idCues,
+		el(idCuePoint, uintEl(idCueTime, 0), trackPos(1, c1)),
+		el(idCuePoint, uintEl(idCueTime, 60_500), trackPos(2, c2, uint64(len(tc2)+len(frame)))),
+		el(idCuePoint, uintEl(idCueTime, 61_000), trackPos(2, c2, uint64(len(tc2)+len(frame)+len(simple)))),
+		el(idCuePoint, uintEl(idCueTime, 120_000), trackPos(2, c3)),
+	)
+	segment := el(idSegment, seekHead(cuesPos), info, tracks, cluster1, cluster2, cluster3, cues)
+	file := append(header, segment...)
+	segStart := int64(len(header)) + 12
+
+	parsed, err := ReadTracks(bytes.NewReader(file))
+	if err != nil {
+		t.Fatalf("ReadTracks: %v", err)
+	}
+	log := &readLog{Reader: bytes.NewReader(file)}
+	got, err := ExtractSubtitles(log, parsed[1])
+	if err != nil {
+		t.Fatalf("ExtractSubtitles: %v", err)
+	}
+	var texts []string
+	for _, c := range got {
+		texts = append(texts, c.Text)
+	}
+	if want := []string{"Indexed", "Grouped", "Whole cluster"}; !reflect.DeepEqual(texts, want) {
+		t.Errorf("cues = %q, want %q", texts, want)
+	}
+	if got[1].Start != 61*time.Second || got[1].End != 63*time.Second {
+		t.Errorf("grouped cue = %v-%v, want 61s-63s", got[1].Start, got[1].End)
+	}
+	// The first cluster has no subtitles, and the second's video frame
+	// sits between blocks the Cues point straight at.
+	skipped := []Span{
+		{segStart + int64(c1) + 12, int64(len(cluster1)) - 12},
+		{segStart + int64(c2) + 12 + int64(len(tc2)), int64(len(frame))},
+	}
+	for _, s := range skipped {
+		if log.touched(s) {
+			t.Errorf("read %+v, which holds no indexed subtitle", s)
+		}
+	}
+
+	// Cues pointing at nothing sensible: the whole file is walked instead.
+	bad := bytes.Clone(file)
+	copy(bad[segStart+int64(c2):], el(0xEC, make([]byte, len(cluster2)-9))) // a Void where cluster 2 was
+	if got, err := ExtractSubtitles(bytes.NewReader(bad), parsed[1]); err != nil || len(got) != 1 {
+		t.Errorf("ExtractSubtitles with a broken index = %d cues, %v; want the 1 left in cluster 3", len(got), err)
+	}
+}
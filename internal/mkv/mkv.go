@@ -0,0 +1,578 @@
+// Package mkv is a minimal Matroska (EBML) reader: just enough to list
+// a file's tracks and pull text subtitle tracks out of its clusters.
+// Most MKV releases carry their subtitles inside the container rather
+// than as separate files, and browsers can't render those themselves,
+// so the streamer extracts them and serves them from /subs/ instead.
+//
+// It reads through an io.ReadSeeker and skips everything it doesn't
+// need with Seek, so on a torrent.Reader only the pieces holding
+// element headers and subtitle blocks are actually waited on.
+package mkv
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"go-watch-something/internal/subfile"
+)
+
+// ErrNotMatroska is returned when the input doesn't start with an EBML
+// header followed by a Segment.
+var ErrNotMatroska = errors.New("mkv: not a Matroska file")
+
+// ErrNoTracks is returned when no Tracks element turns up before the
+// first Cluster.
+var ErrNoTracks = errors.New("mkv: no track list before the first cluster")
+
+// TypeSubtitle is the TrackType value for subtitle tracks.
+const TypeSubtitle = 0x11
+
+// defaultTimecodeScale is the spec default: timecodes in milliseconds.
+const defaultTimecodeScale = 1000000
+
+// maxMissingDuration bounds how long a cue with no BlockDuration stays
+// on screen when the next cue is further away than that.
+const maxMissingDuration = 5 * time.Second
+
+// Track is one TrackEntry from the Tracks element.
+type Track struct {
+	Number       uint64
+	Type         uint64
+	Codec        string
+	Language     string // BCP 47 tag if present, else the ISO 639-2 code
+	Name         string
+	Default      bool
+	Forced       bool
+	CodecPrivate []byte
+
+	compAlgo     int64 // -1 when the track's blocks are stored as-is
+	compSettings []byte
+}
+
+// IsText reports whether t is a subtitle track in a text format this
+// package can extract. Bitmap formats (PGS, VobSub) aren't.
+func (t Track) IsText() bool {
+	if t.Type != TypeSubtitle {
+		return false
+	}
+	switch t.Codec {
+	case "S_TEXT/UTF8", "S_TEXT/ASCII", "S_TEXT/ASS", "S_TEXT/SSA", "S_TEXT/WEBVTT":
+		return true
+	}
+	return false
+}
+
+// ReadTracks returns every track in the file. Only the header is read:
+// muxers write Tracks ahead of the first Cluster, which is where the
+// scan gives up with ErrNoTracks.
+func ReadTracks(rs io.ReadSeeker) ([]Track, error) {
+	r := &reader{r: rs}
+	end, err := r.segment()
+	if err != nil {
+		return nil, err
+	}
+	for end == unknownSize || r.pos < end {
+		id, size, err := r.header()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch id {
+		case idTracks:
+			data, err := r.bytes(size)
+			if err != nil {
+				return nil, fmt.Errorf("mkv: reading tracks: %w", err)
+			}
+			return parseTracks(data)
+		case idCluster:
+			return nil, ErrNoTracks
+		default:
+			if err := r.skip(size); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return nil, ErrNoTracks
+}
+
+func parseTracks(data []byte) ([]Track, error) {
+	r := &reader{r: bytes.NewReader(data)}
+	var tracks []Track
+	for r.pos < int64(len(data)) {
+		id, size, err := r.header()
+		if err != nil {
+			return nil, noEOF(err)
+		}
+		if id != idTrackEntry {
+			if err := r.skip(size); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		body, err := r.bytes(size)
+		if err != nil {
+			return nil, err
+		}
+		t, err := parseTrackEntry(body)
+		if err != nil {
+			return nil, err
+		}
+		tracks = append(tracks, t)
+	}
+	return tracks, nil
+}
+
+func parseTrackEntry(data []byte) (Track, error) {
+	t := Track{Language: "eng", Default: true, compAlgo: -1}
+	var lang, ietf string
+	r := &reader{r: bytes.NewReader(data)}
+	for r.pos < int64(len(data)) {
+		id, size, err := r.header()
+		if err != nil {
+			return t, noEOF(err)
+		}
+		switch id {
+		case idTrackNumber:
+			t.Number, err = r.uint(size)
+		case idTrackType:
+			t.Type, err = r.uint(size)
+		case idFlagDefault:
+			var v uint64
+			v, err = r.uint(size)
+			t.Default = v != 0
+		case idFlagForced:
+			var v uint64
+			v, err = r.uint(size)
+			t.Forced = v != 0
+		case idCodecID, idLanguage, idLanguageIETF, idName:
+			var b []byte
+			b, err = r.bytes(size)
+			s := strings.TrimRight(string(b), "\x00")
+			switch id {
+			case idCodecID:
+				t.Codec = s
+			case idLanguage:
+				lang = s
+			case idLanguageIETF:
+				ietf = s
+			case idName:
+				t.Name = s
+			}
+		case idCodecPrivate:
+			t.CodecPrivate, err = r.bytes(size)
+		case idContentEncodings:
+			var b []byte
+			if b, err = r.bytes(size); err == nil {
+				err = t.parseEncodings(b)
+			}
+		default:
+			err = r.skip(size)
+		}
+		if err != nil {
+			return t, err
+		}
+	}
+	switch {
+	case ietf != "":
+		t.Language = ietf
+	case lang != "":
+		t.Language = lang
+	}
+	return t, nil
+}
+
+// parseEncodings picks the compression settings out of a
+// ContentEncodings element. mkvmerge has zlib-compressed subtitle
+// tracks by default for years, so this isn't optional in practice.
+// Encryption isn't supported; nobody encrypts subtitles.
+func (t *Track) parseEncodings(data []byte) error {
+	return walk(data, func(r *reader, id uint64, size int64) error {
+		switch id {
+		case idContentEncoding:
+			b, err := r.bytes(size)
+			if err != nil {
+				return err
+			}
+			return t.parseEncodings(b)
+		case idContentCompression:
+			b, err := r.bytes(size)
+			if err != nil {
+				return err
+			}
+			t.compAlgo = 0 // the spec default when ContentCompAlgo is absent
+			return walk(b, func(r *reader, id uint64, size int64) error {
+				switch id {
+				case idContentCompAlgo:
+					v, err := r.uint(size)
+					t.compAlgo = int64(v)
+					return err
+				case idContentCompSettings:
+					var err error
+					t.compSettings, err = r.bytes(size)
+					return err
+				}
+				return r.skip(size)
+			})
+		}
+		return r.skip(size)
+	})
+}
+
+// walk calls fn for each child element in data. fn must consume
+// exactly size bytes.
+func walk(data []byte, fn func(r *reader, id uint64, size int64) error) error {
+	r := &reader{r: bytes.NewReader(data)}
+	for r.pos < int64(len(data)) {
+		id, size, err := r.header()
+		if err != nil {
+			return noEOF(err)
+		}
+		if err := fn(r, id, size); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// decode undoes the track's content compression on a block payload.
+func (t Track) decode(payload []byte) ([]byte, error) {
+	switch t.compAlgo {
+	case -1:
+		return payload, nil
+	case 0:
+		zr, err := zlib.NewReader(bytes.NewReader(payload))
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		return io.ReadAll(io.LimitReader(zr, maxElementRead))
+	case 3: // header stripping: the stripped bytes live in the settings
+		return append(append([]byte{}, t.compSettings...), payload...), nil
+	}
+	return nil, fmt.Errorf("mkv: track %d uses unsupported compression %d", t.Number, t.compAlgo)
+}
+
+// cueText turns a decoded block payload into cue text and alignment
+// for t's codec. styles are the ASS styles from CodecPrivate.
+func (t Track) cueText(payload []byte, styles map[string]subfile.ASSStyle) (string, int) {
+	s := string(payload)
+	switch t.Codec {
+	case "S_TEXT/ASS", "S_TEXT/SSA":
+		// In Matroska, ASS blocks drop the Start/End fields of the
+		// Dialogue line: ReadOrder, Layer, Style, Name, MarginL,
+		// MarginR, MarginV, Effect, Text.
+		fields := strings.SplitN(s, ",", 9)
+		var style subfile.ASSStyle
+		if len(fields) == 9 {
+			style = styles[strings.TrimSpace(fields[2])]
+		}
+		return subfile.ASSCue(fields[len(fields)-1], style)
+	}
+	return strings.TrimSpace(strings.ReplaceAll(s, "\r\n", "\n")), 0
+}
+
+// ExtractSubtitles reads every block belonging to track and returns the
+// resulting cues, sorted by start time. When the Cues index the track's
+// blocks, as mkvmerge's do, only those blocks are read -- on a
+// torrent.Reader, only the pieces holding them are waited for.
+// Otherwise it has to visit every Cluster in the file, and won't return
+// until the pieces holding the whole file's cluster and block headers
+// have arrived.
+func ExtractSubtitles(rs io.ReadSeeker, track Track) ([]subfile.Cue, error) {
+	if !track.IsText() {
+		return nil, fmt.Errorf("mkv: track %d (%s) is not a text subtitle track", track.Number, track.Codec)
+	}
+	x := &extractor{r: &reader{r: rs}, track: track}
+	if track.Codec == "S_TEXT/ASS" || track.Codec == "S_TEXT/SSA" {
+		x.styles = subfile.ParseASSStyles(track.CodecPrivate)
+	}
+	h, err := scanHeader(x.r)
+	if err != nil {
+		return nil, err
+	}
+	x.scale = h.scale
+	if refs := x.indexed(h); len(refs) > 0 {
+		if err := x.blocks(refs); err == nil {
+			return x.finish(), nil
+		}
+		// An index that doesn't hold up: start over the long way.
+		x.cues = nil
+	}
+
+	if err := x.r.seek(h.end); err != nil {
+		return nil, err
+	}
+	for h.segEnd == unknownSize || x.r.pos < h.segEnd {
+		id, size, err := x.r.header()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if id == idCluster {
+			err = x.cluster(size)
+		} else {
+			err = x.r.skip(size)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return x.finish(), nil
+}
+
+// indexed is the Cues' positions for x's track, nil if the file has no
+// Cues or they don't index it.
+func (x *extractor) indexed(h header) []blockRef {
+	size, err := h.seekCues(x.r)
+	if err != nil {
+		return nil
+	}
+	data, err := x.r.bytes(size)
+	if err != nil {
+		return nil
+	}
+	refs, err := parseBlockRefs(data, x.track.Number, h.segStart)
+	if err != nil {
+		return nil
+	}
+	return refs
+}
+
+// blocks reads the blocks refs point at. A ref without a relative
+// position has its whole cluster read, once.
+func (x *extractor) blocks(refs []blockRef) error {
+	whole := map[int64]bool{}
+	for _, ref := range refs {
+		if ref.relative < 0 {
+			whole[ref.cluster] = true
+		}
+	}
+	for _, ref := range refs {
+		if whole[ref.cluster] && ref.relative >= 0 {
+			continue
+		}
+		if err := x.r.seek(ref.cluster); err != nil {
+			return err
+		}
+		id, size, err := x.r.header()
+		if err != nil {
+			return noEOF(err)
+		}
+		if id != idCluster {
+			return fmt.Errorf("mkv: cue points at %x, not a Cluster", id)
+		}
+		if ref.relative < 0 {
+			if err := x.cluster(size); err != nil {
+				return err
+			}
+			continue
+		}
+		body := x.r.pos
+		timecode, err := x.clusterTimecode()
+		if err != nil {
+			return err
+		}
+		if err := x.r.seek(body + ref.relative); err != nil {
+			return err
+		}
+		id, n, err := x.r.header()
+		if err != nil {
+			return noEOF(err)
+		}
+		switch id {
+		case idSimpleBlock:
+			err = x.block(n, timecode, -1)
+		case idBlockGroup:
+			err = x.blockGroup(n, timecode)
+		default:
+			err = fmt.Errorf("mkv: cue points at %x, not a block", id)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// clusterTimecode reads a cluster's Timecode, which comes ahead of its
+// blocks.
+func (x *extractor) clusterTimecode() (int64, error) {
+	for {
+		id, n, err := x.r.header()
+		if err != nil {
+			return 0, noEOF(err)
+		}
+		switch id {
+		case idTimecode:
+			v, err := x.r.uint(n)
+			return int64(v), err
+		case idSimpleBlock, idBlockGroup:
+			return 0, errors.New("mkv: cluster without a Timecode ahead of its blocks")
+		}
+		if err := x.r.skip(n); err != nil {
+			return 0, err
+		}
+	}
+}
+
+type extractor struct {
+	r      *reader
+	track  Track
+	styles map[string]subfile.ASSStyle // for ASS/SSA tracks
+	scale  int64                       // nanoseconds per timecode tick
+	cues   []cue
+}
+
+type cue struct {
+	subfile.Cue
+	hasDuration bool
+}
+
+func (x *extractor) cluster(size int64) error {
+	end := x.r.pos + size
+	var timecode int64
+	for size == unknownSize || x.r.pos < end {
+		start := x.r.pos
+		id, n, err := x.r.header()
+		if err == io.EOF && size == unknownSize {
+			return nil
+		}
+		if err != nil {
+			return noEOF(err)
+		}
+		if size == unknownSize && isTopLevel(id) {
+			return x.r.seek(start)
+		}
+		switch id {
+		case idTimecode:
+			v, err := x.r.uint(n)
+			if err != nil {
+				return err
+			}
+			timecode = int64(v)
+		case idSimpleBlock:
+			err = x.block(n, timecode, -1)
+		case idBlockGroup:
+			err = x.blockGroup(n, timecode)
+		default:
+			err = x.r.skip(n)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (x *extractor) blockGroup(size, timecode int64) error {
+	end := x.r.pos + size
+	blockPos, blockSize := int64(-1), int64(0)
+	duration := int64(-1)
+	for x.r.pos < end {
+		id, n, err := x.r.header()
+		if err != nil {
+			return noEOF(err)
+		}
+		switch id {
+		case idBlock:
+			blockPos, blockSize = x.r.pos, n
+			err = x.r.skip(n)
+		case idBlockDuration:
+			var v uint64
+			v, err = x.r.uint(n)
+			duration = int64(v)
+		default:
+			err = x.r.skip(n)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if blockPos < 0 {
+		return nil
+	}
+	// BlockDuration usually follows the Block, so the block itself is
+	// only read once the whole group has been seen.
+	if err := x.r.seek(blockPos); err != nil {
+		return err
+	}
+	if err := x.block(blockSize, timecode, duration); err != nil {
+		return err
+	}
+	return x.r.seek(end)
+}
+
+// block reads a (Simple)Block body of size bytes. duration is in
+// timecode ticks, or -1 if the block didn't come with one.
+func (x *extractor) block(size, timecode, duration int64) error {
+	end := x.r.pos + size
+	track, err := x.r.vint(false)
+	if err != nil {
+		return noEOF(err)
+	}
+	if track != x.track.Number {
+		return x.r.seek(end)
+	}
+	rel, err := x.r.int16()
+	if err != nil {
+		return err
+	}
+	flags, err := x.r.uint(1)
+	if err != nil {
+		return err
+	}
+	if flags&0x06 != 0 {
+		// Laced blocks only show up for audio in practice.
+		return x.r.seek(end)
+	}
+	payload, err := x.r.bytes(end - x.r.pos)
+	if err != nil {
+		return err
+	}
+	data, err := x.track.decode(payload)
+	if err != nil {
+		return err
+	}
+	text, align := x.track.cueText(data, x.styles)
+	if text == "" {
+		return nil
+	}
+	c := cue{Cue: subfile.Cue{Start: x.ticks(timecode + int64(rel)), Text: text, Align: align}}
+	if duration >= 0 {
+		c.End = c.Start + x.ticks(duration)
+		c.hasDuration = true
+	}
+	x.cues = append(x.cues, c)
+	return nil
+}
+
+func (x *extractor) ticks(n int64) time.Duration {
+	return time.Duration(n * x.scale)
+}
+
+// finish sorts the cues and gives the ones with no BlockDuration an end
+// time: up to the next cue, capped at maxMissingDuration.
+func (x *extractor) finish() []subfile.Cue {
+	sort.SliceStable(x.cues, func(i, j int) bool { return x.cues[i].Start < x.cues[j].Start })
+	out := make([]subfile.Cue, len(x.cues))
+	for i, c := range x.cues {
+		if !c.hasDuration {
+			c.End = c.Start + maxMissingDuration
+			if i+1 < len(x.cues) && x.cues[i+1].Start < c.End {
+				c.End = x.cues[i+1].Start
+			}
+		}
+		out[i] = c.Cue
+	}
+	return out
+}
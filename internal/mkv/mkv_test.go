@@ -0,0 +1,200 @@
+package mkv
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"testing"
+	"time"
+)
+
+// el encodes one EBML element: id as written in the spec, a size vint,
+// then the concatenated body.
+func el(id uint64, body ...[]byte) []byte {
+	data := bytes.Join(body, nil)
+	return append(append(idBytes(id), sizeBytes(int64(len(data)))...), data...)
+}
+
+// elUnknown encodes an element whose size field is all ones.
+func elUnknown(id uint64, body ...[]byte) []byte {
+	return append(append(idBytes(id), 0x01, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF), bytes.Join(body, nil)...)
+}
+
+func idBytes(id uint64) []byte {
+	var b []byte
+	for ; id > 0; id >>= 8 {
+		b = append([]byte{byte(id)}, b...)
+	}
+	return b
+}
+
+func sizeBytes(n int64) []byte {
+	// Always 8 bytes wide: valid EBML, and keeps the helper trivial.
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(n))
+	b[0] = 0x01
+	return b
+}
+
+func uintEl(id uint64, v uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, v)
+	return el(id, b)
+}
+
+func strEl(id uint64, s string) []byte { return el(id, []byte(s)) }
+
+// blockBody is a Block/SimpleBlock body: track vint, relative timecode,
+// flags, payload.
+func blockBody(track byte, rel int16, payload string) []byte {
+	b := []byte{0x80 | track, byte(uint16(rel) >> 8), byte(rel), 0x80}
+	return append(b, payload...)
+}
+
+func testFile(tracks []byte, clusters ...[]byte) []byte {
+	header := el(idEBML, strEl(0x4282, "matroska"))
+	segment := el(idSegment,
+		el(idInfo, uintEl(idTimecodeScale, 1000000)),
+		tracks,
+		bytes.Join(clusters, nil),
+	)
+	return append(header, segment...)
+}
+
+func TestReadTracks(t *testing.T) {
+	tracks := el(idTracks,
+		el(idTrackEntry, uintEl(idTrackNumber, 1), uintEl(idTrackType, 1), strEl(idCodecID, "V_MPEG4/ISO/AVC")),
+		el(idTrackEntry, uintEl(idTrackNumber, 3), uintEl(idTrackType, TypeSubtitle), strEl(idCodecID, "S_TEXT/UTF8"),
+			strEl(idLanguage, "por"), strEl(idLanguageIETF, "pt-BR"), strEl(idName, "Brazilian"), uintEl(idFlagForced, 1)),
+		el(idTrackEntry, uintEl(idTrackNumber, 4), uintEl(idTrackType, TypeSubtitle), strEl(idCodecID, "S_HDMV/PGS")),
+	)
+	got, err := ReadTracks(bytes.NewReader(testFile(tracks)))
+	if err != nil {
+		t.Fatalf("ReadTracks: %v", err)
+	}
+	if len(got) != 3 {
+		t.Fatalf("ReadTracks returned %d tracks, want 3", len(got))
+	}
+	sub := got[1]
+	if sub.Number != 3 || sub.Language != "pt-BR" || sub.Name != "Brazilian" || !sub.Forced || !sub.IsText() {
+		t.Errorf("subtitle track = %+v, want number 3, language pt-BR, name Brazilian, forced, text", sub)
+	}
+	if got[2].Language != "eng" {
+		t.Errorf("track with no Language element = %q, want spec default %q", got[2].Language, "eng")
+	}
+	if got[0].IsText() || got[2].IsText() {
+		t.Errorf("video and PGS tracks reported IsText() = true")
+	}
+}
+
+func TestReadTracks_NotMatroska(t *testing.T) {
+	_, err := ReadTracks(bytes.NewReader(el(0x1A45DFA4, []byte("nope"))))
+	if !errors.Is(err, ErrNotMatroska) {
+		t.Errorf("ReadTracks on a non-Matroska file = %v, want ErrNotMatroska", err)
+	}
+}
+
+func TestReadTracks_ClusterBeforeTracks(t *testing.T) {
+	data := append(el(idEBML), el(idSegment, el(idCluster, uintEl(idTimecode, 0)))...)
+	if _, err := ReadTracks(bytes.NewReader(data)); !errors.Is(err, ErrNoTracks) {
+		t.Errorf("ReadTracks with no Tracks element = %v, want ErrNoTracks", err)
+	}
+}
+
+func TestExtractSubtitles_UTF8(t *testing.T) {
+	track := Track{Number: 2, Type: TypeSubtitle, Codec: "S_TEXT/UTF8", compAlgo: -1}
+	tracks := el(idTracks, el(idTrackEntry, uintEl(idTrackNumber, 2), uintEl(idTrackType, TypeSubtitle), strEl(idCodecID, "S_TEXT/UTF8")))
+	data := testFile(tracks,
+		el(idCluster,
+			uintEl(idTimecode, 1000),
+			el(idSimpleBlock, blockBody(1, 0, "video data")),
+			el(idBlockGroup, el(idBlock, blockBody(2, 500, "Hello\r\nthere")), uintEl(idBlockDuration, 1500)),
+		),
+		// Unknown-size cluster, followed by another top-level element.
+		elUnknown(idCluster,
+			uintEl(idTimecode, 10000),
+			el(idSimpleBlock, blockBody(2, 0, "No duration")),
+			el(idSimpleBlock, blockBody(2, 2000, "Next")),
+		),
+		el(idCues),
+	)
+
+	got, err := ExtractSubtitles(bytes.NewReader(data), track)
+	if err != nil {
+		t.Fatalf("ExtractSubtitles: %v", err)
+	}
+	if len(got) != 3 {
+		t.Fatalf("ExtractSubtitles returned %d cues, want 3: %+v", len(got), got)
+	}
+	if got[0].Start != 1500*time.Millisecond || got[0].End != 3*time.Second || got[0].Text != "Hello\nthere" {
+		t.Errorf("cue 0 = %+v, want 1.5s-3s %q", got[0], "Hello\nthere")
+	}
+	if got[1].Start != 10*time.Second || got[1].End != 12*time.Second {
+		t.Errorf("cue 1 = %+v, want 10s-12s (ends at the next cue)", got[1])
+	}
+	if got[2].End != got[2].Start+maxMissingDuration {
+		t.Errorf("cue 2 = %+v, want it capped at %v", got[2], maxMissingDuration)
+	}
+}
+
+func TestExtractSubtitles_ASSAndZlib(t *testing.T) {
+	var z bytes.Buffer
+	zw := zlib.NewWriter(&z)
+	zw.Write([]byte(`0,0,Default,,0,0,0,,{\i1}Compressed{\i0}\Nline`))
+	zw.Close()
+
+	tracks := el(idTracks, el(idTrackEntry,
+		uintEl(idTrackNumber, 5), uintEl(idTrackType, TypeSubtitle), strEl(idCodecID, "S_TEXT/ASS"),
+		el(idContentEncodings, el(idContentEncoding, el(idContentCompression, uintEl(idContentCompAlgo, 0)))),
+	))
+	data := testFile(tracks, el(idCluster,
+		uintEl(idTimecode, 0),
+		el(idBlockGroup, el(idBlock, append(blockBody(5, 0, ""), z.Bytes()...)), uintEl(idBlockDuration, 1000)),
+	))
+
+	parsed, err := ReadTracks(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("ReadTracks: %v", err)
+	}
+	got, err := ExtractSubtitles(bytes.NewReader(data), parsed[0])
+	if err != nil {
+		t.Fatalf("ExtractSubtitles: %v", err)
+	}
+	if len(got) != 1 || got[0].Text != "<i>Compressed</i>\nline" {
+		t.Errorf("ExtractSubtitles = %+v, want one cue %q", got, "<i>Compressed</i>\nline")
+	}
+}
+
+func TestExtractSubtitles_ASSStylesFromCodecPrivate(t *testing.T) {
+	header := "[Script Info]\nScriptType: v4.00+\n\n[V4+ Styles]\n" +
+		"Format: Name, Fontname, Bold, Italic, Alignment\n" +
+		"Style: Sign,Arial,0,-1,8\n"
+	tracks := el(idTracks, el(idTrackEntry,
+		uintEl(idTrackNumber, 5), uintEl(idTrackType, TypeSubtitle), strEl(idCodecID, "S_TEXT/ASS"),
+		strEl(idCodecPrivate, header),
+	))
+	data := testFile(tracks, el(idCluster,
+		uintEl(idTimecode, 0),
+		el(idBlockGroup, el(idBlock, blockBody(5, 0, "0,0,Sign,,0,0,0,,Top text")), uintEl(idBlockDuration, 1000)),
+	))
+
+	parsed, err := ReadTracks(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("ReadTracks: %v", err)
+	}
+	got, err := ExtractSubtitles(bytes.NewReader(data), parsed[0])
+	if err != nil {
+		t.Fatalf("ExtractSubtitles: %v", err)
+	}
+	if len(got) != 1 || got[0].Text != "<i>Top text</i>" || got[0].Align != 8 {
+		t.Errorf("ExtractSubtitles = %+v, want one italic cue aligned top-centre", got)
+	}
+}
+
+func TestExtractSubtitles_RejectsNonTextTrack(t *testing.T) {
+	track := Track{Number: 1, Type: TypeSubtitle, Codec: "S_HDMV/PGS"}
+	if _, err := ExtractSubtitles(bytes.NewReader(nil), track); err == nil {
+		t.Error("ExtractSubtitles on a PGS track = nil error, want error")
+	}
+}
@@ -0,0 +1,342 @@
+// Package mp4 is a minimal ISO BMFF (MP4, M4V, MOV) reader: just
+// enough to find the moov box and map playback times to byte offsets
+// through the video track's sample tables, so the streamer can fetch
+// the right pieces before a player seeks there.
+//
+// Like package mkv, it reads through an io.ReadSeeker and skips what it
+// doesn't need, so on a torrent.Reader only the pieces holding box
+// headers and moov are waited on.
+package mp4
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+var (
+	// ErrNotMP4 is returned when the input doesn't start with a box
+	// this package recognizes.
+	ErrNotMP4 = errors.New("mp4: not an MP4 file")
+	// ErrNoIndex is returned for a file with no moov, or no video
+	// track with sample tables in it (fragmented MP4 keeps them in moof
+	// boxes instead).
+	ErrNoIndex = errors.New("mp4: no sample index")
+)
+
+// maxMoov caps how much of a moov box is read: a feature film's is a
+// few MB.
+const maxMoov = 64 << 20
+
+// Box is a top-level box: its type and where it is in the file.
+type Box struct {
+	Type   string
+	Offset int64 // of the header
+	Size   int64 // header included
+}
+
+// Boxes lists the top-level boxes, reading only their headers. The
+// last one may run past the end of what's been downloaded -- an mdat
+// usually does.
+func Boxes(rs io.ReadSeeker) ([]Box, error) {
+	size, err := rs.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	var boxes []Box
+	for off := int64(0); off < size; {
+		if _, err := rs.Seek(off, io.SeekStart); err != nil {
+			return nil, err
+		}
+		var h [16]byte
+		if _, err := io.ReadFull(rs, h[:8]); err != nil {
+			return boxes, fmt.Errorf("mp4: box header at %d: %w", off, err)
+		}
+		b := Box{Type: string(h[4:8]), Offset: off, Size: int64(binary.BigEndian.Uint32(h[:4]))}
+		switch b.Size {
+		case 0: // to the end of the file
+			b.Size = size - off
+		case 1: // a 64-bit size follows
+			if _, err := io.ReadFull(rs, h[8:16]); err != nil {
+				return boxes, fmt.Errorf("mp4: box header at %d: %w", off, err)
+			}
+			b.Size = int64(binary.BigEndian.Uint64(h[8:16]))
+		}
+		if len(boxes) == 0 && !knownTopLevel(b.Type) || b.Size < 8 {
+			return boxes, ErrNotMP4
+		}
+		boxes = append(boxes, b)
+		off += b.Size
+	}
+	return boxes, nil
+}
+
+func knownTopLevel(typ string) bool {
+	switch typ {
+	case "ftyp", "moov", "mdat", "free", "skip", "wide", "pdin", "styp", "sidx":
+		return true
+	}
+	return false
+}
+
+// Index is a file's seek index: where in the file its video track's
+// keyframes are.
+type Index struct {
+	Duration time.Duration
+	Moov     Box
+	Points   []SyncPoint // by Time
+}
+
+// SyncPoint is a keyframe: the sample playing at Time starts Offset
+// bytes into the file.
+type SyncPoint struct {
+	Time   time.Duration
+	Offset int64
+}
+
+// ReadIndex finds moov -- at the front of web-optimized files, at the
+// end of most others -- and reads the video track's sample tables.
+func ReadIndex(rs io.ReadSeeker) (*Index, error) {
+	boxes, err := Boxes(rs)
+	if err != nil && len(boxes) == 0 {
+		return nil, err
+	}
+	ix := &Index{}
+	for _, b := range boxes {
+		if b.Type == "moov" {
+			ix.Moov = b
+		}
+	}
+	if ix.Moov.Type == "" {
+		if err != nil {
+			return nil, err
+		}
+		return nil, ErrNoIndex
+	}
+	if ix.Moov.Size > maxMoov {
+		return nil, fmt.Errorf("mp4: moov of %d bytes is too large", ix.Moov.Size)
+	}
+	moov := make([]byte, ix.Moov.Size)
+	if _, err := rs.Seek(ix.Moov.Offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	if _, err := io.ReadFull(rs, moov); err != nil {
+		return nil, fmt.Errorf("mp4: reading moov: %w", err)
+	}
+	body, _ := payload(moov)
+
+	for _, c := range children(body) {
+		switch c.typ {
+		case "mvhd":
+			if scale, d, ok := timing(c.body); ok && scale > 0 {
+				ix.Duration = ticks(d, scale)
+			}
+		case "trak":
+			if points, ok := videoTrack(c.body); ok {
+				ix.Points = points
+			}
+		}
+	}
+	if len(ix.Points) == 0 {
+		return ix, ErrNoIndex
+	}
+	return ix, nil
+}
+
+// Offset is where to start reading to play from at: the last keyframe
+// at or before it (the first if at is before them all).
+func (ix *Index) Offset(at time.Duration) int64 {
+	if len(ix.Points) == 0 {
+		return 0
+	}
+	i := sort.Search(len(ix.Points), func(i int) bool { return ix.Points[i].Time > at })
+	return ix.Points[max(i-1, 0)].Offset
+}
+
+// box is a child box, parsed out of its parent's body.
+type box struct {
+	typ  string
+	body []byte
+}
+
+// payload returns the body of the box at the start of data, and the
+// rest of data after it.
+func payload(data []byte) (body, rest []byte) {
+	if len(data) < 8 {
+		return nil, nil
+	}
+	size, header := int64(binary.BigEndian.Uint32(data)), int64(8)
+	switch size {
+	case 0:
+		size = int64(len(data))
+	case 1:
+		if len(data) < 16 {
+			return nil, nil
+		}
+		size, header = int64(binary.BigEndian.Uint64(data[8:])), 16
+	}
+	if size < header || size > int64(len(data)) {
+		return nil, nil
+	}
+	return data[header:size], data[size:]
+}
+
+// children splits a container box's body into its child boxes,
+// stopping at anything malformed.
+func children(data []byte) []box {
+	var boxes []box
+	for len(data) >= 8 {
+		typ := string(data[4:8])
+		body, rest := payload(data)
+		if body == nil && rest == nil {
+			break
+		}
+		boxes = append(boxes, box{typ, body})
+		data = rest
+	}
+	return boxes
+}
+
+func child(data []byte, path ...string) []byte {
+	for _, typ := range path {
+		found := false
+		for _, c := range children(data) {
+			if c.typ == typ {
+				data, found = c.body, true
+				break
+			}
+		}
+		if !found {
+			return nil
+		}
+	}
+	return data
+}
+
+// timing reads the timescale and duration of an mvhd or mdhd body.
+func timing(b []byte) (scale uint32, duration uint64, ok bool) {
+	if len(b) < 1 {
+		return 0, 0, false
+	}
+	if b[0] == 1 { // version 1: 64-bit times
+		if len(b) < 32 {
+			return 0, 0, false
+		}
+		return binary.BigEndian.Uint32(b[20:]), binary.BigEndian.Uint64(b[24:]), true
+	}
+	if len(b) < 20 {
+		return 0, 0, false
+	}
+	return binary.BigEndian.Uint32(b[12:]), uint64(binary.BigEndian.Uint32(b[16:])), true
+}
+
+func ticks(n uint64, scale uint32) time.Duration {
+	return time.Duration(float64(n) / float64(scale) * float64(time.Second))
+}
+
+// table reads a full box's entry count and its entries of width bytes
+// each, starting after skip bytes of fields past the version/flags.
+func table(b []byte, skip, width int) (entries []byte, count int) {
+	if len(b) < 8+skip {
+		return nil, 0
+	}
+	count = int(binary.BigEndian.Uint32(b[4+skip:]))
+	entries = b[8+skip:]
+	if count < 0 || count > len(entries)/max(width, 1) {
+		count = len(entries) / max(width, 1)
+	}
+	return entries, count
+}
+
+// videoTrack maps a trak's keyframes to times and offsets, reporting
+// false if it isn't a video track with sample tables.
+func videoTrack(trak []byte) ([]SyncPoint, bool) {
+	mdia := child(trak, "mdia")
+	if hdlr := child(mdia, "hdlr"); len(hdlr) < 12 || string(hdlr[8:12]) != "vide" {
+		return nil, false
+	}
+	scale, _, ok := timing(child(mdia, "mdhd"))
+	if !ok || scale == 0 {
+		return nil, false
+	}
+	stbl := child(mdia, "minf", "stbl")
+	stts, nTimes := table(child(stbl, "stts"), 0, 8)
+	stsc, nRuns := table(child(stbl, "stsc"), 0, 12)
+	stsz := child(stbl, "stsz")
+	if len(stsz) < 12 {
+		return nil, false
+	}
+	sampleSize := binary.BigEndian.Uint32(stsz[4:])
+	sizes, nSamples := table(stsz, 4, 4)
+	if sampleSize != 0 {
+		nSamples = int(binary.BigEndian.Uint32(stsz[8:]))
+	}
+	var chunks []int64
+	if co, n := table(child(stbl, "stco"), 0, 4); n > 0 {
+		for i := 0; i < n; i++ {
+			chunks = append(chunks, int64(binary.BigEndian.Uint32(co[4*i:])))
+		}
+	} else if co, n := table(child(stbl, "co64"), 0, 8); n > 0 {
+		for i := 0; i < n; i++ {
+			chunks = append(chunks, int64(binary.BigEndian.Uint64(co[8*i:])))
+		}
+	}
+	stssBox := child(stbl, "stss")
+	stss, nSync := table(stssBox, 0, 4)
+	if nTimes == 0 || nRuns == 0 || len(chunks) == 0 {
+		return nil, false
+	}
+
+	var (
+		points []SyncPoint
+		t      uint64 // decode time of the current sample, in track ticks
+		ti     int    // stts entry, and samples left in it
+		tLeft  = int(binary.BigEndian.Uint32(stts[0:]))
+		ri     int // stsc run
+		chunk  int // current chunk (0-based), and samples left in it
+		cLeft  = int(binary.BigEndian.Uint32(stsc[4:]))
+		off    = chunks[0]
+		si     int // next stss entry
+		last   = -time.Hour
+	)
+	for s := 1; s <= nSamples && chunk < len(chunks); s++ {
+		sync := stssBox == nil // no stss: every sample is a keyframe
+		for si < nSync && int(binary.BigEndian.Uint32(stss[4*si:])) < s {
+			si++
+		}
+		if si < nSync && int(binary.BigEndian.Uint32(stss[4*si:])) == s {
+			sync = true
+		}
+		if at := ticks(t, scale); sync && (stssBox != nil || at-last >= time.Second) {
+			points = append(points, SyncPoint{Time: at, Offset: off})
+			last = at
+		}
+
+		// On to the next sample: its time, chunk and offset.
+		size := int64(sampleSize)
+		if sampleSize == 0 {
+			size = int64(binary.BigEndian.Uint32(sizes[4*(s-1):]))
+		}
+		off += size
+		t += uint64(binary.BigEndian.Uint32(stts[8*ti+4:]))
+		if tLeft--; tLeft == 0 && ti+1 < nTimes {
+			ti++
+			tLeft = int(binary.BigEndian.Uint32(stts[8*ti:]))
+		}
+		if cLeft--; cLeft == 0 {
+			chunk++
+			if chunk >= len(chunks) {
+				break
+			}
+			off = chunks[chunk]
+			if ri+1 < nRuns && int(binary.BigEndian.Uint32(stsc[12*(ri+1):]))-1 <= chunk {
+				ri++
+			}
+			cLeft = int(binary.BigEndian.Uint32(stsc[12*ri+4:]))
+		}
+	}
+	return points, len(points) > 0
+}
@@ -0,0 +1,140 @@
+package mp4
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+	"time"
+)
+
+func mkbox(typ string, body ...[]byte) []byte {
+	data := bytes.Join(body, nil)
+	b := make([]byte, 8, 8+len(data))
+	binary.BigEndian.PutUint32(b, uint32(8+len(data)))
+	copy(b[4:], typ)
+	return append(b, data...)
+}
+
+// full is a full box body: version/flags, then 32-bit fields.
+func full(fields ...uint32) []byte {
+	b := make([]byte, 4+4*len(fields))
+	for i, f := range fields {
+		binary.BigEndian.PutUint32(b[4+4*i:], f)
+	}
+	return b
+}
+
+func hdlr(handler string) []byte {
+	b := full(0, 0, 0, 0, 0)
+	copy(b[8:], handler)
+	return mkbox("hdlr", b)
+}
+
+// mdhd and mvhd share their version-0 layout: created, modified,
+// timescale, duration.
+func header(typ string, scale, duration uint32) []byte {
+	return mkbox(typ, full(0, 0, scale, duration))
+}
+
+// testMovie is ftyp, mdat, then moov with an audio track and a video
+// track of 10 one-second samples of 100 bytes: keyframes 1, 3 and 8;
+// chunks of 4, 4 and 2 samples at the given offsets.
+func testMovie(chunks [3]uint32) []byte {
+	video := mkbox("trak", mkbox("mdia",
+		header("mdhd", 1000, 10000),
+		hdlr("vide"),
+		mkbox("minf", mkbox("stbl",
+			mkbox("stts", full(1, 10, 1000)),
+			mkbox("stss", full(3, 1, 3, 8)),
+			mkbox("stsc", full(2, 1, 4, 1, 3, 2, 1)),
+			mkbox("stsz", full(100, 10)),
+			mkbox("stco", full(3, chunks[0], chunks[1], chunks[2])),
+		)),
+	))
+	audio := mkbox("trak", mkbox("mdia", header("mdhd", 48000, 480000), hdlr("soun")))
+	moov := mkbox("moov", header("mvhd", 600, 6000), audio, video)
+	return append(append(mkbox("ftyp", []byte("isom")), mkbox("mdat", make([]byte, 2000))...), moov...)
+}
+
+func TestReadIndex_KeyframesFromSampleTables(t *testing.T) {
+	file := testMovie([3]uint32{100, 700, 1400})
+	ix, err := ReadIndex(bytes.NewReader(file))
+	if err != nil {
+		t.Fatalf("ReadIndex: %v", err)
+	}
+	if ix.Duration != 10*time.Second {
+		t.Errorf("Duration = %v, want 10s", ix.Duration)
+	}
+	if ix.Moov.Type != "moov" || ix.Moov.Offset != 12+2008 || ix.Moov.Offset+ix.Moov.Size != int64(len(file)) {
+		t.Errorf("Moov = %+v", ix.Moov)
+	}
+	want := []SyncPoint{
+		{0, 100},                // sample 1, start of chunk 1
+		{2 * time.Second, 300},  // sample 3, 200 bytes into chunk 1
+		{7 * time.Second, 1000}, // sample 8, 300 bytes into chunk 2
+	}
+	if len(ix.Points) != len(want) {
+		t.Fatalf("Points = %+v, want %+v", ix.Points, want)
+	}
+	for i := range want {
+		if ix.Points[i] != want[i] {
+			t.Fatalf("Points = %+v, want %+v", ix.Points, want)
+		}
+	}
+
+	cases := []struct {
+		at   time.Duration
+		want int64
+	}{
+		{0, 100},
+		{time.Second, 100},
+		{5 * time.Second, 300},
+		{time.Hour, 1000},
+	}
+	for _, c := range cases {
+		if got := ix.Offset(c.at); got != c.want {
+			t.Errorf("Offset(%v) = %d, want %d", c.at, got, c.want)
+		}
+	}
+}
+
+func TestBoxes(t *testing.T) {
+	file := append(mkbox("ftyp", []byte("isom")), mkbox("free")...)
+	large := make([]byte, 16)
+	binary.BigEndian.PutUint32(large, 1)
+	copy(large[4:], "mdat")
+	binary.BigEndian.PutUint64(large[8:], 16+4)
+	file = append(append(file, large...), 1, 2, 3, 4)
+
+	boxes, err := Boxes(bytes.NewReader(file))
+	if err != nil {
+		t.Fatalf("Boxes: %v", err)
+	}
+	want := []Box{{"ftyp", 0, 12}, {"free", 12, 8}, {"mdat", 20, 20}}
+	if len(boxes) != len(want) {
+		t.Fatalf("Boxes = %+v, want %+v", boxes, want)
+	}
+	for i := range want {
+		if boxes[i] != want[i] {
+			t.Errorf("box %d = %+v, want %+v", i, boxes[i], want[i])
+		}
+	}
+}
+
+func TestReadIndex_Errors(t *testing.T) {
+	cases := []struct {
+		name string
+		file []byte
+		want error
+	}{
+		{"not mp4", []byte("\x1aE\xdf\xa3 matroska, not mp4"), ErrNotMP4},
+		{"no moov", append(mkbox("ftyp", []byte("isom")), mkbox("mdat", []byte("x"))...), ErrNoIndex},
+		{"no video track", append(mkbox("ftyp"), mkbox("moov", header("mvhd", 600, 6000))...), ErrNoIndex},
+	}
+	for _, c := range cases {
+		if _, err := ReadIndex(bytes.NewReader(c.file)); !errors.Is(err, c.want) {
+			t.Errorf("%s: ReadIndex error = %v, want %v", c.name, err, c.want)
+		}
+	}
+}
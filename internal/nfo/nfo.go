@@ -0,0 +1,58 @@
+// Package nfo pulls database IDs out of the .nfo files scene and P2P
+// releases ship alongside the video. Almost every one links the IMDb
+// page, and an ID is a far more precise subtitle search key than a
+// title guessed from a filename.
+package nfo
+
+import (
+	"regexp"
+	"strings"
+)
+
+// IDs are the database identifiers found in an NFO. Either may be empty.
+type IDs struct {
+	IMDb string // "tt0133093"
+	TMDb string // "603"
+}
+
+// Empty reports whether no ID was found.
+func (ids IDs) Empty() bool { return ids.IMDb == "" && ids.TMDb == "" }
+
+var (
+	// The title link is preferred over a bare "tt..." elsewhere in the
+	// text, which is occasionally a different title (a prequel, say,
+	// mentioned in the plot notes).
+	imdbLink = regexp.MustCompile(`(?i)imdb\.com/(?:[a-z]{2}/)?title/(tt\d{7,8})`)
+	imdbBare = regexp.MustCompile(`\b(tt\d{7,8})\b`)
+	tmdbLink = regexp.MustCompile(`(?i)themoviedb\.org/(?:movie|tv)/(\d+)`)
+)
+
+// Parse extracts the first IMDb and TMDb IDs in data.
+func Parse(data []byte) IDs {
+	s := string(data)
+	var ids IDs
+	if m := imdbLink.FindStringSubmatch(s); m != nil {
+		ids.IMDb = strings.ToLower(m[1])
+	} else if m := imdbBare.FindStringSubmatch(s); m != nil {
+		ids.IMDb = m[1]
+	}
+	if m := tmdbLink.FindStringSubmatch(s); m != nil {
+		ids.TMDb = m[1]
+	}
+	return ids
+}
+
+// ParseAll merges the IDs found across several NFOs, first match wins.
+func ParseAll(files [][]byte) IDs {
+	var ids IDs
+	for _, f := range files {
+		found := Parse(f)
+		if ids.IMDb == "" {
+			ids.IMDb = found.IMDb
+		}
+		if ids.TMDb == "" {
+			ids.TMDb = found.TMDb
+		}
+	}
+	return ids
+}
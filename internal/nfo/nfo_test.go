@@ -0,0 +1,43 @@
+package nfo
+
+import "testing"
+
+func TestParse(t *testing.T) {
+	cases := []struct {
+		name string
+		nfo  string
+		want IDs
+	}{
+		{"imdb link", "Info: https://www.imdb.com/title/tt0133093/\n", IDs{IMDb: "tt0133093"}},
+		{"imdb link with locale", "http://imdb.com/de/title/tt10872600/?ref_=fn", IDs{IMDb: "tt10872600"}},
+		{"link beats earlier bare id", "Prequel of tt0000001.\nIMDB: https://www.imdb.com/title/tt0133093", IDs{IMDb: "tt0133093"}},
+		{"bare id", "iMDB......: tt0133093 (8.7/10)", IDs{IMDb: "tt0133093"}},
+		{"tmdb movie", "https://www.themoviedb.org/movie/603-the-matrix", IDs{TMDb: "603"}},
+		{"tmdb tv", "https://www.themoviedb.org/tv/1399", IDs{TMDb: "1399"}},
+		{"both", "https://imdb.com/title/tt0133093 https://themoviedb.org/movie/603", IDs{IMDb: "tt0133093", TMDb: "603"}},
+		{"nothing", "Greetz to all our friends", IDs{}},
+		{"too short to be an id", "tt12345", IDs{}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := Parse([]byte(c.nfo)); got != c.want {
+				t.Errorf("Parse(%q) = %+v, want %+v", c.nfo, got, c.want)
+			}
+		})
+	}
+}
+
+func TestParseAll_FirstMatchWins(t *testing.T) {
+	got := ParseAll([][]byte{
+		[]byte("nothing here"),
+		[]byte("imdb.com/title/tt0133093"),
+		[]byte("imdb.com/title/tt0234215 themoviedb.org/movie/604"),
+	})
+	want := IDs{IMDb: "tt0133093", TMDb: "604"}
+	if got != want {
+		t.Errorf("ParseAll = %+v, want %+v", got, want)
+	}
+	if got.Empty() {
+		t.Errorf("Empty() = true for %+v", got)
+	}
+}
@@ -0,0 +1,148 @@
+package player
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Handle is a launched player, to wait on.
+type Handle struct {
+	Command string
+	IPC     string // the player's control socket, "" without one
+
+	done     chan struct{}
+	err      error
+	detached bool
+	control  func(context.Context) (Controller, error) // nil if it can't be controlled
+}
+
+// Controller is a running player that can say where playback is and be
+// told what to do: mpv over IPC, VLC over its HTTP interface, or Kodi
+// over JSON-RPC. A player that can't answer one of these returns an
+// error from it -- errors.ErrUnsupported if it never can.
+type Controller interface {
+	Position() (time.Duration, error)
+	Duration() (time.Duration, error)
+	CacheAhead() (time.Duration, error) // how far ahead of Position it has buffered
+	Paused() (bool, error)
+	SetPause(bool) error
+	AddSubtitle(url string) error
+	Close() error
+}
+
+// newHandle runs wait in the background; the Handle is done when it
+// returns.
+func newHandle(command string, wait func() error) *Handle {
+	h := &Handle{Command: command, done: make(chan struct{})}
+	go func() {
+		h.err = wait()
+		close(h.done)
+	}()
+	return h
+}
+
+// Done is closed once the player has exited.
+func (h *Handle) Done() <-chan struct{} { return h.done }
+
+// Wait blocks until the player exits, and returns why it did: nil for
+// a clean exit, an *exec.ExitError for a non-zero status.
+func (h *Handle) Wait() error {
+	<-h.done
+	return h.err
+}
+
+// ExitCode is the player's exit status once it's done: 0 for a clean
+// exit, 1 if it failed without one (killed, or never ran properly).
+func (h *Handle) ExitCode() int {
+	err := h.Wait()
+	if err == nil {
+		return 0
+	}
+	var exit *exec.ExitError
+	if errors.As(err, &exit) && exit.ExitCode() > 0 {
+		return exit.ExitCode()
+	}
+	return 1
+}
+
+// MPV connects to the player's control socket, waiting (until ctx is
+// done) for it to come up.
+func (h *Handle) MPV(ctx context.Context) (*MPV, error) {
+	if h.IPC == "" {
+		return nil, fmt.Errorf("player: %s wasn't launched with a control socket", h.Command)
+	}
+	ctx, cancel := h.untilDone(ctx)
+	defer cancel()
+	return DialMPV(ctx, h.IPC)
+}
+
+// vlc connects to the player's HTTP interface at addr, waiting (until
+// ctx is done) for it to come up.
+func (h *Handle) vlc(ctx context.Context, addr, password string) (*VLC, error) {
+	ctx, cancel := h.untilDone(ctx)
+	defer cancel()
+	return DialVLC(ctx, addr, password)
+}
+
+// untilDone is ctx, also cancelled if the player exits: there's no
+// point waiting for a dead player's control channel.
+func (h *Handle) untilDone(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		select {
+		case <-h.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// addSubtitles loads subs into the player once it can be controlled,
+// as best it can: there's no one to tell if it can't.
+func (h *Handle) addSubtitles(subs []string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	ctl, err := h.Control(ctx)
+	if err != nil {
+		return
+	}
+	defer ctl.Close()
+	for _, s := range subs {
+		ctl.AddSubtitle(s)
+	}
+}
+
+// Controllable reports whether Control can work: the player was
+// launched with a control channel (mpv or VLC with Options.IPC, or
+// Kodi).
+func (h *Handle) Controllable() bool { return h.control != nil }
+
+// Control connects to the player's control channel, waiting (until ctx
+// is done) for it to come up.
+func (h *Handle) Control(ctx context.Context) (Controller, error) {
+	if h.control == nil {
+		return nil, fmt.Errorf("player: %s can't be controlled", h.Command)
+	}
+	return h.control(ctx)
+}
+
+// Detached reports whether the process launched only hands the URL to
+// another program and exits -- xdg-open does -- so its exit says
+// nothing about when playback ends.
+func (h *Handle) Detached() bool { return h.detached }
+
+// isOpener reports whether command is a URL opener rather than a
+// player.
+func isOpener(command string) bool {
+	switch strings.TrimSuffix(filepath.Base(command), ".exe") {
+	case "xdg-open", "open", "gio":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,295 @@
+package player
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// KodiScheme prefixes a -player value naming a Kodi box:
+// kodi://[user:password@]host[:port], port 8080 by default.
+const KodiScheme = "kodi://"
+
+const (
+	kodiDefaultPort = "8080"
+	kodiTimeout     = 10 * time.Second
+)
+
+// Polling settings for waiting on Kodi; vars so tests can speed them
+// up. Kodi fetches and buffers the stream itself before it reports a
+// player, so starting gets a generous limit.
+var (
+	kodiPoll         = 2 * time.Second
+	kodiStartTimeout = 60 * time.Second
+	kodiMaxFailures  = 3 // polls in a row Kodi can fail to answer before it's taken as gone
+)
+
+// IsKodi reports whether a -player value names a Kodi box.
+func IsKodi(override string) bool { return strings.HasPrefix(override, KodiScheme) }
+
+// Kodi plays media on a Kodi box over its JSON-RPC HTTP API
+// (https://kodi.wiki/view/JSON-RPC_API). The box fetches the stream
+// itself, so the URL handed to it must be reachable from there.
+type Kodi struct {
+	Endpoint string // http://host:port/jsonrpc
+	User     string
+	Password string
+	Client   *http.Client
+
+	playerID     int           // the video player's, set before started is closed
+	started      chan struct{} // closed once Kodi is playing
+	poll         time.Duration
+	startTimeout time.Duration
+}
+
+// ParseKodi reads a kodi:// -player value.
+func ParseKodi(s string) (*Kodi, error) {
+	u, err := url.Parse(s)
+	if err != nil || u.Scheme+"://" != KodiScheme || u.Hostname() == "" {
+		return nil, fmt.Errorf("player: %q isn't kodi://[user:password@]host[:port]", s)
+	}
+	port := u.Port()
+	if port == "" {
+		port = kodiDefaultPort
+	}
+	k := &Kodi{
+		Endpoint: "http://" + net.JoinHostPort(u.Hostname(), port) + "/jsonrpc",
+		Client:   &http.Client{Timeout: kodiTimeout},
+		started:  make(chan struct{}),
+
+		poll:         kodiPoll,
+		startTimeout: kodiStartTimeout,
+	}
+	if u.User != nil {
+		k.User = u.User.Username()
+		k.Password, _ = u.User.Password()
+	}
+	return k, nil
+}
+
+// Addr is the box's host:port.
+func (k *Kodi) Addr() string {
+	u, err := url.Parse(k.Endpoint)
+	if err != nil {
+		return ""
+	}
+	return u.Host
+}
+
+// LocalIP is this machine's address on the route to the box -- what to
+// put in stream URLs for it when the server listens on every
+// interface. Nothing is sent.
+func (k *Kodi) LocalIP() (string, error) {
+	conn, err := net.Dial("udp", k.Addr())
+	if err != nil {
+		return "", fmt.Errorf("player: finding a route to Kodi at %s: %w", k.Addr(), err)
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP.String(), nil
+}
+
+// call runs a JSON-RPC method, decoding its result into result (if
+// non-nil).
+func (k *Kodi) call(method string, params, result any) error {
+	body, err := json.Marshal(map[string]any{"jsonrpc": "2.0", "id": 1, "method": method, "params": params})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, k.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if k.User != "" {
+		req.SetBasicAuth(k.User, k.Password)
+	}
+	resp, err := k.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("player: kodi %s: %w", method, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("player: kodi %s: %s", method, resp.Status)
+	}
+	var reply struct {
+		Result json.RawMessage `json:"result"`
+		Error  *struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&reply); err != nil {
+		return fmt.Errorf("player: kodi %s: %w", method, err)
+	}
+	if reply.Error != nil {
+		return fmt.Errorf("player: kodi %s: %s (%d)", method, reply.Error.Message, reply.Error.Code)
+	}
+	if result == nil {
+		return nil
+	}
+	return json.Unmarshal(reply.Result, result)
+}
+
+// kodiTime is Kodi's Player.Position.Time / Global.Time.
+type kodiTime struct {
+	Hours        int `json:"hours"`
+	Minutes      int `json:"minutes"`
+	Seconds      int `json:"seconds"`
+	Milliseconds int `json:"milliseconds"`
+}
+
+func toKodiTime(d time.Duration) kodiTime {
+	return kodiTime{
+		Hours:        int(d / time.Hour),
+		Minutes:      int(d / time.Minute % 60),
+		Seconds:      int(d / time.Second % 60),
+		Milliseconds: int(d / time.Millisecond % 1000),
+	}
+}
+
+func (t kodiTime) duration() time.Duration {
+	return time.Duration(t.Hours)*time.Hour + time.Duration(t.Minutes)*time.Minute +
+		time.Duration(t.Seconds)*time.Second + time.Duration(t.Milliseconds)*time.Millisecond
+}
+
+// Open starts playing m.URL, from m.Start. Kodi can't be handed a
+// title or subtitles here; subtitles go in with AddSubtitle once it's
+// playing.
+func (k *Kodi) Open(m Media) error {
+	params := map[string]any{"item": map[string]any{"file": m.URL}}
+	if m.Start > 0 {
+		params["options"] = map[string]any{"resume": toKodiTime(m.Start)}
+	}
+	return k.call("Player.Open", params, nil)
+}
+
+// activePlayer looks for a video player, returning its ID and whether
+// there is one.
+func (k *Kodi) activePlayer() (int, bool, error) {
+	var players []struct {
+		PlayerID int    `json:"playerid"`
+		Type     string `json:"type"`
+	}
+	if err := k.call("Player.GetActivePlayers", nil, &players); err != nil {
+		return 0, false, err
+	}
+	for _, p := range players {
+		if p.Type == "video" {
+			return p.PlayerID, true, nil
+		}
+	}
+	return 0, false, nil
+}
+
+// wait follows playback until Kodi stops playing: first until a video
+// player shows up (loading subs into it), then until it's gone.
+func (k *Kodi) wait(subs []string) error {
+	started, failures := false, 0
+	deadline := time.Now().Add(k.startTimeout)
+	for {
+		id, playing, err := k.activePlayer()
+		switch {
+		case err != nil:
+			if failures++; failures >= kodiMaxFailures {
+				return err
+			}
+		case playing && !started:
+			started, failures = true, 0
+			k.playerID = id
+			close(k.started)
+			for _, s := range subs {
+				k.AddSubtitle(s) // best effort; Kodi before 20 can't
+			}
+		case playing:
+			failures = 0
+		case started:
+			return nil
+		case time.Now().After(deadline):
+			return fmt.Errorf("player: kodi at %s didn't start playing in %s", k.Addr(), k.startTimeout)
+		}
+		time.Sleep(k.poll)
+	}
+}
+
+// properties reads Player.GetProperties for the video player.
+func (k *Kodi) properties(v any, names ...string) error {
+	return k.call("Player.GetProperties", map[string]any{"playerid": k.playerID, "properties": names}, v)
+}
+
+// Position is the playback position.
+func (k *Kodi) Position() (time.Duration, error) {
+	var p struct {
+		Time kodiTime `json:"time"`
+	}
+	err := k.properties(&p, "time")
+	return p.Time.duration(), err
+}
+
+// Duration is the length of what's playing.
+func (k *Kodi) Duration() (time.Duration, error) {
+	var p struct {
+		Total kodiTime `json:"totaltime"`
+	}
+	err := k.properties(&p, "totaltime")
+	return p.Total.duration(), err
+}
+
+// CacheAhead isn't reported by Kodi.
+func (k *Kodi) CacheAhead() (time.Duration, error) { return 0, errors.ErrUnsupported }
+
+// Paused reports whether playback is paused (speed 0).
+func (k *Kodi) Paused() (bool, error) {
+	var p struct {
+		Speed int `json:"speed"`
+	}
+	err := k.properties(&p, "speed")
+	return p.Speed == 0, err
+}
+
+// SetPause pauses or resumes playback.
+func (k *Kodi) SetPause(paused bool) error {
+	return k.call("Player.PlayPause", map[string]any{"playerid": k.playerID, "play": !paused}, nil)
+}
+
+// AddSubtitle loads a subtitle URL into the player (Kodi 20 and up).
+func (k *Kodi) AddSubtitle(url string) error {
+	return k.call("Player.AddSubtitle", map[string]any{"playerid": k.playerID, "subtitle": url}, nil)
+}
+
+// Close does nothing: each call is its own HTTP request.
+func (k *Kodi) Close() error { return nil }
+
+// launchKodi opens m on the Kodi box named by o.Override. The Handle
+// is done once Kodi stops playing it, and with o.IPC can be controlled
+// once it has started.
+func launchKodi(m Media, o Options) (*Handle, error) {
+	k, err := ParseKodi(o.Override)
+	if err != nil {
+		return nil, err
+	}
+	if err := k.Open(m); err != nil {
+		return nil, err
+	}
+	h := newHandle(KodiScheme+k.Addr(), func() error { return k.wait(m.Subs) })
+	if !o.IPC {
+		return h, nil
+	}
+	h.control = func(ctx context.Context) (Controller, error) {
+		select {
+		case <-k.started:
+			return k, nil
+		case <-h.done:
+			return nil, fmt.Errorf("player: kodi at %s stopped before it started playing", k.Addr())
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	return h, nil
+}
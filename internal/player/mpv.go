@@ -0,0 +1,194 @@
+package player
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// ErrClosed is returned by MPV calls once the connection is gone --
+// usually because mpv exited.
+var ErrClosed = errors.New("player: mpv connection closed")
+
+// mpvTimeout bounds each IPC request.
+const mpvTimeout = 5 * time.Second
+
+// MPV talks to a running mpv over its JSON IPC socket
+// (--input-ipc-server; see https://mpv.io/manual/master/#json-ipc):
+// one JSON object per line each way, replies matched to requests by
+// request_id, with events interleaved (and ignored here).
+type MPV struct {
+	conn net.Conn
+
+	writeMu sync.Mutex
+
+	mu      sync.Mutex
+	nextID  int64
+	pending map[int64]chan mpvReply
+	closed  bool
+}
+
+type mpvReply struct {
+	Error     string          `json:"error"`
+	Data      json.RawMessage `json:"data"`
+	RequestID *int64          `json:"request_id"`
+	Event     string          `json:"event"`
+}
+
+// DialMPV connects to mpv's IPC socket at path. mpv creates it a
+// moment after starting, so this keeps trying until ctx is done.
+func DialMPV(ctx context.Context, path string) (*MPV, error) {
+	var d net.Dialer
+	for {
+		conn, err := d.DialContext(ctx, "unix", path)
+		if err == nil {
+			return newMPV(conn), nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("player: connecting to mpv at %s: %w", path, err)
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
+
+func newMPV(conn net.Conn) *MPV {
+	m := &MPV{conn: conn, pending: make(map[int64]chan mpvReply)}
+	go m.read()
+	return m
+}
+
+// read dispatches replies to whoever is waiting on them, until the
+// connection goes.
+func (m *MPV) read() {
+	sc := bufio.NewScanner(m.conn)
+	sc.Buffer(nil, 1<<20)
+	for sc.Scan() {
+		var r mpvReply
+		if json.Unmarshal(sc.Bytes(), &r) != nil || r.RequestID == nil {
+			continue
+		}
+		m.mu.Lock()
+		ch := m.pending[*r.RequestID]
+		delete(m.pending, *r.RequestID)
+		m.mu.Unlock()
+		if ch != nil {
+			ch <- r
+		}
+	}
+	m.mu.Lock()
+	m.closed = true
+	for id, ch := range m.pending {
+		close(ch)
+		delete(m.pending, id)
+	}
+	m.mu.Unlock()
+}
+
+// Close closes the connection; mpv keeps running.
+func (m *MPV) Close() error { return m.conn.Close() }
+
+// Command runs an mpv input command ("sub-add", "seek", ...) and
+// returns its result data.
+func (m *MPV) Command(args ...any) (json.RawMessage, error) {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return nil, ErrClosed
+	}
+	m.nextID++
+	id := m.nextID
+	ch := make(chan mpvReply, 1)
+	m.pending[id] = ch
+	m.mu.Unlock()
+
+	line, err := json.Marshal(map[string]any{"command": args, "request_id": id})
+	if err != nil {
+		return nil, err
+	}
+	m.writeMu.Lock()
+	m.conn.SetWriteDeadline(time.Now().Add(mpvTimeout))
+	_, err = m.conn.Write(append(line, '\n'))
+	m.writeMu.Unlock()
+	if err != nil {
+		m.forget(id)
+		return nil, fmt.Errorf("player: mpv %v: %w", args[0], err)
+	}
+
+	select {
+	case r, ok := <-ch:
+		if !ok {
+			return nil, ErrClosed
+		}
+		if r.Error != "success" {
+			return nil, fmt.Errorf("player: mpv %v: %s", args[0], r.Error)
+		}
+		return r.Data, nil
+	case <-time.After(mpvTimeout):
+		m.forget(id)
+		return nil, fmt.Errorf("player: mpv %v: no reply in %s", args[0], mpvTimeout)
+	}
+}
+
+func (m *MPV) forget(id int64) {
+	m.mu.Lock()
+	delete(m.pending, id)
+	m.mu.Unlock()
+}
+
+// Get reads property name into v.
+func (m *MPV) Get(name string, v any) error {
+	data, err := m.Command("get_property", name)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// Set sets property name to v.
+func (m *MPV) Set(name string, v any) error {
+	_, err := m.Command("set_property", name, v)
+	return err
+}
+
+// Position is the playback position (time-pos).
+func (m *MPV) Position() (time.Duration, error) { return m.seconds("time-pos") }
+
+// Duration is the length of the file being played.
+func (m *MPV) Duration() (time.Duration, error) { return m.seconds("duration") }
+
+// CacheAhead is how much mpv has demuxed ahead of the playback
+// position (demuxer-cache-duration).
+func (m *MPV) CacheAhead() (time.Duration, error) { return m.seconds("demuxer-cache-duration") }
+
+// Paused reports whether playback is paused.
+func (m *MPV) Paused() (bool, error) {
+	var paused bool
+	err := m.Get("pause", &paused)
+	return paused, err
+}
+
+// SetPause pauses or resumes playback.
+func (m *MPV) SetPause(paused bool) error { return m.Set("pause", paused) }
+
+// AddSubtitle loads a subtitle into the running player, without
+// switching to it if one's already showing ("auto").
+func (m *MPV) AddSubtitle(url string) error {
+	_, err := m.Command("sub-add", url, "auto")
+	return err
+}
+
+// seconds reads a property mpv reports in (fractional) seconds. Before
+// a file's loaded they're unavailable, which comes back as an error.
+func (m *MPV) seconds(name string) (time.Duration, error) {
+	var s float64
+	if err := m.Get(name, &s); err != nil {
+		return 0, err
+	}
+	return time.Duration(s * float64(time.Second)), nil
+}
@@ -0,0 +1,172 @@
+package player
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeMPV answers mpv IPC requests over a real unix socket: properties
+// come from props, set_property writes them, other commands are
+// recorded. Each reply is preceded by an event, as mpv interleaves
+// them.
+type fakeMPV struct {
+	path string
+	ln   net.Listener
+
+	mu       sync.Mutex
+	props    map[string]any
+	commands [][]any
+}
+
+func startFakeMPV(t *testing.T, props map[string]any) *fakeMPV {
+	t.Helper()
+	dir, err := os.MkdirTemp("", "mpv") // t.TempDir() can exceed the socket path limit
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	f := &fakeMPV{path: filepath.Join(dir, "s"), props: props}
+	if f.ln, err = net.Listen("unix", f.path); err != nil {
+		t.Skipf("unix sockets unavailable: %v", err)
+	}
+	t.Cleanup(func() { f.ln.Close() })
+	go func() {
+		for {
+			conn, err := f.ln.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	return f
+}
+
+func (f *fakeMPV) serve(conn net.Conn) {
+	defer conn.Close()
+	sc := bufio.NewScanner(conn)
+	enc := json.NewEncoder(conn)
+	for sc.Scan() {
+		var req struct {
+			Command   []any `json:"command"`
+			RequestID int64 `json:"request_id"`
+		}
+		if json.Unmarshal(sc.Bytes(), &req) != nil {
+			continue
+		}
+		enc.Encode(map[string]any{"event": "playback-restart"})
+		reply := map[string]any{"error": "success", "request_id": req.RequestID}
+		f.mu.Lock()
+		switch req.Command[0] {
+		case "get_property":
+			if v, ok := f.props[req.Command[1].(string)]; ok {
+				reply["data"] = v
+			} else {
+				reply["error"] = "property unavailable"
+			}
+		case "set_property":
+			f.props[req.Command[1].(string)] = req.Command[2]
+		default:
+			f.commands = append(f.commands, req.Command)
+		}
+		f.mu.Unlock()
+		enc.Encode(reply)
+	}
+}
+
+func TestMPV_PropertiesAndCommands(t *testing.T) {
+	f := startFakeMPV(t, map[string]any{
+		"time-pos": 90.5, "duration": 7200.0, "demuxer-cache-duration": 12.25, "pause": false,
+	})
+	m, err := DialMPV(context.Background(), f.path)
+	if err != nil {
+		t.Fatalf("DialMPV: %v", err)
+	}
+	defer m.Close()
+
+	if pos, err := m.Position(); err != nil || pos != 90500*time.Millisecond {
+		t.Errorf("Position = %v, %v", pos, err)
+	}
+	if d, err := m.Duration(); err != nil || d != 2*time.Hour {
+		t.Errorf("Duration = %v, %v", d, err)
+	}
+	if c, err := m.CacheAhead(); err != nil || c != 12250*time.Millisecond {
+		t.Errorf("CacheAhead = %v, %v", c, err)
+	}
+	if err := m.SetPause(true); err != nil {
+		t.Fatalf("SetPause: %v", err)
+	}
+	if paused, err := m.Paused(); err != nil || !paused {
+		t.Errorf("Paused after SetPause(true) = %v, %v", paused, err)
+	}
+	if err := m.AddSubtitle("http://h/subs/a.en.srt"); err != nil {
+		t.Fatalf("AddSubtitle: %v", err)
+	}
+	f.mu.Lock()
+	got := f.commands
+	f.mu.Unlock()
+	if want := [][]any{{"sub-add", "http://h/subs/a.en.srt", "auto"}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("commands = %v, want %v", got, want)
+	}
+
+	if _, err := m.seconds("no-such-property"); err == nil {
+		t.Error("reading an unavailable property = nil error")
+	}
+}
+
+func TestMPV_ConcurrentRequests(t *testing.T) {
+	f := startFakeMPV(t, map[string]any{"time-pos": 1.0, "duration": 2.0})
+	m, err := DialMPV(context.Background(), f.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			get, want := m.Position, time.Second
+			if i%2 == 1 {
+				get, want = m.Duration, 2*time.Second
+			}
+			if got, err := get(); err != nil || got != want {
+				t.Errorf("request %d = %v, %v; want %v", i, got, err, want)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestMPV_ClosedConnection(t *testing.T) {
+	f := startFakeMPV(t, map[string]any{})
+	m, err := DialMPV(context.Background(), f.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Close()
+	if _, err := m.Command("get_property", "pause"); err == nil {
+		t.Error("Command on a closed connection = nil error")
+	}
+}
+
+func TestDialMPV_WaitsForTheSocket(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := DialMPV(ctx, filepath.Join(t.TempDir(), "nothing")); err == nil {
+		t.Error("DialMPV with no socket = nil error")
+	}
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Errorf("DialMPV gave up after %s, want it to keep trying until ctx is done", elapsed)
+	}
+}
@@ -1,11 +1,26 @@
 // Package player launches a video player pointed at the local stream URL,
 // for -autoplay. xdg-open works on both Linux and BSD (not Linux-only),
 // so it's tried first; mpv/vlc are the fallback for systems without it.
+//
+// How each player is run is described by a Profile: a command and an
+// argument template that passes it the subtitles, title, start position
+// and cache size it understands. mpv, vlc, celluloid, mplayer and
+// xdg-open come built in; more can be defined in the config file. A
+// Kodi box (kodi://host:port) is driven over its JSON-RPC API instead
+// of being run.
 package player
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"net"
+	"os"
 	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 )
 
 // ErrNoPlayerFound is returned when override is empty and none of the
@@ -15,26 +30,327 @@ var ErrNoPlayerFound = errors.New("player: no video player found (tried xdg-open
 
 var candidates = []string{"xdg-open", "mpv", "vlc"}
 
-// Launch opens url in a video player without blocking on it exiting. If
-// override is non-empty it's used directly (and must resolve via
-// exec.LookPath). Otherwise the first of xdg-open/mpv/vlc found on PATH is
-// used.
-func Launch(url, override string) error {
-	bin := override
-	if bin == "" {
-		bin = find()
-		if bin == "" {
-			return ErrNoPlayerFound
+// Media is what to play, and how.
+type Media struct {
+	URL   string
+	Title string        // shown as the media title, "" for the player's default
+	Subs  []string      // subtitle URLs to load alongside
+	Start time.Duration // where to start playback
+	Cache time.Duration // how much to buffer ahead, 0 for the player's default
+}
+
+// Profile is how to run a player. Each Args entry is a template: the
+// placeholders below are filled in, and an entry whose placeholder has
+// no value (no title, a zero start, ...) is left out altogether. An
+// entry is split on spaces before filling in, so "-ss {start}" is two
+// arguments and a title with spaces stays one.
+//
+//	{url}       the stream URL
+//	{title}     Media.Title
+//	{start}     Media.Start, in seconds
+//	{cache}     Media.Cache, in seconds
+//	{cache_ms}  Media.Cache, in milliseconds
+//	{sub}       each subtitle URL -- the entry is repeated per subtitle
+//	{sub1}      the first subtitle URL, for players that take just one
+//	{ipc}       a socket path to control the player through, with
+//	            Options.IPC (mpv's protocol, see MPV)
+//	{http_port}, {http_password}
+//	            a loopback port and password for VLC's HTTP
+//	            interface, with Options.IPC (see VLC)
+//	{http_config}
+//	            a VLC config file setting that password, so it
+//	            needn't be on the command line (see vlcConfig)
+type Profile struct {
+	Command string   `json:"command"` // "" is the profile's name
+	Args    []string `json:"args"`
+}
+
+// Profiles are the built-in player profiles, by name.
+var Profiles = map[string]Profile{
+	"mpv": {Args: []string{
+		"--input-ipc-server={ipc}", "--force-media-title={title}", "--start={start}", "--cache=yes --cache-secs={cache}",
+		"--sub-file={sub}", "{url}",
+	}},
+	"vlc": {Args: []string{
+		"--extraintf=http --http-host=127.0.0.1 --http-port={http_port} --config={http_config}",
+		"--meta-title={title}", "--start-time={start}", "--network-caching={cache_ms}",
+		"--sub-file={sub1}", "{url}",
+	}},
+	// Celluloid is an mpv front end, and passes --mpv-* options on.
+	"celluloid": {Args: []string{
+		"--mpv-force-media-title={title}", "--mpv-start={start}", "--mpv-cache=yes --mpv-cache-secs={cache}",
+		"--mpv-sub-file={sub}", "{url}",
+	}},
+	"mplayer":  {Args: []string{"-title {title}", "-ss {start}", "-sub {sub1}", "{url}"}},
+	"xdg-open": {Args: []string{"{url}"}},
+}
+
+// Options says which player to launch.
+type Options struct {
+	// Override picks the player: a profile name (built in or from
+	// Profiles, which take precedence), a command -- run with the
+	// profile of the same name if there is one, otherwise just given
+	// the URL -- or a whole command line template,
+	// "my-player --fs {url}". Empty auto-detects.
+	Override string
+	Profiles map[string]Profile
+
+	// Wait is set when the caller means to wait for the player to
+	// close. Auto-detection then tries xdg-open last, since it hands
+	// the URL off and exits at once.
+	Wait bool
+
+	// IPC asks for a control channel: a socket for profiles that take
+	// one ({ipc}), and JSON-RPC for Kodi. See Handle.Control.
+	IPC bool
+}
+
+// Launch opens m in a video player without blocking on it exiting,
+// and returns a Handle to wait on it with. Without o.Override, the
+// first of xdg-open/mpv/vlc found on PATH is used.
+func Launch(m Media, o Options) (*Handle, error) {
+	if IsKodi(o.Override) {
+		return launchKodi(m, o)
+	}
+	p, err := resolve(o)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := exec.LookPath(p.Command); err != nil {
+		return nil, err
+	}
+	// The control channel, if asked for and the profile takes one: a
+	// socket for mpv, or VLC's HTTP interface.
+	control := map[string]string{}
+	ipc := ""
+	if o.IPC && p.takes("{ipc}") {
+		ipc = filepath.Join(os.TempDir(), fmt.Sprintf("gws-player-%d-%d.sock", os.Getpid(), time.Now().UnixNano()))
+		control["{ipc}"] = ipc
+	}
+	vlcHTTP := o.IPC && p.takes("{http_port}")
+	var proc *process
+	for attempt := 1; ; attempt++ {
+		if vlcHTTP {
+			if err := setVLCControl(control, p.takes("{http_config}")); err != nil {
+				return nil, err
+			}
 		}
+		proc, err = start(p.Command, p.argv(m, control))
+		if err != nil {
+			removeControlFiles(control)
+			return nil, err
+		}
+		addr := net.JoinHostPort("127.0.0.1", control["{http_port}"])
+		if !vlcHTTP || attempt == vlcAttempts || !vlcPortTaken(addr, control["{http_password}"], proc.exited) {
+			break
+		}
+		proc.kill()
+		removeControlFiles(control)
 	}
-	if _, err := exec.LookPath(bin); err != nil {
+	h := newHandle(p.Command, func() error {
+		err := proc.wait()
+		removeControlFiles(control)
 		return err
+	})
+	switch {
+	case ipc != "":
+		h.IPC = ipc
+		h.control = func(ctx context.Context) (Controller, error) {
+			m, err := h.MPV(ctx)
+			if err != nil {
+				return nil, err
+			}
+			return m, nil
+		}
+	case control["{http_port}"] != "":
+		addr := net.JoinHostPort("127.0.0.1", control["{http_port}"])
+		h.control = func(ctx context.Context) (Controller, error) {
+			v, err := h.vlc(ctx, addr, control["{http_password}"])
+			if err != nil {
+				return nil, err
+			}
+			return v, nil
+		}
+	}
+	// Subtitles the command line couldn't carry -- VLC takes only one
+	// -- go in over the control channel.
+	if rest := p.untaken(m.Subs); h.control != nil && len(rest) > 0 {
+		go h.addSubtitles(rest)
+	}
+	h.detached = isOpener(p.Command)
+	return h, nil
+}
+
+// process is a started player command, waited on in the background so
+// Launch can tell if it exits early.
+type process struct {
+	cmd    *exec.Cmd
+	err    error
+	exited chan struct{} // closed once err is set
+}
+
+func start(name string, args []string) (*process, error) {
+	p := &process{cmd: exec.Command(name, args...), exited: make(chan struct{})}
+	if err := p.cmd.Start(); err != nil {
+		return nil, err
+	}
+	go func() {
+		p.err = p.cmd.Wait()
+		close(p.exited)
+	}()
+	return p, nil
+}
+
+// wait blocks until the command exits, and returns why it did.
+func (p *process) wait() error {
+	<-p.exited
+	return p.err
+}
+
+// kill stops the command and waits for it to go.
+func (p *process) kill() {
+	p.cmd.Process.Kill()
+	<-p.exited
+}
+
+// removeControlFiles deletes the control socket and config file a
+// launch left behind, if any.
+func removeControlFiles(control map[string]string) {
+	for _, k := range []string{"{ipc}", "{http_config}"} {
+		if control[k] != "" {
+			os.Remove(control[k])
+		}
 	}
-	return exec.Command(bin, url).Start()
 }
 
-func find() string {
-	for _, c := range candidates {
+// resolve picks the profile to launch, with Command filled in.
+func resolve(o Options) (Profile, error) {
+	override := o.Override
+	lookup := func(name string) (Profile, bool) {
+		if p, ok := o.Profiles[name]; ok {
+			return p, true
+		}
+		p, ok := Profiles[name]
+		return p, ok
+	}
+	named := func(name string, p Profile) Profile {
+		if p.Command == "" {
+			p.Command = name
+		}
+		return p
+	}
+
+	if override == "" {
+		bin := find(o.Wait)
+		if bin == "" {
+			return Profile{}, ErrNoPlayerFound
+		}
+		p, _ := lookup(bin)
+		return named(bin, p), nil
+	}
+	if fields := strings.Fields(override); len(fields) > 1 {
+		p := Profile{Command: fields[0], Args: fields[1:]}
+		if !strings.Contains(override, "{url}") {
+			p.Args = append(p.Args, "{url}")
+		}
+		return p, nil
+	}
+	if p, ok := lookup(override); ok {
+		return named(override, p), nil
+	}
+	if p, ok := lookup(strings.TrimSuffix(filepath.Base(override), ".exe")); ok {
+		p.Command = override
+		return p, nil
+	}
+	return Profile{Command: override, Args: []string{"{url}"}}, nil
+}
+
+// takes reports whether p's template uses placeholder.
+func (p Profile) takes(placeholder string) bool {
+	for _, a := range p.Args {
+		if strings.Contains(a, placeholder) {
+			return true
+		}
+	}
+	return false
+}
+
+// untaken is the subtitles p's template doesn't pass on.
+func (p Profile) untaken(subs []string) []string {
+	switch {
+	case p.takes("{sub}"):
+		return nil
+	case p.takes("{sub1}") && len(subs) > 0:
+		return subs[1:]
+	}
+	return subs
+}
+
+// argv fills in p's argument template for m, and the control channel's
+// placeholders ({ipc}, {http_port}, {http_password}, {http_config})
+// from control.
+func (p Profile) argv(m Media, control map[string]string) []string {
+	values := map[string]string{"{url}": m.URL, "{title}": m.Title}
+	for k, v := range control {
+		values[k] = v
+	}
+	if m.Start > 0 {
+		values["{start}"] = seconds(m.Start)
+	}
+	if m.Cache > 0 {
+		values["{cache}"] = seconds(m.Cache)
+		values["{cache_ms}"] = strconv.FormatInt(m.Cache.Milliseconds(), 10)
+	}
+	if len(m.Subs) > 0 {
+		values["{sub1}"] = m.Subs[0]
+	}
+
+	var argv []string
+	for _, tmpl := range p.Args {
+		if !strings.Contains(tmpl, "{sub}") {
+			argv = append(argv, expand(tmpl, values)...)
+			continue
+		}
+		for _, s := range m.Subs {
+			values["{sub}"] = s
+			argv = append(argv, expand(tmpl, values)...)
+		}
+	}
+	return argv
+}
+
+// expand fills in one template entry, or drops it (nil) if any
+// placeholder in it has no value. Unknown placeholders are left as is.
+func expand(tmpl string, values map[string]string) []string {
+	fields := strings.Fields(tmpl)
+	for _, placeholder := range []string{"{url}", "{title}", "{start}", "{cache}", "{cache_ms}", "{sub}", "{sub1}", "{ipc}", "{http_port}", "{http_password}", "{http_config}"} {
+		if strings.Contains(tmpl, placeholder) && values[placeholder] == "" {
+			return nil
+		}
+	}
+	// One pass, so a title containing "{url}" stays as it is.
+	var pairs []string
+	for placeholder, v := range values {
+		pairs = append(pairs, placeholder, v)
+	}
+	r := strings.NewReplacer(pairs...)
+	for i, f := range fields {
+		fields[i] = r.Replace(f)
+	}
+	return fields
+}
+
+// seconds formats d for a command line: "4320", "90.5".
+func seconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', -1, 64)
+}
+
+func find(wait bool) string {
+	order := candidates
+	if wait {
+		order = []string{"mpv", "vlc", "xdg-open"}
+	}
+	for _, c := range order {
 		if _, err := exec.LookPath(c); err == nil {
 			return c
 		}
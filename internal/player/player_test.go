@@ -3,8 +3,10 @@ package player
 import (
 	"os"
 	"path/filepath"
+	"reflect"
 	"runtime"
 	"testing"
+	"time"
 )
 
 // writeStub creates an executable no-op script named name in dir, real
@@ -12,12 +14,18 @@ import (
 // against it -- this exercises the real find()/Launch() logic against
 // real PATH lookups, not a mocked lookup function.
 func writeStub(t *testing.T, dir, name string) {
+	t.Helper()
+	writeScript(t, dir, name, "exit 0")
+}
+
+// writeScript is writeStub running script instead of exiting at once.
+func writeScript(t *testing.T, dir, name, script string) {
 	t.Helper()
 	if runtime.GOOS == "windows" {
 		t.Skip("stub scripts are POSIX shell, not written for windows")
 	}
 	path := filepath.Join(dir, name)
-	if err := os.WriteFile(path, []byte("#!/bin/sh\nexit 0\n"), 0o755); err != nil {
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+script+"\n"), 0o755); err != nil {
 		t.Fatalf("writing stub %s: %v", name, err)
 	}
 }
@@ -35,9 +43,13 @@ func TestLaunch_PrefersXdgOpen(t *testing.T) {
 	writeStub(t, dir, "mpv")
 	withPath(t, dir)
 
-	if err := Launch("http://localhost:8080/movie", ""); err != nil {
+	h, err := Launch(Media{URL: "http://localhost:8080/movie"}, Options{})
+	if err != nil {
 		t.Fatalf("Launch: %v", err)
 	}
+	if h.Command != "xdg-open" {
+		t.Errorf("launched %s, want xdg-open", h.Command)
+	}
 }
 
 func TestLaunch_FallsBackToMpvWhenNoXdgOpen(t *testing.T) {
@@ -45,9 +57,13 @@ func TestLaunch_FallsBackToMpvWhenNoXdgOpen(t *testing.T) {
 	writeStub(t, dir, "mpv")
 	withPath(t, dir)
 
-	if err := Launch("http://localhost:8080/movie", ""); err != nil {
+	h, err := Launch(Media{URL: "http://localhost:8080/movie"}, Options{})
+	if err != nil {
 		t.Fatalf("Launch: %v", err)
 	}
+	if h.Command != "mpv" {
+		t.Errorf("launched %s, want mpv", h.Command)
+	}
 }
 
 func TestLaunch_FallsBackToVlcWhenNoXdgOpenOrMpv(t *testing.T) {
@@ -55,16 +71,20 @@ func TestLaunch_FallsBackToVlcWhenNoXdgOpenOrMpv(t *testing.T) {
 	writeStub(t, dir, "vlc")
 	withPath(t, dir)
 
-	if err := Launch("http://localhost:8080/movie", ""); err != nil {
+	h, err := Launch(Media{URL: "http://localhost:8080/movie"}, Options{})
+	if err != nil {
 		t.Fatalf("Launch: %v", err)
 	}
+	if h.Command != "vlc" {
+		t.Errorf("launched %s, want vlc", h.Command)
+	}
 }
 
 func TestLaunch_NoPlayerFound(t *testing.T) {
 	dir := t.TempDir() // empty -- nothing on PATH
 	withPath(t, dir)
 
-	err := Launch("http://localhost:8080/movie", "")
+	_, err := Launch(Media{URL: "http://localhost:8080/movie"}, Options{})
 	if err != ErrNoPlayerFound {
 		t.Errorf("Launch = %v, want ErrNoPlayerFound", err)
 	}
@@ -76,17 +96,152 @@ func TestLaunch_OverrideTakesPriorityEvenIfCandidatesExist(t *testing.T) {
 	writeStub(t, dir, "my-custom-player")
 	withPath(t, dir)
 
-	if err := Launch("http://localhost:8080/movie", "my-custom-player"); err != nil {
+	h, err := Launch(Media{URL: "http://localhost:8080/movie"}, Options{Override: "my-custom-player"})
+	if err != nil {
 		t.Fatalf("Launch: %v", err)
 	}
+	if h.Command != "my-custom-player" {
+		t.Errorf("launched %s, want my-custom-player", h.Command)
+	}
 }
 
 func TestLaunch_OverrideNotFound(t *testing.T) {
 	dir := t.TempDir()
 	withPath(t, dir)
 
-	err := Launch("http://localhost:8080/movie", "definitely-not-a-real-player")
+	_, err := Launch(Media{URL: "http://localhost:8080/movie"}, Options{Override: "definitely-not-a-real-player"})
 	if err == nil {
 		t.Errorf("Launch with a nonexistent override = nil error, want error")
 	}
 }
+
+func TestArgv_PassSubtitlesToPlayersThatTakeThem(t *testing.T) {
+	m := Media{URL: "http://h/movie", Subs: []string{"http://h/subs/a.en.srt", "http://h/subs/a.pt.srt"}}
+	cases := map[string][]string{
+		"mpv":          {"--sub-file=http://h/subs/a.en.srt", "--sub-file=http://h/subs/a.pt.srt", "http://h/movie"},
+		"/usr/bin/vlc": {"--sub-file=http://h/subs/a.en.srt", "http://h/movie"},
+		"xdg-open":     {"http://h/movie"},
+		"my-player":    {"http://h/movie"},
+	}
+	for bin, want := range cases {
+		p, err := resolve(Options{Override: bin})
+		if err != nil {
+			t.Fatalf("resolve(%q): %v", bin, err)
+		}
+		if p.Command != bin {
+			t.Errorf("resolve(%q).Command = %q", bin, p.Command)
+		}
+		if got := p.argv(m, nil); !reflect.DeepEqual(got, want) {
+			t.Errorf("argv(%q) = %q, want %q", bin, got, want)
+		}
+	}
+}
+
+func TestArgv_TitleStartAndCache(t *testing.T) {
+	m := Media{
+		URL: "http://h/movie", Title: "Some Movie (2024)", Subs: []string{"http://h/s.srt"},
+		Start: 72*time.Minute + 500*time.Millisecond, Cache: 30 * time.Second,
+	}
+	cases := map[string][]string{
+		"mpv": {"--force-media-title=Some Movie (2024)", "--start=4320.5", "--cache=yes", "--cache-secs=30",
+			"--sub-file=http://h/s.srt", "http://h/movie"},
+		"vlc": {"--meta-title=Some Movie (2024)", "--start-time=4320.5", "--network-caching=30000",
+			"--sub-file=http://h/s.srt", "http://h/movie"},
+		"celluloid": {"--mpv-force-media-title=Some Movie (2024)", "--mpv-start=4320.5", "--mpv-cache=yes", "--mpv-cache-secs=30",
+			"--mpv-sub-file=http://h/s.srt", "http://h/movie"},
+		"mplayer": {"-title", "Some Movie (2024)", "-ss", "4320.5", "-sub", "http://h/s.srt", "http://h/movie"},
+	}
+	for name, want := range cases {
+		if got := Profiles[name].argv(m, nil); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s argv =\n%q\nwant\n%q", name, got, want)
+		}
+	}
+}
+
+func TestResolve_CustomProfilesAndCommandLines(t *testing.T) {
+	custom := map[string]Profile{
+		"iina": {Command: "iina-cli", Args: []string{"--mpv-start={start}", "{url}"}},
+		"mpv":  {Args: []string{"--fs", "{url}"}}, // overrides the built-in
+	}
+	m := Media{URL: "http://h/movie", Title: "{url}", Start: time.Minute}
+	cases := []struct {
+		override string
+		command  string
+		argv     []string
+	}{
+		{"iina", "iina-cli", []string{"--mpv-start=60", "http://h/movie"}},
+		{"mpv", "mpv", []string{"--fs", "http://h/movie"}},
+		{"/opt/bin/mpv", "/opt/bin/mpv", []string{"--fs", "http://h/movie"}},
+		{"my-player --fs --title={title}", "my-player", []string{"--fs", "--title={url}", "http://h/movie"}},
+		{"my-player {url} --fs", "my-player", []string{"http://h/movie", "--fs"}},
+	}
+	for _, c := range cases {
+		p, err := resolve(Options{Override: c.override, Profiles: custom})
+		if err != nil {
+			t.Fatalf("resolve(%q): %v", c.override, err)
+		}
+		if got := p.argv(m, nil); p.Command != c.command || !reflect.DeepEqual(got, c.argv) {
+			t.Errorf("resolve(%q) runs %s %q, want %s %q", c.override, p.Command, got, c.command, c.argv)
+		}
+	}
+}
+
+func TestLaunch_WaitingPrefersRealPlayersOverXdgOpen(t *testing.T) {
+	dir := t.TempDir()
+	writeStub(t, dir, "xdg-open")
+	writeStub(t, dir, "vlc")
+	withPath(t, dir)
+
+	h, err := Launch(Media{URL: "http://localhost:8080/movie"}, Options{Wait: true})
+	if err != nil {
+		t.Fatalf("Launch: %v", err)
+	}
+	if h.Command != "vlc" || h.Detached() {
+		t.Errorf("launched %s (detached %v), want vlc", h.Command, h.Detached())
+	}
+
+	// Only xdg-open: launched, but flagged as not worth waiting on.
+	dir = t.TempDir()
+	writeStub(t, dir, "xdg-open")
+	withPath(t, dir)
+	if h, err = Launch(Media{URL: "http://localhost:8080/movie"}, Options{Wait: true}); err != nil {
+		t.Fatalf("Launch: %v", err)
+	}
+	if h.Command != "xdg-open" || !h.Detached() {
+		t.Errorf("launched %s (detached %v), want a detached xdg-open", h.Command, h.Detached())
+	}
+}
+
+func TestHandle_WaitAndExitCode(t *testing.T) {
+	dir := t.TempDir()
+	writeScript(t, dir, "good-player", "exit 0")
+	writeScript(t, dir, "bad-player", "exit 3")
+	writeScript(t, dir, "slow-player", "PATH=/usr/bin:/bin; exec sleep 2")
+	withPath(t, dir)
+
+	cases := map[string]int{"good-player": 0, "bad-player": 3}
+	for bin, want := range cases {
+		h, err := Launch(Media{URL: "http://h/movie"}, Options{Override: bin})
+		if err != nil {
+			t.Fatalf("Launch(%s): %v", bin, err)
+		}
+		select {
+		case <-h.Done():
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s: Done never closed", bin)
+		}
+		if got := h.ExitCode(); got != want {
+			t.Errorf("%s: ExitCode = %d, want %d (Wait: %v)", bin, got, want, h.Wait())
+		}
+	}
+
+	h, err := Launch(Media{URL: "http://h/movie"}, Options{Override: "slow-player"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-h.Done():
+		t.Error("Done closed while the player was still running")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
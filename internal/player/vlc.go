@@ -0,0 +1,240 @@
+package player
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"time"
+)
+
+// VLC talks to a running VLC over its HTTP interface (--extraintf=http;
+// see https://wiki.videolan.org/VLC_HTTP_requests/): every request to
+// /requests/status.json returns the player's state, and can carry a
+// command to run first.
+type VLC struct {
+	base     string // http://127.0.0.1:port
+	password string
+	client   *http.Client
+}
+
+// vlcStatus is the part of status.json read here. Times are in whole
+// seconds.
+type vlcStatus struct {
+	State  string `json:"state"` // "playing", "paused" or "stopped"
+	Time   int64  `json:"time"`
+	Length int64  `json:"length"`
+}
+
+// DialVLC connects to VLC's HTTP interface at addr (host:port). VLC
+// starts it a moment after launching, so this keeps trying until ctx is
+// done.
+func DialVLC(ctx context.Context, addr, password string) (*VLC, error) {
+	v := &VLC{base: "http://" + addr, password: password, client: &http.Client{Timeout: mpvTimeout}}
+	for {
+		_, err := v.status(nil)
+		if err == nil {
+			return v, nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("player: connecting to vlc at %s: %w", addr, err)
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
+
+// errWrongServer is what status returns when something answers at
+// VLC's address, but not as the VLC launched: it turns the password
+// away, or isn't VLC at all.
+var errWrongServer = errors.New("player: vlc: something else is answering")
+
+// status fetches status.json, running the command in params if any.
+func (v *VLC) status(params url.Values) (vlcStatus, error) {
+	var s vlcStatus
+	req, err := http.NewRequest(http.MethodGet, v.base+"/requests/status.json?"+params.Encode(), nil)
+	if err != nil {
+		return s, err
+	}
+	req.SetBasicAuth("", v.password) // VLC only checks the password
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return s, fmt.Errorf("player: vlc: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return s, fmt.Errorf("%w (%s)", errWrongServer, resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&s); err != nil {
+		return s, fmt.Errorf("%w (reading status: %v)", errWrongServer, err)
+	}
+	return s, nil
+}
+
+// command runs a status.json command, with val if it's not "".
+func (v *VLC) command(name, val string) error {
+	params := url.Values{"command": {name}}
+	if val != "" {
+		params.Set("val", val)
+	}
+	_, err := v.status(params)
+	return err
+}
+
+// Position is the playback position, to the second.
+func (v *VLC) Position() (time.Duration, error) {
+	s, err := v.status(nil)
+	if err == nil && s.State == "stopped" {
+		err = errors.New("player: vlc: nothing playing")
+	}
+	return time.Duration(s.Time) * time.Second, err
+}
+
+// Duration is the length of what's playing, 0 until VLC knows it.
+func (v *VLC) Duration() (time.Duration, error) {
+	s, err := v.status(nil)
+	return time.Duration(s.Length) * time.Second, err
+}
+
+// CacheAhead isn't reported by VLC's HTTP interface.
+func (v *VLC) CacheAhead() (time.Duration, error) { return 0, errors.ErrUnsupported }
+
+// Paused reports whether playback is paused.
+func (v *VLC) Paused() (bool, error) {
+	s, err := v.status(nil)
+	return s.State == "paused", err
+}
+
+// SetPause pauses or resumes playback. (VLC's plain "pl_pause"
+// toggles; these don't.)
+func (v *VLC) SetPause(paused bool) error {
+	if paused {
+		return v.command("pl_forcepause", "")
+	}
+	return v.command("pl_forceresume", "")
+}
+
+// AddSubtitle loads a subtitle URL into the player, and switches to it.
+func (v *VLC) AddSubtitle(url string) error { return v.command("addsubtitle", url) }
+
+// Close does nothing: each call is its own HTTP request. VLC keeps
+// running.
+func (v *VLC) Close() error { return nil }
+
+// vlcAttempts is how many times Launch starts VLC, on a new port each
+// time, when another program turns out to be answering on the port it
+// picked -- it was free when picked, but nothing holds it until VLC
+// binds it. vlcBindWait is how long it waits to find out; a VLC that
+// isn't answering by then is left to come up in its own time.
+const vlcAttempts = 3
+
+var vlcBindWait = 5 * time.Second
+
+// vlcEndpoint picks a free loopback port and a random password for
+// VLC's HTTP interface. A variable so tests can hand out ports of
+// their choosing.
+var vlcEndpoint = func() (port, password string, err error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", "", err
+	}
+	port = strconv.Itoa(ln.Addr().(*net.TCPAddr).Port)
+	ln.Close()
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	return port, hex.EncodeToString(b), nil
+}
+
+// setVLCControl fills in the {http_*} placeholders for a fresh port and
+// password, and writes the config file for {http_config} if withConfig.
+func setVLCControl(control map[string]string, withConfig bool) error {
+	port, password, err := vlcEndpoint()
+	if err != nil {
+		return err
+	}
+	control["{http_port}"], control["{http_password}"] = port, password
+	if withConfig {
+		path, err := vlcConfig(password)
+		if err != nil {
+			return err
+		}
+		control["{http_config}"] = path
+	}
+	return nil
+}
+
+// vlcConfig writes a VLC config file setting the HTTP interface's
+// password, and returns its path. Passed with --config, it keeps the
+// password out of the process list, where --http-password would show
+// it to every user on the machine. It starts as a copy of the user's
+// own vlcrc, if there is one, since --config reads it instead; VLC
+// takes the last value it reads for a setting, so the password added
+// at the end wins over any already there.
+func vlcConfig(password string) (string, error) {
+	f, err := os.CreateTemp("", "gws-vlcrc-*") // readable by the owner only
+	if err != nil {
+		return "", fmt.Errorf("player: vlc config: %w", err)
+	}
+	if rc, err := os.ReadFile(vlcrc()); err == nil {
+		f.Write(rc)
+	}
+	_, err = fmt.Fprintf(f, "\n[lua]\nhttp-password=%s\n", password)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", fmt.Errorf("player: vlc config: %w", err)
+	}
+	return f.Name(), nil
+}
+
+// vlcrc is where VLC keeps the user's configuration, "" if there's no
+// telling.
+func vlcrc() string {
+	if runtime.GOOS == "darwin" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		return filepath.Join(home, "Library", "Preferences", "org.videolan.vlc", "vlcrc")
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "vlc", "vlcrc")
+}
+
+// vlcPortTaken waits for the HTTP interface at addr to answer, and
+// reports whether something other than the VLC given password did --
+// in which case that VLC couldn't bind the port. It gives up, saying
+// no, after vlcBindWait or once exited is closed.
+func vlcPortTaken(addr, password string, exited <-chan struct{}) bool {
+	v := &VLC{base: "http://" + addr, password: password, client: &http.Client{Timeout: mpvTimeout}}
+	deadline := time.After(vlcBindWait)
+	for {
+		_, err := v.status(nil)
+		if err == nil || errors.Is(err, errWrongServer) {
+			return err != nil
+		}
+		select {
+		case <-deadline:
+			return false
+		case <-exited:
+			return false
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
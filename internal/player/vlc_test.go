@@ -0,0 +1,251 @@
+package player
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeVLC serves VLC's /requests/status.json: state and times from its
+// fields, pl_forcepause/pl_forceresume change the state, other commands
+// are recorded.
+type fakeVLC struct {
+	password string
+
+	mu       sync.Mutex
+	state    string
+	time     int64
+	length   int64
+	commands []string
+}
+
+func (f *fakeVLC) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if _, pass, _ := r.BasicAuth(); pass != f.password {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if r.URL.Path != "/requests/status.json" {
+		http.NotFound(w, r)
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch cmd := r.URL.Query().Get("command"); cmd {
+	case "":
+	case "pl_forcepause":
+		f.state = "paused"
+	case "pl_forceresume":
+		f.state = "playing"
+	default:
+		f.commands = append(f.commands, cmd+" "+r.URL.Query().Get("val"))
+	}
+	json.NewEncoder(w).Encode(map[string]any{"state": f.state, "time": f.time, "length": f.length, "volume": 256})
+}
+
+func (f *fakeVLC) recorded() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.commands...)
+}
+
+func TestVLC_StatusAndCommands(t *testing.T) {
+	f := &fakeVLC{password: "pw", state: "playing", time: 754, length: 5400}
+	srv := httptest.NewServer(f)
+	defer srv.Close()
+	addr := strings.TrimPrefix(srv.URL, "http://")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if _, err := DialVLC(ctx, addr, "wrong"); err == nil {
+		t.Error("DialVLC with the wrong password = nil error")
+	}
+
+	v, err := DialVLC(context.Background(), addr, "pw")
+	if err != nil {
+		t.Fatalf("DialVLC: %v", err)
+	}
+	if pos, err := v.Position(); err != nil || pos != 754*time.Second {
+		t.Errorf("Position = %v, %v", pos, err)
+	}
+	if d, err := v.Duration(); err != nil || d != 90*time.Minute {
+		t.Errorf("Duration = %v, %v", d, err)
+	}
+	if err := v.SetPause(true); err != nil {
+		t.Fatal(err)
+	}
+	if paused, err := v.Paused(); err != nil || !paused {
+		t.Errorf("Paused after SetPause(true) = %v, %v", paused, err)
+	}
+	if err := v.AddSubtitle("http://h/subs/a b.en.srt"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := f.recorded(), []string{"addsubtitle http://h/subs/a b.en.srt"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("commands = %q, want %q", got, want)
+	}
+	if _, err := v.CacheAhead(); err == nil {
+		t.Error("CacheAhead = nil error; VLC doesn't report it")
+	}
+
+	f.mu.Lock()
+	f.state = "stopped"
+	f.mu.Unlock()
+	if _, err := v.Position(); err == nil {
+		t.Error("Position with nothing playing = nil error")
+	}
+}
+
+func TestProfile_Untaken(t *testing.T) {
+	subs := []string{"a", "b", "c"}
+	cases := []struct {
+		name string
+		want []string
+	}{
+		{"mpv", nil},
+		{"vlc", []string{"b", "c"}},
+		{"xdg-open", subs},
+	}
+	for _, c := range cases {
+		if got := Profiles[c.name].untaken(subs); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s untaken = %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+// TestLaunch_VLCControl runs a stub vlc that records its arguments,
+// stands a fake HTTP interface up on the port it was given, and checks
+// the subtitles the command line couldn't carry arrive over it.
+func TestLaunch_VLCControl(t *testing.T) {
+	dir := t.TempDir()
+	argsFile := filepath.Join(dir, "args")
+	writeScript(t, dir, "vlc", "PATH=/usr/bin:/bin\nprintf '%s\\n' \"$@\" > "+argsFile+".tmp && mv "+argsFile+".tmp "+argsFile+"\nexec sleep 3")
+	withPath(t, dir)
+	withBindWait(t, 100*time.Millisecond)
+	configHome := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", configHome)
+	os.MkdirAll(filepath.Join(configHome, "vlc"), 0o755)
+	os.WriteFile(filepath.Join(configHome, "vlc", "vlcrc"), []byte("[qt]\nqt-privacy-ask=0\n"), 0o644)
+
+	m := Media{URL: "http://h/movie", Subs: []string{"http://h/subs/a.en.srt", "http://h/subs/a.fr.srt"}}
+	h, err := Launch(m, Options{Override: "vlc", IPC: true})
+	if err != nil {
+		t.Fatalf("Launch: %v", err)
+	}
+	if !h.Controllable() {
+		t.Fatal("vlc launched with IPC isn't controllable")
+	}
+
+	var args []string
+	for deadline := time.Now().Add(3 * time.Second); args == nil; time.Sleep(10 * time.Millisecond) {
+		if data, err := os.ReadFile(argsFile); err == nil {
+			args = strings.Fields(string(data))
+		} else if time.Now().After(deadline) {
+			t.Fatal("stub vlc never wrote its arguments")
+		}
+	}
+	f := &fakeVLC{state: "playing"}
+	var port, config string
+	for _, a := range args {
+		if v, ok := strings.CutPrefix(a, "--http-port="); ok {
+			port = v
+		}
+		if v, ok := strings.CutPrefix(a, "--config="); ok {
+			config = v
+		}
+		if strings.HasPrefix(a, "--http-password") {
+			t.Errorf("vlc args carry the password: %q", a)
+		}
+	}
+	if port == "" || config == "" || !slices.Contains(args, "--extraintf=http") || !slices.Contains(args, "--sub-file="+m.Subs[0]) {
+		t.Fatalf("vlc args = %q, want the HTTP interface on a port with a config file, and the first subtitle", args)
+	}
+	rc, err := os.ReadFile(config)
+	if err != nil {
+		t.Fatalf("reading vlc config: %v", err)
+	}
+	if runtime.GOOS != "darwin" && !strings.HasPrefix(string(rc), "[qt]\nqt-privacy-ask=0\n") {
+		t.Errorf("vlc config = %q, want it to start with the user's vlcrc", rc)
+	}
+	for _, line := range strings.Split(string(rc), "\n") {
+		if v, ok := strings.CutPrefix(line, "http-password="); ok {
+			f.password = v
+		}
+	}
+	if f.password == "" {
+		t.Fatalf("vlc config = %q, want the HTTP password set", rc)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:"+port)
+	if err != nil {
+		t.Skipf("port %s taken meanwhile: %v", port, err)
+	}
+	srv := httptest.NewUnstartedServer(f)
+	srv.Listener.Close()
+	srv.Listener = ln
+	srv.Start()
+	defer srv.Close()
+
+	want := []string{"addsubtitle " + m.Subs[1]}
+	for deadline := time.Now().Add(3 * time.Second); !reflect.DeepEqual(f.recorded(), want); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("commands = %q, want %q", f.recorded(), want)
+		}
+	}
+}
+
+// TestLaunch_VLCRetriesTakenPort has the first port handed out answered
+// by another server, as if it had been taken between being picked and
+// VLC binding it, and checks VLC is started again on another.
+func TestLaunch_VLCRetriesTakenPort(t *testing.T) {
+	dir := t.TempDir()
+	argsFile := filepath.Join(dir, "args")
+	writeScript(t, dir, "vlc", "PATH=/usr/bin:/bin\nprintf '%s\\n' \"$@\" >> "+argsFile+"\nexec sleep 1")
+	withPath(t, dir)
+	withBindWait(t, 500*time.Millisecond)
+
+	other := httptest.NewServer(http.NotFoundHandler())
+	defer other.Close()
+	_, taken, _ := net.SplitHostPort(other.Listener.Addr().String())
+	ports := []string{taken, "1"}
+	original := vlcEndpoint
+	vlcEndpoint = func() (string, string, error) {
+		port := ports[0]
+		ports = ports[1:]
+		return port, "pw", nil
+	}
+	t.Cleanup(func() { vlcEndpoint = original })
+
+	h, err := Launch(Media{URL: "http://h/movie"}, Options{Override: "vlc", IPC: true})
+	if err != nil {
+		t.Fatalf("Launch: %v", err)
+	}
+	defer h.Wait()
+	data, _ := os.ReadFile(argsFile)
+	var launched []string
+	for _, a := range strings.Fields(string(data)) {
+		if v, ok := strings.CutPrefix(a, "--http-port="); ok {
+			launched = append(launched, v)
+		}
+	}
+	// The first VLC may be killed before it gets to write its
+	// arguments, so only the one left running is sure to have.
+	if len(ports) != 0 || len(launched) == 0 || launched[len(launched)-1] != "1" {
+		t.Errorf("vlc launched on ports %v with %v left unused, want it last on 1 after %s", launched, ports, taken)
+	}
+}
+
+func withBindWait(t *testing.T, d time.Duration) {
+	t.Helper()
+	original := vlcBindWait
+	vlcBindWait = d
+	t.Cleanup(func() { vlcBindWait = original })
+}
@@ -0,0 +1,324 @@
+// Package release parses scene/P2P-style release names such as
+// "Show.S02E05.1080p.WEB-DL.x264-GROUP.mkv" into their parts. Like any
+// release-name parser it's heuristic -- naming conventions are loose
+// and inconsistent -- so every field is best-effort and may be empty.
+package release
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Info is what Parse could make out of a release name.
+type Info struct {
+	Title      string
+	Year       int
+	Season     int
+	Episodes   []int  // more than one for multi-episode files (S01E01E02, S01E01-03)
+	Resolution string // normalized to "720p", "1080p", "2160p", ...
+	Source     string // as written: "BluRay", "WEB-DL", "HDTV", ...
+	Codec      string // as written: "x264", "HEVC", "H.264", ...
+	Audio      string // as written: "DTS", "DDP5.1", "AAC2.0", ...
+	Group      string
+
+	Proper       bool
+	Repack       bool
+	Extended     bool
+	Unrated      bool
+	Remastered   bool
+	DirectorsCut bool
+	Sample       bool // a short preview clip, not the release itself
+}
+
+// IsEpisode reports whether the name identified a TV episode rather
+// than a movie (or a season pack).
+func (i Info) IsEpisode() bool { return len(i.Episodes) > 0 }
+
+// String is a short human-readable label: "Title (2024)" for movies,
+// "Show S02E05" (or "Show S02E05-E06") for episodes.
+func (i Info) String() string {
+	s := i.Title
+	if i.IsEpisode() {
+		s += fmt.Sprintf(" S%02dE%02d", i.Season, i.Episodes[0])
+		if n := len(i.Episodes); n > 1 {
+			s += fmt.Sprintf("-E%02d", i.Episodes[n-1])
+		}
+	} else if i.Season > 0 {
+		s += fmt.Sprintf(" S%02d", i.Season)
+	}
+	if i.Year > 0 {
+		s += fmt.Sprintf(" (%d)", i.Year)
+	}
+	return strings.TrimSpace(s)
+}
+
+var (
+	leadingGroup = regexp.MustCompile(`^\s*\[([^\]]+)\]\s*`)
+	trailingTag  = regexp.MustCompile(`\s*\[([^\]]+)\]\s*$`)
+	crc32Tag     = regexp.MustCompile(`^[0-9A-Fa-f]{8}$`)
+	brackets     = strings.NewReplacer("[", " ", "]", " ", "(", " ", ")", " ", "{", " ", "}", " ")
+	separators   = regexp.MustCompile(`[\s._]+`)
+
+	// Dots that belong inside a token rather than separate two: "H.264",
+	// "DD5.1", "AAC2.0". They're swapped for dotMark before splitting
+	// and restored after.
+	dottedCodec    = regexp.MustCompile(`(?i)\b(h)\.(26[45])\b`)
+	dottedChannels = regexp.MustCompile(`(?i)\b((?:dd\+?|ddp|e?ac3|aac|dts|truehd|flac|opus)?)[ .]?([1-9])\.([0-2])\b`)
+
+	yearToken       = regexp.MustCompile(`^(19\d\d|20\d\d)$`)
+	resolutionToken = regexp.MustCompile(`(?i)^(\d{3,4})[pi]$`)
+	seasonEpisode   = regexp.MustCompile(`(?i)^s(\d{1,2})((?:-?e\d{1,3})+(?:-\d{1,3})?)?$`)
+	episodeRun      = regexp.MustCompile(`(?i)(-?)e?(\d{1,3})`)
+	crossEpisode    = regexp.MustCompile(`(?i)^(\d{1,2})x(\d{2,3})(?:-(?:\d{1,2}x)?(\d{2,3}))?$`)
+	bareEpisode     = regexp.MustCompile(`(?i)^(?:e|ep)(\d{1,4})$`)
+	absoluteEpisode = regexp.MustCompile(`^\d{1,4}$`)
+	// Fansub absolute numbering after " - ": "05", "13v2" (a fixed
+	// re-release), "01-02" (two in one file).
+	absoluteRun = regexp.MustCompile(`(?i)^(\d{1,4})(?:v\d)?(?:-(\d{1,4})(?:v\d)?)?$`)
+)
+
+const dotMark = "·"
+
+var sources = map[string]bool{
+	"bluray": true, "blu-ray": true, "bdrip": true, "brrip": true, "bdremux": true, "remux": true,
+	"web-dl": true, "webdl": true, "webrip": true, "web": true, "hdtv": true, "pdtv": true,
+	"dvdrip": true, "dvd": true, "dvdscr": true, "hdrip": true, "hdcam": true,
+}
+
+var codecs = map[string]bool{
+	"x264": true, "x265": true, "h264": true, "h265": true, "h" + dotMark + "264": true, "h" + dotMark + "265": true,
+	"hevc": true, "avc": true, "xvid": true, "divx": true, "av1": true, "vp9": true,
+}
+
+var audioPrefix = regexp.MustCompile(`(?i)^(aac|ac3|eac3|dd\+?|ddp|dts(-hd)?|dts-x|truehd|atmos|flac|opus|mp3)(\d` + dotMark + `\d)?$`)
+
+// other markers that end the title but aren't reported in Info.
+var noise = map[string]bool{
+	"hdr": true, "hdr10": true, "hdr10+": true, "dv": true, "dovi": true, "10bit": true, "8bit": true,
+	"multi": true, "dual-audio": true, "dubbed": true, "subbed": true, "complete": true, "internal": true,
+	"limited": true, "imax": true, "hc": true, "nf": true, "amzn": true, "dsnp": true, "hmax": true, "atvp": true,
+	"sample": true, "readnfo": true, "uhd": true, "4k": true,
+}
+
+// Parse parses a release or file name. A leading directory and a
+// trailing file extension are ignored.
+func Parse(name string) Info {
+	var info Info
+	name = stripExt(filepath.Base(name))
+
+	if m := leadingGroup.FindStringSubmatch(name); m != nil {
+		info.Group = m[1] // fansub style: "[Group] Show - 05 [1080p].mkv"
+		name = name[len(m[0]):]
+	}
+	for {
+		m := trailingTag.FindStringSubmatch(name)
+		if m == nil || !crc32Tag.MatchString(m[1]) {
+			break
+		}
+		name = name[:len(name)-len(m[0])]
+	}
+	if m := trailingTag.FindStringSubmatch(name); m != nil && info.Group == "" && !isMarker(strings.ToLower(m[1])) {
+		// "... [YTS.MX]"
+		info.Group = m[1]
+		name = name[:len(name)-len(m[0])]
+	}
+
+	name = dottedCodec.ReplaceAllString(name, "$1"+dotMark+"$2")
+	name = dottedChannels.ReplaceAllString(name, "$1$2"+dotMark+"$3")
+	tokens := separators.Split(strings.TrimSpace(brackets.Replace(name)), -1)
+
+	// "...x264-GROUP-sample": the sample's own marker, ahead of the group.
+	if last := tokens[len(tokens)-1]; len(tokens) > 1 && strings.HasSuffix(strings.ToLower(last), "-sample") {
+		info.Sample = true
+		tokens[len(tokens)-1] = last[:len(last)-len("-sample")]
+	}
+
+	// A trailing "-GROUP" only counts after something technical, so
+	// "The.Amazing.Spider-Man" keeps its hyphen.
+	if info.Group == "" && len(tokens) > 1 && hasMarker(tokens[:len(tokens)-1]) {
+		last := tokens[len(tokens)-1]
+		if i := strings.LastIndex(last, "-"); i > 0 && i < len(last)-1 && !isMarker(strings.ToLower(last)) {
+			info.Group = last[i+1:]
+			tokens[len(tokens)-1] = last[:i]
+		}
+	}
+
+	titleEnd := -1
+	yearAt := -1
+	for i, tok := range tokens {
+		lower := strings.ToLower(tok)
+		matched := true
+		switch {
+		case i > 0 && yearToken.MatchString(tok):
+			// Only a candidate: "2001.A.Space.Odyssey.1968" has two, and
+			// the last one before any other marker is the real year.
+			if titleEnd < 0 || info.Year == 0 {
+				info.Year, _ = strconv.Atoi(tok)
+				yearAt = i
+			}
+			matched = false
+		case resolutionToken.MatchString(tok):
+			if info.Resolution == "" {
+				info.Resolution = strings.ToLower(tok[:len(tok)-1]) + "p"
+			}
+		case lower == "4k" || lower == "uhd" || lower == "2160":
+			if info.Resolution == "" {
+				info.Resolution = "2160p"
+			}
+		case seasonEpisode.MatchString(tok):
+			m := seasonEpisode.FindStringSubmatch(tok)
+			info.Season, _ = strconv.Atoi(m[1])
+			info.Episodes = episodes(m[2])
+		case crossEpisode.MatchString(tok):
+			m := crossEpisode.FindStringSubmatch(tok)
+			info.Season, _ = strconv.Atoi(m[1])
+			info.Episodes = episodeRange(m[2], m[3])
+		case lower == "season" && i+1 < len(tokens) && absoluteEpisode.MatchString(tokens[i+1]):
+			info.Season, _ = strconv.Atoi(tokens[i+1])
+		case bareEpisode.MatchString(tok) && i > 0 && (titleEnd < 0 || seasonEpisode.MatchString(tokens[i-1])):
+			// "Show.E05", or "Show.S01.E05" split in two.
+			n, _ := strconv.Atoi(bareEpisode.FindStringSubmatch(tok)[1])
+			info.Episodes = []int{n}
+		case tok == "-" && i > 0 && i+1 < len(tokens) && absoluteRun.MatchString(tokens[i+1]) && !yearToken.MatchString(tokens[i+1]):
+			// Fansub absolute numbering: "Show - 05".
+			m := absoluteRun.FindStringSubmatch(tokens[i+1])
+			info.Episodes = episodeRange(m[1], m[2])
+		case sources[lower]:
+			if info.Source == "" {
+				info.Source = restore(tok)
+			}
+		case codecs[lower]:
+			if info.Codec == "" {
+				info.Codec = restore(tok)
+			}
+		case audioPrefix.MatchString(tok):
+			if info.Audio == "" {
+				info.Audio = restore(tok)
+			}
+		case lower == "proper":
+			info.Proper = true
+		case lower == "repack" || lower == "rerip":
+			info.Repack = true
+		case lower == "extended":
+			info.Extended = true
+		case lower == "unrated" || lower == "uncut":
+			info.Unrated = true
+		case lower == "remastered":
+			info.Remastered = true
+		case lower == "dc" || (lower == "directors" && i+1 < len(tokens) && strings.EqualFold(tokens[i+1], "cut")):
+			info.DirectorsCut = true
+		case lower == "sample":
+			info.Sample = true
+		case noise[lower]:
+		default:
+			matched = false
+		}
+		if matched && titleEnd < 0 {
+			titleEnd = i
+		}
+	}
+
+	switch {
+	case yearAt >= 0 && (titleEnd < 0 || yearAt < titleEnd):
+		titleEnd = yearAt
+	case titleEnd < 0:
+		titleEnd = len(tokens)
+	}
+	info.Title = strings.Trim(strings.Join(tokens[:titleEnd], " "), " -")
+	return info
+}
+
+// episodes parses the episode part of an SxxEyy token: "E05",
+// "E01E02", "E01-E03" and "E01-03" (the last two are ranges).
+func episodes(s string) []int {
+	var eps []int
+	for _, m := range episodeRun.FindAllStringSubmatch(s, -1) {
+		n, _ := strconv.Atoi(m[2])
+		if m[1] == "-" && len(eps) > 0 {
+			for e := eps[len(eps)-1] + 1; e < n; e++ {
+				eps = append(eps, e)
+			}
+		}
+		eps = append(eps, n)
+	}
+	return eps
+}
+
+// episodeRange is first to last, or just first if last is "".
+func episodeRange(first, last string) []int {
+	from, _ := strconv.Atoi(first)
+	to, _ := strconv.Atoi(last)
+	eps := []int{from}
+	for e := from + 1; e <= to; e++ {
+		eps = append(eps, e)
+	}
+	return eps
+}
+
+func isMarker(lower string) bool {
+	return sources[lower] || codecs[lower] || noise[lower] || resolutionToken.MatchString(lower) ||
+		audioPrefix.MatchString(lower) || strings.HasPrefix(lower, "dts-")
+}
+
+func hasMarker(tokens []string) bool {
+	for i, tok := range tokens {
+		lower := strings.ToLower(tok)
+		if isMarker(lower) || (i > 0 && yearToken.MatchString(tok)) || seasonEpisode.MatchString(tok) || crossEpisode.MatchString(tok) {
+			return true
+		}
+	}
+	return false
+}
+
+func restore(tok string) string { return strings.ReplaceAll(tok, dotMark, ".") }
+
+// stripExt drops a file extension, but not a trailing ".2024" or
+// ".720p" that's part of the release name itself.
+func stripExt(name string) string {
+	ext := filepath.Ext(name)
+	if len(ext) < 2 || len(ext) > 5 {
+		return name
+	}
+	for _, r := range ext[1:] {
+		if (r < 'a' || r > 'z') && (r < 'A' || r > 'Z') && (r < '0' || r > '9') {
+			return name
+		}
+	}
+	if _, err := strconv.Atoi(ext[1:]); err == nil || isMarker(strings.ToLower(ext[1:])) {
+		return name
+	}
+	return strings.TrimSuffix(name, ext)
+}
+
+// Obfuscated reports whether name looks like a random or hashed file
+// name ("a8f3e1c94b2d7710.mkv", a UUID) rather than a release name --
+// some uploaders rename files that way, which leaves nothing to search
+// for. Callers should fall back to the torrent's display name.
+func Obfuscated(name string) bool {
+	title := Parse(name).Title
+	if title == "" {
+		return true
+	}
+	if strings.Contains(title, " ") {
+		return false
+	}
+	var letters, digits int
+	for _, r := range title {
+		switch {
+		case r >= '0' && r <= '9':
+			digits++
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z':
+			letters++
+		}
+	}
+	switch {
+	case letters == 0:
+		return digits >= 6
+	case digits == 0:
+		return false
+	}
+	return letters+digits >= 12
+}
@@ -0,0 +1,114 @@
+package release
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	cases := []struct {
+		name string
+		want Info
+	}{
+		// Movies.
+		{"Some.Movie.2024.1080p.WEB-DL.x264.mkv", Info{Title: "Some Movie", Year: 2024, Resolution: "1080p", Source: "WEB-DL", Codec: "x264"}},
+		{"Another_Movie_720p_BluRay.mp4", Info{Title: "Another Movie", Resolution: "720p", Source: "BluRay"}},
+		{"plain name.mkv", Info{Title: "plain name"}},
+		{"The.Matrix.1999.1080p.BluRay.x264-SPARKS.mkv", Info{Title: "The Matrix", Year: 1999, Resolution: "1080p", Source: "BluRay", Codec: "x264", Group: "SPARKS"}},
+		{"2001.A.Space.Odyssey.1968.2160p.UHD.BluRay.x265-TERMiNAL", Info{Title: "2001 A Space Odyssey", Year: 1968, Resolution: "2160p", Source: "BluRay", Codec: "x265", Group: "TERMiNAL"}},
+		{"Blade.Runner.2049.2017.1080p.WEB-DL.DD5.1.H.264-FGT.mkv", Info{Title: "Blade Runner 2049", Year: 2017, Resolution: "1080p", Source: "WEB-DL", Codec: "H.264", Audio: "DD5.1", Group: "FGT"}},
+		{"1917.2019.720p.BRRip.XviD.AC3-EVO.avi", Info{Title: "1917", Year: 2019, Resolution: "720p", Source: "BRRip", Codec: "XviD", Audio: "AC3", Group: "EVO"}},
+		{"Movie Title (2020) [1080p] [BluRay] [5.1] [YTS.MX].mp4", Info{Title: "Movie Title", Year: 2020, Resolution: "1080p", Source: "BluRay", Group: "YTS.MX"}},
+		{"The.Amazing.Spider-Man.2012.720p.BluRay.x264.mkv", Info{Title: "The Amazing Spider-Man", Year: 2012, Resolution: "720p", Source: "BluRay", Codec: "x264"}},
+		{"The.Amazing.Spider-Man.mkv", Info{Title: "The Amazing Spider-Man"}},
+		{"Aliens.1986.Extended.Remastered.1080p.BluRay.DTS-HD.MA.5.1.x264", Info{Title: "Aliens", Year: 1986, Resolution: "1080p", Source: "BluRay", Codec: "x264", Audio: "DTS-HD", Extended: true, Remastered: true}},
+		{"Blade.Runner.1982.Directors.Cut.1080p.BluRay.x264", Info{Title: "Blade Runner", Year: 1982, Resolution: "1080p", Source: "BluRay", Codec: "x264", DirectorsCut: true}},
+		{"Some.Movie.2010.PROPER.REPACK.720p.HDTV.x264-GRP", Info{Title: "Some Movie", Year: 2010, Resolution: "720p", Source: "HDTV", Codec: "x264", Group: "GRP", Proper: true, Repack: true}},
+		{"Film.2015.UNRATED.1080p.WEBRip.AAC2.0.x264", Info{Title: "Film", Year: 2015, Resolution: "1080p", Source: "WEBRip", Codec: "x264", Audio: "AAC2.0", Unrated: true}},
+		{"Movie.2021.2160p.AMZN.WEB-DL.DDP5.1.HDR.HEVC-GROUP", Info{Title: "Movie", Year: 2021, Resolution: "2160p", Source: "WEB-DL", Codec: "HEVC", Audio: "DDP5.1", Group: "GROUP"}},
+		{"Movie.2019.4K.HDR.x265", Info{Title: "Movie", Year: 2019, Resolution: "2160p", Codec: "x265"}},
+		{"movie.2008.dvdrip.xvid.avi", Info{Title: "movie", Year: 2008, Source: "dvdrip", Codec: "xvid"}},
+		{"Movie.Title.2023", Info{Title: "Movie Title", Year: 2023}},
+		{"/downloads/Some Dir/Movie.Title.2023.1080p.mkv", Info{Title: "Movie Title", Year: 2023, Resolution: "1080p"}},
+		{"Movie.Title.2023.TrueHD.Atmos.1080p", Info{Title: "Movie Title", Year: 2023, Resolution: "1080p", Audio: "TrueHD"}},
+		{"Dune Part Two 2024 1080p WEBRip x265", Info{Title: "Dune Part Two", Year: 2024, Resolution: "1080p", Source: "WEBRip", Codec: "x265"}},
+
+		// Episodes.
+		{"Show.S02E05.1080p.WEB.h264-GROUP.mkv", Info{Title: "Show", Season: 2, Episodes: []int{5}, Resolution: "1080p", Source: "WEB", Codec: "h264", Group: "GROUP"}},
+		{"Show.Name.s01e01.720p.hdtv.x264", Info{Title: "Show Name", Season: 1, Episodes: []int{1}, Resolution: "720p", Source: "hdtv", Codec: "x264"}},
+		{"Show.Name.S01E01E02.720p", Info{Title: "Show Name", Season: 1, Episodes: []int{1, 2}, Resolution: "720p"}},
+		{"Show.Name.S01E01-E03.720p", Info{Title: "Show Name", Season: 1, Episodes: []int{1, 2, 3}, Resolution: "720p"}},
+		{"Show.Name.S01E01-03.720p", Info{Title: "Show Name", Season: 1, Episodes: []int{1, 2, 3}, Resolution: "720p"}},
+		{"Show.Name.1x05.HDTV", Info{Title: "Show Name", Season: 1, Episodes: []int{5}, Source: "HDTV"}},
+		{"Show.Name.2019.S03E10.1080p", Info{Title: "Show Name", Year: 2019, Season: 3, Episodes: []int{10}, Resolution: "1080p"}},
+		{"Show.Name.S04.1080p.BluRay.x264", Info{Title: "Show Name", Season: 4, Resolution: "1080p", Source: "BluRay", Codec: "x264"}},
+		{"Show Name Season 2 Complete 720p", Info{Title: "Show Name", Season: 2, Resolution: "720p"}},
+		{"Show.Name.S10E100.REPACK.720p", Info{Title: "Show Name", Season: 10, Episodes: []int{100}, Resolution: "720p", Repack: true}},
+
+		// Fansub style.
+		{"[SubsPlease] Some Anime - 05 (1080p) [A1B2C3D4].mkv", Info{Title: "Some Anime", Episodes: []int{5}, Resolution: "1080p", Group: "SubsPlease"}},
+		{"[Group] Other Show - 112 [720p].mkv", Info{Title: "Other Show", Episodes: []int{112}, Resolution: "720p", Group: "Group"}},
+		{"[Fansub] Anime Title S2 - 03 [1080p HEVC]", Info{Title: "Anime Title", Season: 2, Episodes: []int{3}, Resolution: "1080p", Codec: "HEVC", Group: "Fansub"}},
+		{"[Erai-raws] One Piece - 1071 [1080p][Multiple Subtitle].mkv", Info{Title: "One Piece", Episodes: []int{1071}, Resolution: "1080p", Group: "Erai-raws"}},
+		{"[SubsPlease] Some Anime - 01-02 (1080p).mkv", Info{Title: "Some Anime", Episodes: []int{1, 2}, Resolution: "1080p", Group: "SubsPlease"}},
+		{"Some Anime - 13v2 [720p].mkv", Info{Title: "Some Anime", Episodes: []int{13}, Resolution: "720p"}},
+		{"One.Piece.E1071.1080p", Info{Title: "One Piece", Episodes: []int{1071}, Resolution: "1080p"}},
+
+		// Multi-episode, and episodes written other ways.
+		{"Show.S01E01E02E03.1080p", Info{Title: "Show", Season: 1, Episodes: []int{1, 2, 3}, Resolution: "1080p"}},
+		{"Show.Name.S02E09-E10.720p.WEB", Info{Title: "Show Name", Season: 2, Episodes: []int{9, 10}, Resolution: "720p", Source: "WEB"}},
+		{"Show.Name.1x01-02.HDTV", Info{Title: "Show Name", Season: 1, Episodes: []int{1, 2}, Source: "HDTV"}},
+		{"Show.Name.S01.E01.720p", Info{Title: "Show Name", Season: 1, Episodes: []int{1}, Resolution: "720p"}},
+
+		// A year as the whole title, or as the title and the year.
+		{"2012.mkv", Info{Title: "2012"}},
+		{"2012.2009.1080p.BluRay.mkv", Info{Title: "2012", Year: 2009, Resolution: "1080p", Source: "BluRay"}},
+		{"1917 (2019).mp4", Info{Title: "1917", Year: 2019}},
+
+		// Samples.
+		{"Show.S01E01.Sample.mkv", Info{Title: "Show", Season: 1, Episodes: []int{1}, Sample: true}},
+		{"show.s01e01.720p.hdtv.x264-grp-sample.mkv", Info{Title: "show", Season: 1, Episodes: []int{1}, Resolution: "720p", Source: "hdtv", Codec: "x264", Group: "grp", Sample: true}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := Parse(c.name)
+			if !reflect.DeepEqual(got, c.want) {
+				t.Errorf("Parse(%q) =\n  %#v\nwant\n  %#v", c.name, got, c.want)
+			}
+		})
+	}
+}
+
+func TestInfoString(t *testing.T) {
+	cases := map[string]Info{
+		"Some Movie (2024)":  {Title: "Some Movie", Year: 2024},
+		"Show S02E05":        {Title: "Show", Season: 2, Episodes: []int{5}},
+		"Show S01E01-E03":    {Title: "Show", Season: 1, Episodes: []int{1, 2, 3}},
+		"Show S04":           {Title: "Show", Season: 4},
+		"Show S01E02 (2019)": {Title: "Show", Year: 2019, Season: 1, Episodes: []int{2}},
+		"plain name":         {Title: "plain name"},
+	}
+	for want, info := range cases {
+		if got := info.String(); got != want {
+			t.Errorf("%+v.String() = %q, want %q", info, got, want)
+		}
+	}
+}
+
+func TestObfuscated(t *testing.T) {
+	cases := map[string]bool{
+		"a8f3e1c94b2d7710.mkv":                     true,
+		"3f2504e0-4f89-11d3-9a0c-0305e82c3301.mp4": true,
+		"1234567.mkv":                              true,
+		"Some.Movie.2024.1080p.WEB-DL.x264.mkv":    false,
+		"Se7en.1995.1080p.mkv":                     false,
+		"Terminator2.mkv":                          false,
+		"movie.mkv":                                false,
+		"1917.2019.720p.mkv":                       false,
+	}
+	for name, want := range cases {
+		if got := Obfuscated(name); got != want {
+			t.Errorf("Obfuscated(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
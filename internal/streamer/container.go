@@ -0,0 +1,106 @@
+package streamer
+
+import (
+	"context"
+	"errors"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/anacrolix/torrent"
+
+	"go-watch-something/internal/avi"
+	"go-watch-something/internal/mkv"
+	"go-watch-something/internal/mp4"
+)
+
+// Span is a stretch of a file: Size bytes from Offset.
+type Span struct {
+	Offset, Size int64
+}
+
+// IndexSpans finds the parts of f a player reads before it can start
+// or seek -- an MP4's moov, an MKV's header and Cues, an AVI's header
+// list and idx1 -- and says what they are. Often they're at the end of
+// the file, where a front-to-back download gets last. Only box, element
+// and chunk headers are read, waiting at most indexTimeout. Other
+// containers get no spans.
+func IndexSpans(f *torrent.File) ([]Span, string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), indexTimeout)
+	defer cancel()
+	r := f.NewReader()
+	defer r.Close()
+	return indexSpans(contextReader{ctx: ctx, ReadSeeker: r}, f.Path())
+}
+
+// indexSpans is IndexSpans on any ReadSeeker over a file at path.
+func indexSpans(rs io.ReadSeeker, path string) ([]Span, string, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".mkv", ".webm", ".mka":
+		header, cues, err := mkv.IndexSpans(rs)
+		switch {
+		case errors.Is(err, mkv.ErrNoCues) && header.Size > 0:
+			return []Span{{header.Offset, header.Size}}, "MKV header (no cues)", nil
+		case err != nil:
+			return nil, "", err
+		}
+		return []Span{{header.Offset, header.Size}, {cues.Offset, cues.Size}}, "MKV header and cues", nil
+
+	case ".mp4", ".m4v", ".mov":
+		boxes, err := mp4.Boxes(rs)
+		if len(boxes) == 0 {
+			return nil, "", err
+		}
+		// Everything but the media data and padding is metadata: ftyp,
+		// moov and the like.
+		var spans []Span
+		what := "MP4 header"
+		for _, b := range boxes {
+			switch b.Type {
+			case "mdat", "free", "skip", "wide":
+				continue
+			case "moov":
+				what = "MP4 moov"
+			}
+			spans = append(spans, Span{b.Offset, b.Size})
+		}
+		return spans, what, nil
+
+	case ".avi":
+		chunks, err := avi.Chunks(rs)
+		if len(chunks) == 0 {
+			return nil, "", err
+		}
+		var spans []Span
+		what := "AVI header"
+		for _, c := range chunks {
+			switch {
+			case c.ID == "LIST" && c.List == "hdrl":
+			case c.ID == "idx1":
+				what = "AVI header and idx1"
+			default:
+				continue
+			}
+			spans = append(spans, Span{c.Offset, c.Size})
+		}
+		return spans, what, nil
+	}
+	return nil, "", nil
+}
+
+// spanPieces returns the pieces holding any of spans in f, each once,
+// in the order spans lists them.
+func spanPieces(f *torrent.File, spans []Span) []int {
+	seen := map[int]bool{}
+	var pieces []int
+	for _, s := range spans {
+		begin, end := filePieces(f, s.Offset, s.Size)
+		for i := begin; i < end; i++ {
+			if !seen[i] {
+				seen[i] = true
+				pieces = append(pieces, i)
+			}
+		}
+	}
+	return pieces
+}
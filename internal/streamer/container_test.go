@@ -0,0 +1,50 @@
+package streamer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+func TestIndexSpans(t *testing.T) {
+	box := func(typ string, n int) []byte { // big-endian size, then type
+		b := make([]byte, n)
+		binary.BigEndian.PutUint32(b, uint32(n))
+		copy(b[4:], typ)
+		return b
+	}
+	chunk := func(id, list string, n int) []byte { // little-endian size of what follows
+		b := make([]byte, n)
+		copy(b, id)
+		binary.LittleEndian.PutUint32(b[4:], uint32(n-8))
+		copy(b[8:], list)
+		return b
+	}
+	mp4 := bytes.Join([][]byte{box("ftyp", 16), box("mdat", 1000), box("free", 8), box("moov", 100)}, nil)
+	avi := append([]byte("RIFF\x00\x00\x00\x00AVI "), bytes.Join([][]byte{chunk("LIST", "hdrl", 200), chunk("LIST", "movi", 1000), chunk("idx1", "", 64)}, nil)...)
+	binary.LittleEndian.PutUint32(avi[4:], uint32(len(avi)-8))
+
+	cases := []struct {
+		path  string
+		file  []byte
+		spans []Span
+		what  string
+	}{
+		{"film.mp4", mp4, []Span{{0, 16}, {1024, 100}}, "MP4 moov"},
+		{"film.avi", avi, []Span{{12, 200}, {1212, 64}}, "AVI header and idx1"},
+		{"film.ts", mp4, nil, ""},
+	}
+	for _, c := range cases {
+		spans, what, err := indexSpans(bytes.NewReader(c.file), c.path)
+		if err != nil || !reflect.DeepEqual(spans, c.spans) || what != c.what {
+			t.Errorf("indexSpans(%s) = %v, %q, %v; want %v, %q", c.path, spans, what, err, c.spans, c.what)
+		}
+	}
+}
+
+func TestIndexSpans_NotTheContainerItSays(t *testing.T) {
+	if _, _, err := indexSpans(bytes.NewReader([]byte("not a video at all")), "film.mkv"); err == nil {
+		t.Error("indexSpans of a bogus .mkv: no error")
+	}
+}
@@ -0,0 +1,25 @@
+package streamer
+
+import (
+	"context"
+	"time"
+
+	"github.com/anacrolix/torrent"
+
+	"go-watch-something/internal/subtitles"
+)
+
+// hashTimeout bounds how long MovieHash waits for the last pieces of
+// the file, which nothing else asks for this early.
+const hashTimeout = 30 * time.Second
+
+// MovieHash computes f's OpenSubtitles movie hash (see
+// subtitles.MovieHash). The first and last 64 KiB have to arrive from
+// the swarm first; it gives up after hashTimeout.
+func MovieHash(f *torrent.File) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), hashTimeout)
+	defer cancel()
+	r := f.NewReader()
+	defer r.Close()
+	return subtitles.MovieHash(contextReader{ctx: ctx, ReadSeeker: r}, f.Length())
+}
@@ -0,0 +1,92 @@
+package streamer
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"go-watch-something/internal/subfile"
+)
+
+// serveMerged serves GET /subs/merged?langs=en,pt-BR[&format=vtt]: the
+// two languages' subtitles stacked into one track, the second under
+// the first (see subfile.Merge) -- for watching with a language you're
+// learning alongside one you know. The format defaults to SRT.
+func (h *subsHandler) serveMerged(w http.ResponseWriter, r *http.Request) {
+	langs := strings.Split(r.URL.Query().Get("langs"), ",")
+	if len(langs) != 2 || langs[0] == "" || langs[1] == "" {
+		http.Error(w, "langs must name exactly two languages, e.g. langs=en,pt-BR", http.StatusBadRequest)
+		return
+	}
+	ext := ".srt"
+	switch f := r.URL.Query().Get("format"); f {
+	case "", "srt":
+	case "vtt":
+		ext = ".vtt"
+	default:
+		http.Error(w, fmt.Sprintf("Unsupported format %q: want srt or vtt", f), http.StatusBadRequest)
+		return
+	}
+
+	entries, err := h.entries()
+	if err != nil {
+		http.Error(w, "Failed to list subtitles", http.StatusInternalServerError)
+		return
+	}
+	var tracks [2][]subfile.Cue
+	for i, lang := range langs {
+		e, ok := pickEntry(entries, strings.TrimSpace(lang))
+		if !ok {
+			http.Error(w, fmt.Sprintf("No %s subtitle available", lang), http.StatusNotFound)
+			return
+		}
+		if tracks[i], err = h.entryCues(r, e); err != nil {
+			if r.Context().Err() == nil {
+				log.Printf("Loading %s for merged subtitles: %v", e.Name, err)
+				http.Error(w, fmt.Sprintf("Failed to read %s subtitle %s", lang, e.Name), http.StatusInternalServerError)
+			}
+			return
+		}
+	}
+	writeCues(w, subfile.Merge(tracks[0], tracks[1]), ext)
+}
+
+// entryCues loads e's cues, whether it's a file in dir or a track
+// embedded in the video.
+func (h *subsHandler) entryCues(r *http.Request, e subEntry) ([]subfile.Cue, error) {
+	if e.Provider == "embedded" {
+		return h.embedded[strings.TrimSuffix(e.Name, ".srt")].load(r.Context())
+	}
+	return h.readCues(e.Name)
+}
+
+// pickEntry finds a subtitle in lang that can be parsed. An exact
+// language match beats a looser one ("pt" for "pt-BR", or the other
+// way round), and a regular subtitle beats a hearing-impaired one,
+// whose sound descriptions only get in the way when stacked.
+func pickEntry(entries []subEntry, lang string) (subEntry, bool) {
+	best, bestRank := subEntry{}, 0
+	base := func(l string) string { b, _, _ := strings.Cut(l, "-"); return strings.ToLower(b) }
+	for _, e := range entries {
+		if e.Format == "sub" || e.Format == "idx" {
+			continue
+		}
+		rank := 0
+		switch {
+		case strings.EqualFold(e.Lang, lang):
+			rank = 4
+		case e.Lang != "" && base(e.Lang) == base(lang):
+			rank = 2
+		default:
+			continue
+		}
+		if !e.HearingImpaired {
+			rank++
+		}
+		if rank > bestRank {
+			best, bestRank = e, rank
+		}
+	}
+	return best, bestRank > 0
+}
@@ -0,0 +1,57 @@
+package streamer
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSubsHandler_MergedStacksTwoLanguages(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "movie.en.srt"), []byte("1\n00:00:01,000 --> 00:00:03,000\nHello.\n"), 0o644)
+	os.WriteFile(filepath.Join(dir, "movie.en.hi.srt"), []byte("1\n00:00:01,000 --> 00:00:03,000\n[door creaks] Hello.\n"), 0o644)
+	os.WriteFile(filepath.Join(dir, "movie.pt-BR.srt"), []byte("1\n00:00:01,200 --> 00:00:02,800\nOlá.\n"), 0o644)
+	h := &subsHandler{dir: dir}
+
+	cases := []struct {
+		url, want string
+	}{
+		{"/subs/merged?langs=en,pt-BR", "1\n00:00:01,000 --> 00:00:03,000\nHello.\nOlá.\n\n"},
+		{"/subs/merged?langs=pt-BR,en&format=vtt", "WEBVTT\n\n00:00:01.200 --> 00:00:02.800\nOlá.\nHello.\n\n"},
+		{"/subs/merged?langs=en,pt", "1\n00:00:01,000 --> 00:00:03,000\nHello.\nOlá.\n\n"},
+	}
+	for _, c := range cases {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, c.url, nil))
+		if rec.Code != http.StatusOK {
+			t.Errorf("GET %s status = %d (%s), want 200", c.url, rec.Code, rec.Body.String())
+			continue
+		}
+		if rec.Body.String() != c.want {
+			t.Errorf("GET %s body = %q, want %q", c.url, rec.Body.String(), c.want)
+		}
+	}
+}
+
+func TestSubsHandler_MergedErrors(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "movie.en.srt"), []byte("1\n00:00:01,000 --> 00:00:03,000\nHello.\n"), 0o644)
+	h := &subsHandler{dir: dir}
+
+	cases := map[string]int{
+		"/subs/merged":                        http.StatusBadRequest,
+		"/subs/merged?langs=en":               http.StatusBadRequest,
+		"/subs/merged?langs=en,fr,de":         http.StatusBadRequest,
+		"/subs/merged?langs=en,en&format=ass": http.StatusBadRequest,
+		"/subs/merged?langs=en,fr":            http.StatusNotFound,
+	}
+	for url, want := range cases {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, url, nil))
+		if rec.Code != want {
+			t.Errorf("GET %s status = %d, want %d", url, rec.Code, want)
+		}
+	}
+}
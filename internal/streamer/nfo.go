@@ -0,0 +1,52 @@
+package streamer
+
+import (
+	"context"
+	"io"
+	"log"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/anacrolix/torrent"
+)
+
+// maxNFOSize skips anything too big to be a real .nfo -- they're a few
+// KiB of ASCII art and text.
+const maxNFOSize = 64 << 10
+
+// nfoTimeout bounds how long ReadNFOs waits on peers. The .nfo is only a
+// nice-to-have for subtitle lookup; it mustn't hold up the stream.
+const nfoTimeout = 20 * time.Second
+
+// ReadNFOs downloads and returns the contents of the torrent's small
+// .nfo files. It runs in the background subtitle fetch, alongside the
+// video's download, so their pieces are put ahead of everything else
+// while they're read. Files that can't be read in time are skipped.
+func ReadNFOs(t *torrent.Torrent) [][]byte {
+	ctx, cancel := context.WithTimeout(context.Background(), nfoTimeout)
+	defer cancel()
+
+	var nfos [][]byte
+	for _, f := range t.Files() {
+		if !strings.EqualFold(filepath.Ext(f.Path()), ".nfo") || f.Length() > maxNFOSize {
+			continue
+		}
+		data, err := readFile(ctx, f)
+		if err != nil {
+			log.Printf("Skipping %s: %v", f.Path(), err)
+			continue
+		}
+		nfos = append(nfos, data)
+	}
+	return nfos
+}
+
+func readFile(ctx context.Context, f *torrent.File) ([]byte, error) {
+	prio := f.Priority()
+	f.SetPriority(torrent.PiecePriorityNow)
+	defer f.SetPriority(prio)
+	r := f.NewReader()
+	defer r.Close()
+	return io.ReadAll(contextReader{ctx: ctx, ReadSeeker: r})
+}
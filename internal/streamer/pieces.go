@@ -0,0 +1,45 @@
+package streamer
+
+import (
+	"github.com/anacrolix/torrent"
+)
+
+// pieceSpan returns the torrent's piece indices [begin, end) holding
+// bytes [off, off+n) of a file that starts fileOffset bytes into the
+// torrent and is fileLen long. The range is clipped to the file.
+func pieceSpan(fileOffset, fileLen, pieceLen, off, n int64) (begin, end int) {
+	off = max(off, 0)
+	stop := min(off+n, fileLen)
+	if stop <= off || pieceLen <= 0 {
+		return 0, 0
+	}
+	return int((fileOffset + off) / pieceLen), int((fileOffset + stop + pieceLen - 1) / pieceLen)
+}
+
+// filePieces returns the pieces holding bytes [off, off+n) of f.
+func filePieces(f *torrent.File, off, n int64) (begin, end int) {
+	return pieceSpan(f.Offset(), f.Length(), f.Torrent().Info().PieceLength, off, n)
+}
+
+// rangeReady reports whether bytes [off, off+n) of f are all
+// downloaded and verified.
+func rangeReady(f *torrent.File, off, n int64) bool {
+	t := f.Torrent()
+	begin, end := filePieces(f, off, n)
+	for i := begin; i < end; i++ {
+		if !t.Piece(i).State().Complete {
+			return false
+		}
+	}
+	return true
+}
+
+// setRangePriority sets the priority of the pieces holding bytes
+// [off, off+n) of f.
+func setRangePriority(f *torrent.File, off, n int64, prio torrent.PiecePriority) {
+	t := f.Torrent()
+	begin, end := filePieces(f, off, n)
+	for i := begin; i < end; i++ {
+		t.Piece(i).SetPriority(prio)
+	}
+}
@@ -0,0 +1,26 @@
+package streamer
+
+import "testing"
+
+func TestPieceSpan(t *testing.T) {
+	cases := []struct {
+		name                        string
+		fileOffset, fileLen, off, n int64
+		wantBegin, wantEnd          int
+	}{
+		{"first piece", 0, 1000, 0, 10, 0, 1},
+		{"straddles a boundary", 0, 1000, 95, 10, 0, 2},
+		{"ends on a boundary", 0, 1000, 100, 100, 1, 2},
+		{"file starts mid-torrent", 250, 1000, 0, 100, 2, 4},
+		{"clipped to the file", 0, 1000, 950, 500, 9, 10},
+		{"negative offset clamped", 0, 1000, -50, 100, 0, 1},
+		{"past the end", 0, 1000, 1000, 10, 0, 0},
+		{"empty", 0, 1000, 10, 0, 0, 0},
+	}
+	for _, c := range cases {
+		b, e := pieceSpan(c.fileOffset, c.fileLen, 100, c.off, c.n)
+		if b != c.wantBegin || e != c.wantEnd {
+			t.Errorf("%s: pieceSpan = [%d, %d), want [%d, %d)", c.name, b, e, c.wantBegin, c.wantEnd)
+		}
+	}
+}
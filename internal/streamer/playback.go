@@ -0,0 +1,134 @@
+package streamer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/anacrolix/torrent"
+)
+
+// Playback is a player reporting where playback is and taking pause
+// commands -- player.MPV, player.VLC and player.Kodi implement it. VLC
+// and Kodi don't say how much they've buffered; their CacheAhead
+// returns errors.ErrUnsupported.
+type Playback interface {
+	Position() (time.Duration, error)
+	Duration() (time.Duration, error)
+	CacheAhead() (time.Duration, error) // how far ahead of Position the player has read
+	Paused() (bool, error)
+	SetPause(bool) error
+}
+
+const (
+	followInterval = time.Second
+	// followAhead is how much playback time past where the player is
+	// reading gets top priority.
+	followAhead = 60 * time.Second
+	// lowWater: the player is paused once it has less than this
+	// buffered and the next stretch isn't downloaded either...
+	lowWater = 3 * time.Second
+	// resumeAhead: ...and resumed once this much is.
+	resumeAhead = 20 * time.Second
+)
+
+// FollowPlayback keeps the swarm on what the player is about to play:
+// every second, the pieces from where it's reading to followAhead past
+// that are put first. When its buffer runs dry on pieces that aren't
+// here yet, playback is paused until resumeAhead's worth has arrived,
+// rather than stuttering. A pause the user made is left alone. Byte
+// offsets are estimated from position over duration, so a constant
+// bitrate is assumed. Runs until ctx is done.
+func FollowPlayback(ctx context.Context, p Playback, f *torrent.File) {
+	fl := &follower{
+		length: f.Length(),
+		ready:  func(off, n int64) bool { return rangeReady(f, off, n) },
+		focus: func(off, n int64) {
+			// The next few seconds are needed now; the rest soon.
+			setRangePriority(f, off, n, torrent.PiecePriorityReadahead)
+			setRangePriority(f, off, n/10, torrent.PiecePriorityNow)
+		},
+		unfocus: func(off, n int64) { setRangePriority(f, off, n, torrent.PiecePriorityNormal) },
+		logf:    func(format string, args ...any) { fmt.Printf(format+"\n", args...) },
+	}
+	tick := time.NewTicker(followInterval)
+	defer tick.Stop()
+	for {
+		fl.step(p)
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+		}
+	}
+}
+
+// follower is FollowPlayback's state between steps, with the torrent
+// behind functions so it can be tested without one.
+type follower struct {
+	length  int64
+	ready   func(off, n int64) bool
+	focus   func(off, n int64)
+	unfocus func(off, n int64)
+	logf    func(format string, args ...any)
+
+	window     [2]int64 // the focused range, as off, n
+	pausedByUs bool
+}
+
+// step looks at the player once. Anything it can't read (nothing
+// loaded yet, or the player gone) skips the step.
+func (fl *follower) step(p Playback) {
+	pos, err := p.Position()
+	if err != nil {
+		return
+	}
+	dur, err := p.Duration()
+	if err != nil || dur <= 0 {
+		return
+	}
+	cache, err := p.CacheAhead()
+	cacheUnknown := errors.Is(err, errors.ErrUnsupported)
+	if err != nil {
+		cache = 0
+	}
+	paused, err := p.Paused()
+	if err != nil {
+		return
+	}
+
+	rate := float64(fl.length) / dur.Seconds() // bytes per second of playback
+	bytesFor := func(d time.Duration) int64 { return int64(rate * d.Seconds()) }
+	at := bytesFor(pos + cache) // where the player is reading
+
+	if w := [2]int64{at, bytesFor(followAhead)}; w != fl.window {
+		if fl.window[1] > 0 {
+			fl.unfocus(fl.window[0], fl.window[1])
+		}
+		fl.focus(w[0], w[1])
+		fl.window = w
+	}
+
+	dry := cache < lowWater && !fl.ready(at, bytesFor(lowWater))
+	if cacheUnknown {
+		// Whatever the player has buffered is a mystery, so only
+		// pieces missing right where it's playing -- before the next
+		// step -- count as running dry.
+		dry = !fl.ready(at, bytesFor(followInterval))
+	}
+	switch {
+	case !paused && dry:
+		if p.SetPause(true) == nil {
+			fl.pausedByUs = true
+			fl.logf("Buffer ran dry at %s; pausing until it catches up.", pos.Round(time.Second))
+		}
+	case paused && fl.pausedByUs && fl.ready(at, bytesFor(resumeAhead)):
+		if p.SetPause(false) == nil {
+			fl.pausedByUs = false
+			fl.logf("Buffered %s ahead; resuming.", resumeAhead)
+		}
+	case !paused:
+		fl.pausedByUs = false
+	}
+}
@@ -0,0 +1,128 @@
+package streamer
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// fakePlayback is a Playback with fixed readings that records pauses.
+type fakePlayback struct {
+	pos, dur, cache time.Duration
+	paused          bool
+	err             error
+	cacheErr        error // CacheAhead's, if p.err isn't set
+	pauses          []bool
+}
+
+func (p *fakePlayback) Position() (time.Duration, error) { return p.pos, p.err }
+func (p *fakePlayback) Duration() (time.Duration, error) { return p.dur, p.err }
+func (p *fakePlayback) CacheAhead() (time.Duration, error) {
+	if p.err != nil {
+		return 0, p.err
+	}
+	return p.cache, p.cacheErr
+}
+func (p *fakePlayback) Paused() (bool, error) { return p.paused, p.err }
+func (p *fakePlayback) SetPause(b bool) error {
+	p.paused = b
+	p.pauses = append(p.pauses, b)
+	return nil
+}
+
+// testFollower follows a 1000s file of 1000 bytes a second, with
+// everything below have downloaded.
+func testFollower(have *int64, focused *[2]int64) *follower {
+	return &follower{
+		length:  1_000_000,
+		ready:   func(off, n int64) bool { return off+n <= *have },
+		focus:   func(off, n int64) { *focused = [2]int64{off, n} },
+		unfocus: func(off, n int64) {},
+		logf:    func(string, ...any) {},
+	}
+}
+
+func TestFollower_FocusesWhereThePlayerReads(t *testing.T) {
+	var have int64 = 1_000_000
+	var focused [2]int64
+	fl := testFollower(&have, &focused)
+	fl.step(&fakePlayback{pos: 100 * time.Second, cache: 10 * time.Second, dur: 1000 * time.Second})
+	if want := [2]int64{110_000, 60_000}; focused != want {
+		t.Errorf("focused %v, want %v", focused, want)
+	}
+}
+
+func TestFollower_PausesOnUnderrunAndResumes(t *testing.T) {
+	var have int64 = 100_500
+	var focused [2]int64
+	fl := testFollower(&have, &focused)
+	p := &fakePlayback{pos: 100 * time.Second, cache: time.Second, dur: 1000 * time.Second}
+
+	fl.step(p)
+	if !p.paused {
+		t.Fatal("not paused with 1s buffered and the next 3s missing")
+	}
+	have = 110_000 // 9s ahead, short of resumeAhead
+	fl.step(p)
+	if !p.paused {
+		t.Fatal("resumed before resumeAhead was downloaded")
+	}
+	have = 200_000
+	fl.step(p)
+	if p.paused {
+		t.Fatal("still paused with resumeAhead downloaded")
+	}
+	if want := []bool{true, false}; !reflect.DeepEqual(p.pauses, want) {
+		t.Errorf("pauses = %v, want %v", p.pauses, want)
+	}
+}
+
+func TestFollower_LeavesTheUsersPauseAlone(t *testing.T) {
+	var have int64 = 1_000_000
+	var focused [2]int64
+	fl := testFollower(&have, &focused)
+	p := &fakePlayback{pos: 100 * time.Second, dur: 1000 * time.Second, paused: true}
+	fl.step(p)
+	if len(p.pauses) != 0 {
+		t.Errorf("pauses = %v, want the user's pause untouched", p.pauses)
+	}
+}
+
+func TestFollower_DoesNotPauseWithPiecesAhead(t *testing.T) {
+	var have int64 = 1_000_000
+	var focused [2]int64
+	fl := testFollower(&have, &focused)
+	p := &fakePlayback{pos: 100 * time.Second, dur: 1000 * time.Second}
+	fl.step(p) // the player's buffer is empty, but the pieces are here
+	if p.paused {
+		t.Error("paused although the next bytes are downloaded")
+	}
+}
+
+func TestFollower_WithoutCacheReadingGoesByThePosition(t *testing.T) {
+	var have int64 = 102_000 // 2s past the position: short of lowWater, past a step
+	var focused [2]int64
+	fl := testFollower(&have, &focused)
+	p := &fakePlayback{pos: 100 * time.Second, dur: 1000 * time.Second, cacheErr: errors.ErrUnsupported}
+	fl.step(p)
+	if p.paused {
+		t.Fatal("paused a player that doesn't report its cache, with the next step's pieces here")
+	}
+	have = 100_500
+	fl.step(p)
+	if !p.paused {
+		t.Error("not paused with pieces missing right at the position")
+	}
+}
+
+func TestFollower_SkipsWhenNothingIsLoaded(t *testing.T) {
+	var have int64
+	var focused [2]int64
+	fl := testFollower(&have, &focused)
+	p := &fakePlayback{err: errors.New("property unavailable")}
+	fl.step(p)
+	if len(p.pauses) != 0 || focused != [2]int64{} {
+		t.Errorf("acted without readings: pauses %v, focused %v", p.pauses, focused)
+	}
+}
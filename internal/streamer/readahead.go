@@ -0,0 +1,348 @@
+package streamer
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/anacrolix/torrent"
+)
+
+const (
+	// readaheadTime is how much playback time past where each client
+	// is reading gets fetched first, when the swarm keeps up with the
+	// bitrate. A slower swarm gets proportionally more, so more pieces
+	// are in flight across its peers.
+	readaheadTime = 30 * time.Second
+	minReadahead  = 4 << 20
+	maxReadahead  = 256 << 20
+	// seekSlack: a request starting further than this from where its
+	// client last read is a seek.
+	seekSlack = 1 << 20
+	// clientIdle is how long a client with no request open is
+	// remembered, and its pieces kept in focus.
+	clientIdle = time.Minute
+	// ttfbKept is how many seeks' times to first byte /status averages.
+	ttfbKept = 20
+)
+
+// readaheadBytes is how far past a client's read position to fetch, for
+// a file playing at bitrate bytes a second over a swarm delivering
+// speed (0 if unknown).
+func readaheadBytes(bitrate, speed float64) int64 {
+	ahead := bitrate * readaheadTime.Seconds()
+	if speed > 0 && speed < bitrate {
+		ahead *= bitrate / speed
+	}
+	return min(max(int64(ahead), minReadahead), maxReadahead)
+}
+
+// readahead follows where the clients of /movie are reading and keeps
+// the swarm on it: readaheadBytes past each client's position are
+// fetched first, and the same again just behind it, which nobody is
+// about to read, is put last. A request starting somewhere new -- a
+// seek -- moves both right away, and how long it waits for its first
+// byte is kept for /status. The torrent is behind functions so this can
+// be tested without one.
+type readahead struct {
+	bitrate      float64            // bytes per second of playback
+	speed        func() float64     // download rate, bytes per second
+	focus        func(off, n int64) // fetch first
+	unfocus      func(off, n int64) // back to normal
+	deprioritize func(off, n int64)
+	now          func() time.Time
+
+	mu      sync.Mutex
+	clients map[string]*client
+	seeks   int
+	ttfb    []time.Duration // the last ttfbKept seeks', oldest first
+}
+
+// client is one player (an address and user agent) reading /movie,
+// possibly over several requests at once.
+type client struct {
+	addr, agent string
+	pos         int64    // where its latest read ended
+	read        bool     // whether it has read anything yet
+	window      [2]int64 // fetched first, as off, n
+	behind      [2]int64 // put last, as off, n
+	active      int      // requests open
+	seen        time.Time
+}
+
+// newReadahead follows reads of f, which plays for duration.
+func newReadahead(f *torrent.File, duration time.Duration) *readahead {
+	t := f.Torrent()
+	// A file's priority is a floor under its pieces'. DownloadAll has
+	// set every piece's own to normal, so the file's can go: that lets
+	// the stretch behind a client drop below the rest.
+	f.SetPriority(torrent.PiecePriorityNone)
+	rate := &rateMeter{read: func() int64 {
+		s := t.Stats()
+		return s.BytesReadUsefulData.Int64()
+	}}
+	return &readahead{
+		bitrate: float64(f.Length()) / duration.Seconds(),
+		speed:   rate.Rate,
+		focus: func(off, n int64) {
+			// As FollowPlayback does: the start is needed now, the
+			// rest soon.
+			setRangePriority(f, off, n, torrent.PiecePriorityReadahead)
+			setRangePriority(f, off, n/4, torrent.PiecePriorityNow)
+		},
+		unfocus:      func(off, n int64) { setRangePriority(f, off, n, torrent.PiecePriorityNormal) },
+		deprioritize: func(off, n int64) { setRangePriority(f, off, n, torrent.PiecePriorityNone) },
+		now:          time.Now,
+	}
+}
+
+// open starts following a request for r, returning what to serve it
+// from. Close it when the request is done.
+func (ra *readahead) open(r *http.Request, content io.ReadSeeker, setReadahead func(int64)) *trackedReader {
+	addr, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		addr = r.RemoteAddr
+	}
+	key := addr + " " + r.UserAgent()
+	ra.mu.Lock()
+	defer ra.mu.Unlock()
+	if ra.clients == nil {
+		ra.clients = map[string]*client{}
+	}
+	ra.sweep()
+	c := ra.clients[key]
+	if c == nil {
+		c = &client{addr: addr, agent: r.UserAgent()}
+		ra.clients[key] = c
+	}
+	c.active++
+	c.seen = ra.now()
+	return &trackedReader{ReadSeeker: content, ra: ra, c: c, setReadahead: setReadahead, opened: ra.now()}
+}
+
+// start is a request's first read, from pos: it reports whether that's
+// a seek and refocuses on it, returning the readahead to use.
+func (ra *readahead) start(c *client, pos int64) (seek bool, n int64) {
+	ra.mu.Lock()
+	defer ra.mu.Unlock()
+	if c.read {
+		seek = pos < c.pos-seekSlack || pos > c.pos+seekSlack
+	} else {
+		seek = pos > seekSlack
+	}
+	if seek {
+		ra.seeks++
+	}
+	c.pos, c.read, c.seen = pos, true, ra.now()
+	return seek, ra.refocus(c)
+}
+
+// advance records a read up to pos, returning the readahead to use if
+// the window moved, 0 if not.
+func (ra *readahead) advance(c *client, pos int64) int64 {
+	ra.mu.Lock()
+	defer ra.mu.Unlock()
+	c.pos, c.seen = pos, ra.now()
+	if w := c.window; pos >= w[0] && pos < w[0]+w[1]/4 {
+		return 0
+	}
+	return ra.refocus(c)
+}
+
+// refocus moves c's window and the stretch behind it to where it's
+// reading. Other clients' windows are focused again after, in case
+// they overlap what was put last. Called with mu held.
+func (ra *readahead) refocus(c *client) int64 {
+	n := readaheadBytes(ra.bitrate, ra.speed())
+	if c.window[1] > 0 {
+		ra.unfocus(c.window[0], c.window[1])
+	}
+	if c.behind[1] > 0 {
+		ra.unfocus(c.behind[0], c.behind[1])
+	}
+	back := max(c.pos-n, 0)
+	c.behind = [2]int64{back, c.pos - back}
+	if c.behind[1] > 0 {
+		ra.deprioritize(c.behind[0], c.behind[1])
+	}
+	c.window = [2]int64{c.pos, n}
+	ra.focus(c.window[0], c.window[1])
+	for _, o := range ra.clients {
+		if o != c && o.window[1] > 0 {
+			ra.focus(o.window[0], o.window[1])
+		}
+	}
+	return n
+}
+
+func (ra *readahead) firstByte(d time.Duration) {
+	ra.mu.Lock()
+	defer ra.mu.Unlock()
+	ra.ttfb = append(ra.ttfb, d)
+	if len(ra.ttfb) > ttfbKept {
+		ra.ttfb = ra.ttfb[len(ra.ttfb)-ttfbKept:]
+	}
+}
+
+func (ra *readahead) close(c *client) {
+	ra.mu.Lock()
+	defer ra.mu.Unlock()
+	c.active--
+	c.seen = ra.now()
+}
+
+// clientStatus is a client of /movie, as /status reports it.
+type clientStatus struct {
+	Addr      string `json:"addr"`
+	Agent     string `json:"agent,omitempty"`
+	Position  int64  `json:"position"`
+	Readahead int64  `json:"readahead"`
+	Requests  int    `json:"requests"`
+}
+
+// seekStats is how seeks have gone, as /status reports them. Times are
+// in milliseconds, over the last ttfbKept seeks.
+type seekStats struct {
+	Count      int   `json:"count"`
+	LastTTFBMs int64 `json:"last_ttfb_ms"`
+	AvgTTFBMs  int64 `json:"avg_ttfb_ms"`
+	MaxTTFBMs  int64 `json:"max_ttfb_ms"`
+}
+
+// sweepEvery sweeps every interval, forever.
+func (ra *readahead) sweepEvery(interval time.Duration) {
+	for range time.Tick(interval) {
+		ra.mu.Lock()
+		ra.sweep()
+		ra.mu.Unlock()
+	}
+}
+
+// sweep forgets clients idle for clientIdle, putting their pieces back
+// to normal. Called with mu held.
+func (ra *readahead) sweep() {
+	for key, c := range ra.clients {
+		if c.active > 0 || ra.now().Sub(c.seen) <= clientIdle {
+			continue
+		}
+		if c.window[1] > 0 {
+			ra.unfocus(c.window[0], c.window[1])
+		}
+		if c.behind[1] > 0 {
+			ra.unfocus(c.behind[0], c.behind[1])
+		}
+		delete(ra.clients, key)
+	}
+}
+
+// status reports the clients and seeks.
+func (ra *readahead) status() ([]clientStatus, seekStats) {
+	ra.mu.Lock()
+	defer ra.mu.Unlock()
+	clients := []clientStatus{}
+	for _, c := range ra.clients {
+		clients = append(clients, clientStatus{Addr: c.addr, Agent: c.agent, Position: c.pos, Readahead: c.window[1], Requests: c.active})
+	}
+	sort.Slice(clients, func(i, j int) bool { return clients[i].Addr+clients[i].Agent < clients[j].Addr+clients[j].Agent })
+
+	s := seekStats{Count: ra.seeks}
+	var total time.Duration
+	for _, d := range ra.ttfb {
+		total += d
+		s.MaxTTFBMs = max(s.MaxTTFBMs, d.Milliseconds())
+	}
+	if len(ra.ttfb) > 0 {
+		s.LastTTFBMs = ra.ttfb[len(ra.ttfb)-1].Milliseconds()
+		s.AvgTTFBMs = (total / time.Duration(len(ra.ttfb))).Milliseconds()
+	}
+	return clients, s
+}
+
+// trackedReader reports a request's reads to its readahead.
+type trackedReader struct {
+	io.ReadSeeker
+	ra           *readahead
+	c            *client
+	setReadahead func(int64)
+	opened       time.Time
+
+	pos             int64
+	started, timing bool
+}
+
+func (r *trackedReader) Seek(offset int64, whence int) (int64, error) {
+	pos, err := r.ReadSeeker.Seek(offset, whence)
+	if err == nil {
+		r.pos = pos
+	}
+	return pos, err
+}
+
+// begin starts following the request from where it's at, if it hasn't
+// yet. Read calls it: http.ServeContent seeks around to size the file,
+// so where the first read is from is where the request starts.
+func (r *trackedReader) begin() {
+	if r.started {
+		return
+	}
+	r.started = true
+	seek, n := r.ra.start(r.c, r.pos)
+	r.timing = seek
+	r.setReadahead(n)
+}
+
+func (r *trackedReader) Read(p []byte) (int, error) {
+	r.begin()
+	n, err := r.ReadSeeker.Read(p)
+	if n > 0 {
+		if r.timing {
+			r.timing = false
+			r.ra.firstByte(r.ra.now().Sub(r.opened))
+		}
+		r.pos += int64(n)
+		if ahead := r.ra.advance(r.c, r.pos); ahead > 0 {
+			r.setReadahead(ahead)
+		}
+	}
+	return n, err
+}
+
+// Close lets go of the client; the reader underneath is the caller's.
+func (r *trackedReader) Close() error {
+	r.ra.close(r.c)
+	return nil
+}
+
+// rateMeter turns a running byte count into a rate, smoothed over the
+// calls at least a second apart.
+type rateMeter struct {
+	read func() int64
+
+	mu   sync.Mutex
+	last int64
+	at   time.Time
+	rate float64
+}
+
+// Rate is the latest rate in bytes per second, 0 on the first call.
+func (m *rateMeter) Rate() float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now, n := time.Now(), m.read()
+	switch dt := now.Sub(m.at); {
+	case m.at.IsZero():
+		m.last, m.at = n, now
+	case dt >= time.Second:
+		r := float64(n-m.last) / dt.Seconds()
+		if m.rate == 0 {
+			m.rate = r
+		} else {
+			m.rate = (m.rate + r) / 2
+		}
+		m.last, m.at = n, now
+	}
+	return m.rate
+}
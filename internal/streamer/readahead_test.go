@@ -0,0 +1,97 @@
+package streamer
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestReadaheadBytes(t *testing.T) {
+	const mb = 1 << 20
+	cases := []struct {
+		bitrate, speed float64
+		want           int64
+	}{
+		{mb, 0, 30 * mb},       // speed unknown
+		{mb, 10 * mb, 30 * mb}, // the swarm keeps up
+		{mb, mb / 2, 60 * mb},  // half as fast as playback: twice as far
+		{1000, 0, minReadahead},
+		{100 * mb, 0, maxReadahead},
+	}
+	for _, c := range cases {
+		if got := readaheadBytes(c.bitrate, c.speed); got != c.want {
+			t.Errorf("readaheadBytes(%v, %v) = %d, want %d", c.bitrate, c.speed, got, c.want)
+		}
+	}
+}
+
+func TestReadahead_FollowsReadsAndTimesSeeks(t *testing.T) {
+	var focused, deprioritized [][2]int64
+	ra := &readahead{
+		bitrate:      1000, // a readahead of minReadahead
+		speed:        func() float64 { return 0 },
+		focus:        func(off, n int64) { focused = append(focused, [2]int64{off, n}) },
+		unfocus:      func(off, n int64) {},
+		deprioritize: func(off, n int64) { deprioritized = append(deprioritized, [2]int64{off, n}) },
+		now:          time.Now,
+	}
+	content := bytes.NewReader(make([]byte, 4*minReadahead))
+	var readaheads []int64
+	serve := func(rangeHeader string) {
+		req := httptest.NewRequest(http.MethodGet, "/movie", nil)
+		req.Header.Set("Range", rangeHeader)
+		req.Header.Set("User-Agent", "mpv")
+		tr := ra.open(req, content, func(n int64) { readaheads = append(readaheads, n) })
+		defer tr.Close()
+		rec := httptest.NewRecorder()
+		http.ServeContent(rec, req, "movie.mkv", time.Time{}, tr)
+		io.Copy(io.Discard, rec.Body)
+	}
+
+	serve("bytes=0-999")
+	if want := [][2]int64{{0, minReadahead}}; !slices.Equal(focused, want) || len(deprioritized) != 0 {
+		t.Fatalf("from the start: focused %v, deprioritized %v; want %v and nothing", focused, deprioritized, want)
+	}
+	if _, s := ra.status(); s.Count != 0 {
+		t.Errorf("reading from the start counted as %d seeks", s.Count)
+	}
+
+	focused = nil
+	serve(fmt.Sprintf("bytes=%d-%d", 2*minReadahead, 2*minReadahead+999))
+	if want := [2]int64{2 * minReadahead, minReadahead}; len(focused) == 0 || focused[0] != want {
+		t.Errorf("after seeking: focused %v, want %v first", focused, want)
+	}
+	if want := [2]int64{minReadahead, minReadahead}; len(deprioritized) == 0 || deprioritized[0] != want {
+		t.Errorf("after seeking: deprioritized %v, want %v first", deprioritized, want)
+	}
+	if len(readaheads) == 0 || readaheads[len(readaheads)-1] != minReadahead {
+		t.Errorf("readaheads set: %v, want %d", readaheads, minReadahead)
+	}
+
+	clients, s := ra.status()
+	if s.Count != 1 || len(ra.ttfb) != 1 {
+		t.Errorf("seeks = %+v (%d timed), want 1, timed", s, len(ra.ttfb))
+	}
+	want := []clientStatus{{Addr: "192.0.2.1", Agent: "mpv", Position: 2*minReadahead + 1000, Readahead: minReadahead}}
+	if !slices.Equal(clients, want) {
+		t.Errorf("clients = %+v, want %+v", clients, want)
+	}
+
+	// Idle long enough, the client is forgotten, and its pieces put
+	// back.
+	var unfocused [][2]int64
+	ra.unfocus = func(off, n int64) { unfocused = append(unfocused, [2]int64{off, n}) }
+	ra.now = func() time.Time { return time.Now().Add(2 * clientIdle) }
+	ra.sweep()
+	if clients, _ := ra.status(); len(clients) != 0 {
+		t.Errorf("idle clients still listed: %+v", clients)
+	}
+	if len(unfocused) != 2 {
+		t.Errorf("unfocused %v on forgetting the client, want its window and the stretch behind", unfocused)
+	}
+}
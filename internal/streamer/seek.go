@@ -0,0 +1,129 @@
+package streamer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/anacrolix/torrent"
+
+	"go-watch-something/internal/mkv"
+	"go-watch-something/internal/mp4"
+	"go-watch-something/internal/release"
+)
+
+// indexTimeout bounds how long StartOffset waits for the pieces holding
+// a container's seek index -- for MP4s without faststart and MKV cues,
+// the end of the file.
+const indexTimeout = 30 * time.Second
+
+// Runtimes assumed for the bitrate estimate when the container doesn't
+// say how long it is.
+const (
+	assumedFilmRuntime    = 2 * time.Hour
+	assumedEpisodeRuntime = 45 * time.Minute
+)
+
+// StartOffset maps a playback time in f to the byte offset playing it
+// starts from, saying how it got there: the keyframe at or before at
+// from the container's index if it has one, otherwise the spot at ≈
+// at's share of the file, assuming a constant bitrate.
+func StartOffset(f *torrent.File, at time.Duration) (off int64, via string) {
+	ctx, cancel := context.WithTimeout(context.Background(), indexTimeout)
+	defer cancel()
+	r := f.NewReader()
+	defer r.Close()
+	return startOffset(contextReader{ctx: ctx, ReadSeeker: r}, f.Path(), f.Length(), at)
+}
+
+// startOffset is StartOffset on any ReadSeeker over a file at path,
+// length bytes long.
+func startOffset(rs io.ReadSeeker, path string, length int64, at time.Duration) (off int64, via string) {
+	var duration time.Duration
+	var err error
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".mkv", ".webm", ".mka":
+		var ix *mkv.Index
+		if ix, err = mkv.ReadIndex(rs); err == nil {
+			return ix.Offset(at), "MKV cues"
+		}
+		if ix != nil {
+			duration = ix.Duration
+		}
+	case ".mp4", ".m4v", ".mov":
+		var ix *mp4.Index
+		if ix, err = mp4.ReadIndex(rs); err == nil {
+			return ix.Offset(at), "MP4 sample index"
+		}
+		if ix != nil {
+			duration = ix.Duration
+		}
+	default:
+		err = errors.ErrUnsupported
+	}
+
+	via = "bitrate estimate"
+	if err != nil && !errors.Is(err, errors.ErrUnsupported) {
+		via = fmt.Sprintf("bitrate estimate; no index: %v", err)
+	}
+	if duration <= 0 {
+		duration = assumedRuntime(path)
+		via += fmt.Sprintf(", assuming a %s runtime", duration)
+	}
+	return estimateOffset(length, duration, at), via
+}
+
+// estimateOffset is where at falls in a file of length bytes playing
+// for duration at a constant bitrate.
+func estimateOffset(length int64, duration, at time.Duration) int64 {
+	if duration <= 0 || at <= 0 {
+		return 0
+	}
+	if at >= duration {
+		return length
+	}
+	return int64(float64(length) * (float64(at) / float64(duration)))
+}
+
+// assumedRuntime guesses how long the video at path runs, from whether
+// its name looks like an episode's.
+func assumedRuntime(path string) time.Duration {
+	if release.Parse(filepath.Base(path)).IsEpisode() {
+		return assumedEpisodeRuntime
+	}
+	return assumedFilmRuntime
+}
+
+// MediaDuration is how long f plays, from its container if it says,
+// otherwise assumedRuntime. Reading the index waits at most
+// indexTimeout, but after StartDownload it's already here.
+func MediaDuration(f *torrent.File) time.Duration {
+	ctx, cancel := context.WithTimeout(context.Background(), indexTimeout)
+	defer cancel()
+	r := f.NewReader()
+	defer r.Close()
+	if d := containerDuration(contextReader{ctx: ctx, ReadSeeker: r}, f.Path()); d > 0 {
+		return d
+	}
+	return assumedRuntime(f.Path())
+}
+
+// containerDuration is the duration an MKV or MP4 file gives, 0 for
+// other files or if it doesn't say.
+func containerDuration(rs io.ReadSeeker, path string) time.Duration {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".mkv", ".webm", ".mka":
+		if ix, _ := mkv.ReadIndex(rs); ix != nil {
+			return ix.Duration
+		}
+	case ".mp4", ".m4v", ".mov":
+		if ix, _ := mp4.ReadIndex(rs); ix != nil {
+			return ix.Duration
+		}
+	}
+	return 0
+}
@@ -0,0 +1,47 @@
+package streamer
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEstimateOffset(t *testing.T) {
+	cases := []struct {
+		length       int64
+		duration, at time.Duration
+		want         int64
+	}{
+		{1000, time.Hour, 0, 0},
+		{1000, time.Hour, 30 * time.Minute, 500},
+		{1000, 2 * time.Hour, 72 * time.Minute, 600},
+		{1000, time.Hour, 2 * time.Hour, 1000},
+		{1000, 0, time.Minute, 0},
+	}
+	for _, c := range cases {
+		if got := estimateOffset(c.length, c.duration, c.at); got != c.want {
+			t.Errorf("estimateOffset(%d, %v, %v) = %d, want %d", c.length, c.duration, c.at, got, c.want)
+		}
+	}
+}
+
+func TestStartOffset_FallsBackToBitrate(t *testing.T) {
+	cases := []struct {
+		path   string
+		want   int64
+		viaHas string
+	}{
+		// No index for AVI: a film is assumed to run 2h.
+		{"Some.Film.2019.1080p.avi", 300, "assuming a 2h0m0s runtime"},
+		{"Show.S01E02.720p.avi", 800, "assuming a 45m0s runtime"},
+		// Not really an MKV: the failure is reported.
+		{"Some.Film.2019.1080p.mkv", 300, "no index"},
+	}
+	for _, c := range cases {
+		off, via := startOffset(bytes.NewReader(make([]byte, 64)), c.path, 1200, 30*time.Minute)
+		if off != c.want || !strings.Contains(via, c.viaHas) {
+			t.Errorf("startOffset(%q) = %d, %q; want %d, containing %q", c.path, off, via, c.want, c.viaHas)
+		}
+	}
+}
@@ -0,0 +1,224 @@
+package streamer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anacrolix/torrent"
+)
+
+const (
+	// stallLogInterval is how often a read waiting on pieces is logged.
+	stallLogInterval = 10 * time.Second
+	// busyProbe is how much from where a request starts has to be
+	// here for it to be answered with -busy-after.
+	busyProbe = 64 << 10
+	busyPoll  = 250 * time.Millisecond
+	// Bounds on the Retry-After sent with a 503, in seconds.
+	minRetryAfter = 1
+	maxRetryAfter = 30
+)
+
+// waits is the byte ranges clients are waiting on, for /status.
+type waits struct {
+	mu sync.Mutex
+	m  map[*wait]struct{}
+}
+
+// wait is a client waiting on bytes [From, To) since Since.
+type wait struct {
+	Client   string
+	From, To int64
+	Since    time.Time
+}
+
+func (ws *waits) add(client string, off, n int64) *wait {
+	w := &wait{Client: client, From: off, To: off + n, Since: time.Now()}
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	if ws.m == nil {
+		ws.m = map[*wait]struct{}{}
+	}
+	ws.m[w] = struct{}{}
+	return w
+}
+
+func (ws *waits) remove(w *wait) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	delete(ws.m, w)
+}
+
+// waitStatus is a wait, as /status reports it.
+type waitStatus struct {
+	Client    string `json:"client"`
+	From      int64  `json:"from"`
+	To        int64  `json:"to"`
+	WaitingMs int64  `json:"waiting_ms"`
+}
+
+// status lists the waits, longest first.
+func (ws *waits) status() []waitStatus {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	list := []waitStatus{}
+	for w := range ws.m {
+		list = append(list, waitStatus{Client: w.Client, From: w.From, To: w.To, WaitingMs: time.Since(w.Since).Milliseconds()})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].WaitingMs > list[j].WaitingMs })
+	return list
+}
+
+// stallReader is a /movie request's reads of the torrent: a read of
+// pieces that aren't here yet is listed in waits and logged every
+// stallLogInterval, and with a timeout gives up after that long rather
+// than blocking until the player does. The torrent is behind functions
+// so this can be tested without one.
+type stallReader struct {
+	io.ReadSeeker // a torrent.Reader
+	ctx           context.Context
+	client        string
+	waits         *waits
+	timeout       time.Duration // 0 waits as long as it takes
+	ready         func(off, n int64) bool
+	progress      func(off, n int64) string // what's in of the range, for the log
+
+	pos int64
+}
+
+func (s *stallReader) Seek(offset int64, whence int) (int64, error) {
+	pos, err := s.ReadSeeker.Seek(offset, whence)
+	if err == nil {
+		s.pos = pos
+	}
+	return pos, err
+}
+
+func (s *stallReader) Read(p []byte) (int, error) {
+	if s.ready(s.pos, int64(len(p))) {
+		return s.read(s.ctx, p)
+	}
+	w := s.waits.add(s.client, s.pos, int64(len(p)))
+	defer s.waits.remove(w)
+	ctx := s.ctx
+	if s.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.timeout)
+		defer cancel()
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		tick := time.NewTicker(stallLogInterval)
+		defer tick.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-tick.C:
+				log.Printf("%s has waited %s on bytes %d-%d: %s", s.client, time.Since(w.Since).Round(time.Second), w.From, w.To, s.progress(w.From, w.To-w.From))
+			}
+		}
+	}()
+
+	n, err := s.read(ctx, p)
+	if errors.Is(err, context.DeadlineExceeded) && s.ctx.Err() == nil {
+		log.Printf("Gave up on bytes %d-%d for %s after %s: %s", w.From, w.To, s.client, s.timeout, s.progress(w.From, w.To-w.From))
+		err = fmt.Errorf("streamer: bytes %d-%d not downloaded in %s: %w", w.From, w.To, s.timeout, err)
+	}
+	return n, err
+}
+
+func (s *stallReader) read(ctx context.Context, p []byte) (int, error) {
+	n, err := contextReader{ctx: ctx, ReadSeeker: s.ReadSeeker}.Read(p)
+	s.pos += int64(n)
+	return n, err
+}
+
+// piecesProgress describes how much of bytes [off, off+n) of f is in.
+func piecesProgress(f *torrent.File, off, n int64) string {
+	t := f.Torrent()
+	begin, end := filePieces(f, off, n)
+	done := 0
+	for i := begin; i < end; i++ {
+		if t.Piece(i).State().Complete {
+			done++
+		}
+	}
+	return fmt.Sprintf("%d of %d pieces in, %d peers", done, end-begin, t.Stats().ActivePeers)
+}
+
+// waitReady waits until bytes [off, off+n) are ready, for at most d.
+func waitReady(ctx context.Context, ready func(off, n int64) bool, off, n int64, d time.Duration) bool {
+	deadline := time.Now().Add(d)
+	for !ready(off, n) {
+		if time.Now().After(deadline) {
+			return false
+		}
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(busyPoll):
+		}
+	}
+	return true
+}
+
+// missingBytes is how much of bytes [off, off+n) of f is in pieces that
+// aren't here yet.
+func missingBytes(f *torrent.File, off, n int64) int64 {
+	t := f.Torrent()
+	begin, end := filePieces(f, off, n)
+	var missing int64
+	for i := begin; i < end; i++ {
+		if !t.Piece(i).State().Complete {
+			missing += t.Piece(i).Info().Length()
+		}
+	}
+	return missing
+}
+
+// rangeStart is where a Range header asks a file of length bytes to be
+// read from: the first range's start, 0 without one.
+func rangeStart(header string, length int64) int64 {
+	spec, ok := strings.CutPrefix(header, "bytes=")
+	if !ok {
+		return 0
+	}
+	first, _, _ := strings.Cut(spec, ",")
+	from, to, ok := strings.Cut(strings.TrimSpace(first), "-")
+	if !ok {
+		return 0
+	}
+	if from == "" { // the last to bytes
+		n, err := strconv.ParseInt(to, 10, 64)
+		if err != nil {
+			return 0
+		}
+		return max(length-n, 0)
+	}
+	off, err := strconv.ParseInt(from, 10, 64)
+	if err != nil || off < 0 || off >= length {
+		return 0
+	}
+	return off
+}
+
+// retryAfter is how many seconds a 503's client should wait, for
+// missing bytes to come in at speed bytes a second (0 if unknown).
+func retryAfter(missing int64, speed float64) int {
+	if speed <= 0 {
+		return 5
+	}
+	secs := int(math.Ceil(float64(missing) / speed))
+	return min(max(secs, minRetryAfter), maxRetryAfter)
+}
@@ -0,0 +1,111 @@
+package streamer
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRangeStart(t *testing.T) {
+	cases := []struct {
+		header string
+		want   int64
+	}{
+		{"", 0},
+		{"bytes=500-", 500},
+		{"bytes=500-999", 500},
+		{"bytes=100-199, 500-", 100},
+		{"bytes=-200", 800},
+		{"bytes=-5000", 0},
+		{"bytes=5000-", 0}, // past the end: ServeContent refuses it anyway
+		{"items=1-2", 0},
+		{"bytes=x-", 0},
+	}
+	for _, c := range cases {
+		if got := rangeStart(c.header, 1000); got != c.want {
+			t.Errorf("rangeStart(%q) = %d, want %d", c.header, got, c.want)
+		}
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	cases := []struct {
+		missing int64
+		speed   float64
+		want    int
+	}{
+		{1 << 20, 0, 5}, // speed unknown
+		{1 << 20, 1 << 19, 2},
+		{10, 1 << 20, minRetryAfter},
+		{1 << 30, 1 << 10, maxRetryAfter},
+	}
+	for _, c := range cases {
+		if got := retryAfter(c.missing, c.speed); got != c.want {
+			t.Errorf("retryAfter(%d, %v) = %d, want %d", c.missing, c.speed, got, c.want)
+		}
+	}
+}
+
+// blockingReader is a torrent.Reader whose pieces never arrive.
+type blockingReader struct{ io.ReadSeeker }
+
+func (blockingReader) ReadContext(ctx context.Context, b []byte) (int, error) {
+	<-ctx.Done()
+	return 0, ctx.Err()
+}
+
+func TestStallReader_GivesUpAndListsTheWait(t *testing.T) {
+	ws := &waits{}
+	s := &stallReader{
+		ReadSeeker: blockingReader{strings.NewReader(strings.Repeat("x", 100))},
+		ctx:        context.Background(),
+		client:     "192.0.2.1:1234",
+		waits:      ws,
+		timeout:    100 * time.Millisecond,
+		ready:      func(off, n int64) bool { return false },
+		progress:   func(off, n int64) string { return "0 of 1 pieces in" },
+	}
+	s.Seek(40, io.SeekStart)
+
+	seen := make(chan []waitStatus, 1)
+	go func() {
+		for {
+			if list := ws.status(); len(list) > 0 {
+				seen <- list
+				return
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}()
+	n, err := s.Read(make([]byte, 10))
+	if n != 0 || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Read = %d, %v; want 0 and a deadline error", n, err)
+	}
+	select {
+	case list := <-seen:
+		if len(list) != 1 || list[0].Client != "192.0.2.1:1234" || list[0].From != 40 || list[0].To != 50 {
+			t.Errorf("waiting = %+v, want bytes 40-50 for the client", list)
+		}
+	case <-time.After(time.Second):
+		t.Error("the wait was never listed")
+	}
+	if list := ws.status(); len(list) != 0 {
+		t.Errorf("still waiting after the read gave up: %+v", list)
+	}
+}
+
+func TestStallReader_ReadsWhatsThere(t *testing.T) {
+	s := &stallReader{
+		ReadSeeker: strings.NewReader("hello"),
+		ctx:        context.Background(),
+		waits:      &waits{},
+		ready:      func(off, n int64) bool { return true },
+	}
+	b, err := io.ReadAll(s)
+	if string(b) != "hello" || err != nil {
+		t.Errorf("ReadAll = %q, %v", b, err)
+	}
+}
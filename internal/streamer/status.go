@@ -0,0 +1,66 @@
+package streamer
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/anacrolix/torrent"
+
+	"go-watch-something/internal/subtitles"
+)
+
+// status is the body of GET /status.
+type status struct {
+	File           string `json:"file"`
+	BytesCompleted int64  `json:"bytes_completed"`
+	Length         int64  `json:"length"`
+	Peers          int    `json:"peers"`
+
+	// Subtitles is "off" without -subs, otherwise the background
+	// fetch's state: "fetching", "ready" or "failed". Files show up at
+	// /subs/ as they land, before it says "ready".
+	Subtitles     string `json:"subtitles"`
+	SubtitleError string `json:"subtitle_error,omitempty"`
+
+	// Clients are who's reading /movie, where, and how far past that
+	// is fetched first. DownloadRate is in bytes per second. Seeks
+	// says how long requests starting somewhere new waited for their
+	// first byte.
+	Clients      []clientStatus `json:"clients"`
+	DownloadRate int64          `json:"download_rate"`
+	Seeks        seekStats      `json:"seeks"`
+
+	// Waiting is the byte ranges clients are waiting on pieces for,
+	// longest waiting first.
+	Waiting []waitStatus `json:"waiting"`
+}
+
+// statusHandler serves GET /status: download progress, and whether
+// subtitles are ready yet, for scripts and players polling the session.
+type statusHandler struct {
+	file      *torrent.File
+	subs      *subtitles.Job // nil without -subs
+	readahead *readahead
+	waits     *waits
+}
+
+func (h *statusHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s := status{
+		File:           h.file.Path(),
+		BytesCompleted: h.file.BytesCompleted(),
+		Length:         h.file.Length(),
+		Peers:          h.file.Torrent().Stats().ActivePeers,
+		Subtitles:      "off",
+	}
+	if h.subs != nil {
+		s.Subtitles = h.subs.State()
+		if err := h.subs.Err(); err != nil {
+			s.SubtitleError = err.Error()
+		}
+	}
+	s.Clients, s.Seeks = h.readahead.status()
+	s.DownloadRate = int64(h.readahead.speed())
+	s.Waiting = h.waits.status()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s)
+}
@@ -0,0 +1,379 @@
+package streamer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/anacrolix/torrent"
+
+	"go-watch-something/internal/mkv"
+	"go-watch-something/internal/subfile"
+	"go-watch-something/internal/subtitles"
+)
+
+// subsHandler serves /subs/: the subtitle files providers saved into
+// dir or the user added, plus any text tracks embedded in the video
+// container itself. GET /subs/ lists them as a JSON array of subEntry;
+// POST /subs/ uploads another.
+type subsHandler struct {
+	dir      string                    // "" if there's nowhere to keep files
+	embedded map[string]*embeddedTrack // keyed by name minus extension, e.g. "track3.eng"
+	fetch    *subtitles.Job            // the fetch filling dir, for provider attribution; nil without -subs
+
+	mu   sync.Mutex
+	user map[string]subtitles.Subtitle // user-supplied files, keyed by name in dir
+}
+
+// subEntry is one subtitle in the /subs/ listing.
+type subEntry struct {
+	Name            string `json:"name"`
+	Format          string `json:"format"`             // "srt", "ass", "vtt", ...
+	Lang            string `json:"lang,omitempty"`     // from the provider, else parsed from the name
+	Provider        string `json:"provider,omitempty"` // "embedded" for tracks inside the video
+	Size            int64  `json:"size,omitempty"`     // bytes on disk; 0 for embedded tracks
+	HearingImpaired bool   `json:"hearing_impaired"`
+}
+
+func (h *subsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/subs/")
+	if (name == "" || name == "/") && r.Method == http.MethodPost {
+		h.upload(w, r)
+		return
+	}
+	if name == "" || name == "/" {
+		h.list(w)
+		return
+	}
+	if name == "merged" {
+		h.serveMerged(w, r)
+		return
+	}
+
+	ext := path.Ext(name)
+	if e, ok := h.embedded[strings.TrimSuffix(name, ext)]; ok && (ext == ".srt" || ext == ".vtt") {
+		h.serveEmbedded(w, r, e, ext)
+		return
+	}
+
+	if h.dir == "" {
+		http.NotFound(w, r)
+		return
+	}
+	// "ep01.ass.vtt": ep01.ass, converted.
+	if src := strings.TrimSuffix(name, ext); (ext == ".srt" || ext == ".vtt") && subfile.Format(src) != "" {
+		h.serveConverted(w, r, src, ext)
+		return
+	}
+	// Serve a specific subtitle file. ServeFile would guess the type
+	// from the extension, which Go's mime table doesn't know for any
+	// subtitle format but .vtt.
+	if format := subfile.Format(name); format != "" {
+		w.Header().Set("Content-Type", subfile.ContentType(format))
+	}
+	http.ServeFile(w, r, filepath.Join(h.dir, name))
+}
+
+func (h *subsHandler) list(w http.ResponseWriter) {
+	subs, err := h.entries()
+	if err != nil {
+		http.Error(w, "Failed to list subtitles", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(subs)
+}
+
+// entries describes every subtitle on offer, sorted by name.
+func (h *subsHandler) entries() ([]subEntry, error) {
+	subs := []subEntry{}
+	if h.dir != "" {
+		files, err := os.ReadDir(h.dir)
+		if err != nil {
+			return nil, err
+		}
+		fetched := make(map[string]subtitles.Subtitle)
+		if h.fetch != nil {
+			for _, s := range h.fetch.Subtitles() {
+				fetched[filepath.Base(s.Path)] = s
+			}
+		}
+		h.mu.Lock()
+		for name, s := range h.user {
+			fetched[name] = s
+		}
+		h.mu.Unlock()
+		for _, f := range files {
+			format := subfile.Format(f.Name())
+			if f.IsDir() || format == "" {
+				continue
+			}
+			e := subEntry{Name: f.Name(), Format: format}
+			e.Lang, e.HearingImpaired = subfile.ParseName(f.Name())
+			if info, err := f.Info(); err == nil {
+				e.Size = info.Size()
+			}
+			if s, ok := fetched[f.Name()]; ok {
+				e.Provider = s.Provider
+				e.Lang = s.Lang
+				e.HearingImpaired = e.HearingImpaired || s.HearingImpaired
+			}
+			subs = append(subs, e)
+		}
+	}
+	for name, e := range h.embedded {
+		subs = append(subs, subEntry{
+			Name:            name + ".srt",
+			Format:          "srt",
+			Lang:            e.track.Language,
+			Provider:        "embedded",
+			HearingImpaired: isSDHTrackName(e.track.Name),
+		})
+	}
+	sort.Slice(subs, func(i, j int) bool { return subs[i].Name < subs[j].Name })
+	return subs, nil
+}
+
+// maxUpload caps POST /subs/ bodies. Subtitle files are rarely over a
+// few hundred KB, even zipped with a VobSub.
+const maxUpload = 10 << 20
+
+// upload handles POST /subs/, adding a subtitle file to dir (see
+// subtitles.Add). It takes either a multipart form with the file in
+// "file" -- curl -F file=@movie.en.srt -- or the raw file as the body,
+// named by the "name" query parameter. An optional "lang" (form field
+// or query parameter) overrides the language in the file name. The new
+// file's listing entry is returned.
+func (h *subsHandler) upload(w http.ResponseWriter, r *http.Request) {
+	if h.dir == "" {
+		http.Error(w, "Uploads need a subtitle directory", http.StatusNotFound)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxUpload)
+
+	var name, lang string
+	var data []byte
+	var err error
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		var f multipart.File
+		var hdr *multipart.FileHeader
+		if f, hdr, err = r.FormFile("file"); err == nil {
+			defer f.Close()
+			name, lang = hdr.Filename, r.FormValue("lang")
+			data, err = io.ReadAll(f)
+		}
+	} else {
+		name, lang = r.URL.Query().Get("name"), r.URL.Query().Get("lang")
+		data, err = io.ReadAll(r.Body)
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Reading upload: %v", err), http.StatusBadRequest)
+		return
+	}
+	if name == "" {
+		http.Error(w, "Upload needs a file name: send a multipart \"file\" field, or ?name=", http.StatusBadRequest)
+		return
+	}
+
+	sub, err := subtitles.Add(h.dir, name, data, lang)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	stored := filepath.Base(sub.Path)
+	h.addUser(sub)
+	log.Printf("Subtitle uploaded: %s", stored)
+
+	entries, err := h.entries()
+	if err != nil {
+		http.Error(w, "Failed to list subtitles", http.StatusInternalServerError)
+		return
+	}
+	// The listing should have it, but if it doesn't (the file went
+	// between the two), the name it was stored under is still worth
+	// answering with.
+	created := subEntry{Name: stored}
+	for _, e := range entries {
+		if e.Name == stored {
+			created = e
+			break
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(created)
+}
+
+// addUser records s as user-supplied, for the listing.
+func (h *subsHandler) addUser(s subtitles.Subtitle) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.user == nil {
+		h.user = make(map[string]subtitles.Subtitle)
+	}
+	h.user[filepath.Base(s.Path)] = s
+}
+
+// isSDHTrackName guesses from an embedded track's name ("English SDH",
+// "English (Hearing Impaired)") whether it's a hearing-impaired track.
+func isSDHTrackName(name string) bool {
+	for _, w := range strings.FieldsFunc(strings.ToLower(name), func(r rune) bool {
+		return !unicode.IsLetter(r)
+	}) {
+		if w == "sdh" || w == "cc" || w == "hearing" {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *subsHandler) serveEmbedded(w http.ResponseWriter, r *http.Request, e *embeddedTrack, ext string) {
+	cues, err := e.load(r.Context())
+	if err != nil {
+		if r.Context().Err() == nil {
+			log.Printf("Extracting embedded subtitle track %d: %v", e.track.Number, err)
+			http.Error(w, "Failed to extract subtitle track", http.StatusInternalServerError)
+		}
+		return
+	}
+	writeCues(w, cues, ext)
+}
+
+// serveConverted serves the file src from dir converted to ext (".srt"
+// or ".vtt"), so a player that only takes one format can still use
+// whatever the provider delivered.
+func (h *subsHandler) serveConverted(w http.ResponseWriter, r *http.Request, src, ext string) {
+	cues, err := h.readCues(src)
+	if errors.Is(err, fs.ErrNotExist) {
+		http.NotFound(w, r)
+		return
+	}
+	if errors.Is(err, subfile.ErrUnsupported) {
+		http.Error(w, fmt.Sprintf("Can't convert %s subtitles", subfile.Format(src)), http.StatusUnsupportedMediaType)
+		return
+	}
+	if err != nil {
+		log.Printf("Converting subtitle %s: %v", src, err)
+		http.Error(w, "Failed to parse subtitle", http.StatusUnprocessableEntity)
+		return
+	}
+	writeCues(w, cues, ext)
+}
+
+// readCues parses the subtitle file name in dir.
+func (h *subsHandler) readCues(name string) ([]subfile.Cue, error) {
+	// Cleaned as an absolute path first so ".." can't climb out of dir.
+	data, err := os.ReadFile(filepath.Join(h.dir, filepath.FromSlash(path.Clean("/"+name))))
+	if err != nil {
+		return nil, err
+	}
+	return subfile.Parse(subfile.Clean(data), subfile.Format(name))
+}
+
+// writeCues encodes cues as SRT, or WebVTT if ext is ".vtt".
+func writeCues(w http.ResponseWriter, cues []subfile.Cue, ext string) {
+	var err error
+	var buf bytes.Buffer
+	if ext == ".vtt" {
+		w.Header().Set("Content-Type", subfile.ContentType("vtt"))
+		err = subfile.WriteVTT(&buf, cues)
+	} else {
+		w.Header().Set("Content-Type", subfile.ContentType("srt"))
+		err = subfile.WriteSRT(&buf, cues)
+	}
+	if err != nil {
+		http.Error(w, "Failed to encode subtitles", http.StatusInternalServerError)
+		return
+	}
+	w.Write(buf.Bytes())
+}
+
+// embeddedTrack is a text subtitle track inside the video container.
+// Extraction reads a block in every stretch of the file at best, and
+// the whole file at worst, so the result is kept once it has succeeded
+// -- the first request pays for it, later ones don't.
+type embeddedTrack struct {
+	track mkv.Track
+	open  func() io.ReadSeekCloser
+
+	mu     sync.Mutex
+	loaded bool
+	cues   []subfile.Cue
+}
+
+func (e *embeddedTrack) load(ctx context.Context) ([]subfile.Cue, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.loaded {
+		return e.cues, nil
+	}
+	r := e.open()
+	defer r.Close()
+	cues, err := mkv.ExtractSubtitles(contextReader{ctx: ctx, ReadSeeker: r}, e.track)
+	if err != nil {
+		return nil, err
+	}
+	e.cues, e.loaded = cues, true
+	return cues, nil
+}
+
+// contextReader makes reads on a torrent.Reader give up when ctx does
+// (the client hanging up), rather than blocking on pieces nobody is
+// waiting for anymore.
+type contextReader struct {
+	ctx context.Context
+	io.ReadSeeker
+}
+
+func (c contextReader) Read(b []byte) (int, error) {
+	if rc, ok := c.ReadSeeker.(interface {
+		ReadContext(context.Context, []byte) (int, error)
+	}); ok {
+		return rc.ReadContext(c.ctx, b)
+	}
+	return c.ReadSeeker.Read(b)
+}
+
+// embeddedSubtitles lists the text subtitle tracks inside f, if it's an
+// MKV. Only the container header is read, which the initial buffering
+// usually has fetched by the time the server starts -- but not always
+// (no index found, or -start buffering mid-file), so it waits at most
+// indexTimeout rather than holding the server up indefinitely.
+func embeddedSubtitles(f *torrent.File) map[string]*embeddedTrack {
+	if !strings.EqualFold(filepath.Ext(f.Path()), ".mkv") {
+		return nil
+	}
+	open := func() io.ReadSeekCloser { return f.NewReader() }
+
+	ctx, cancel := context.WithTimeout(context.Background(), indexTimeout)
+	defer cancel()
+	r := open()
+	defer r.Close()
+	tracks, err := mkv.ReadTracks(contextReader{ctx: ctx, ReadSeeker: r})
+	if err != nil {
+		log.Printf("Reading MKV track list: %v", err)
+		return nil
+	}
+
+	embedded := make(map[string]*embeddedTrack)
+	for _, t := range tracks {
+		if t.IsText() {
+			embedded[fmt.Sprintf("track%d.%s", t.Number, t.Language)] = &embeddedTrack{track: t, open: open}
+		}
+	}
+	return embedded
+}
@@ -0,0 +1,222 @@
+package streamer
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"go-watch-something/internal/mkv"
+	"go-watch-something/internal/subfile"
+	"go-watch-something/internal/subtitles"
+)
+
+// loadedTrack is an embeddedTrack whose extraction has already run, so
+// handler tests don't need a real MKV behind it.
+func loadedTrack(number uint64, cues ...subfile.Cue) *embeddedTrack {
+	return &embeddedTrack{track: mkv.Track{Number: number}, loaded: true, cues: cues}
+}
+
+func TestSubsHandler_ListsFilesAndEmbeddedTracks(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "movie.en.srt"), []byte("x"), 0o644)
+	os.WriteFile(filepath.Join(dir, "movie.pt_br.hi.ass"), []byte("xyz"), 0o644)
+	os.WriteFile(filepath.Join(dir, "subtitle.fr.srt"), []byte("x"), 0o644)
+	os.WriteFile(filepath.Join(dir, "movie.mkv"), []byte("x"), 0o644)
+
+	sdh := loadedTrack(4)
+	sdh.track.Language, sdh.track.Name = "eng", "English (SDH)"
+	fetch := subtitles.Start(func() ([]subtitles.Subtitle, error) {
+		return []subtitles.Subtitle{{Path: filepath.Join(dir, "subtitle.fr.srt"), Lang: "fr", Provider: "opensubtitles"}}, nil
+	})
+	<-fetch.Done()
+
+	h := &subsHandler{
+		dir:      dir,
+		fetch:    fetch,
+		embedded: map[string]*embeddedTrack{"track3.eng": loadedTrack(3), "track4.eng": sdh},
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/subs/", nil))
+
+	var got []subEntry
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatalf("decoding listing: %v", err)
+	}
+	want := []subEntry{
+		{Name: "movie.en.srt", Format: "srt", Lang: "en", Size: 1},
+		{Name: "movie.pt_br.hi.ass", Format: "ass", Lang: "pt-BR", Size: 3, HearingImpaired: true},
+		{Name: "subtitle.fr.srt", Format: "srt", Lang: "fr", Provider: "opensubtitles", Size: 1},
+		{Name: "track3.eng.srt", Format: "srt", Provider: "embedded"},
+		{Name: "track4.eng.srt", Format: "srt", Lang: "eng", Provider: "embedded", HearingImpaired: true},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("listing =\n%+v\nwant\n%+v", got, want)
+	}
+}
+
+func TestSubsHandler_ServesFilesWithSubtitleContentType(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"movie.en.srt", "movie.en.ass", "movie.en.vtt"} {
+		os.WriteFile(filepath.Join(dir, name), []byte("x"), 0o644)
+	}
+	h := &subsHandler{dir: dir}
+
+	cases := []struct{ name, want string }{
+		{"movie.en.srt", "application/x-subrip; charset=utf-8"},
+		{"movie.en.ass", "text/x-ssa; charset=utf-8"},
+		{"movie.en.vtt", "text/vtt; charset=utf-8"},
+	}
+	for _, c := range cases {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/subs/"+c.name, nil))
+		if rec.Code != http.StatusOK {
+			t.Errorf("GET %s status = %d, want 200", c.name, rec.Code)
+		}
+		if got := rec.Header().Get("Content-Type"); got != c.want {
+			t.Errorf("GET %s Content-Type = %q, want %q", c.name, got, c.want)
+		}
+	}
+}
+
+func TestSubsHandler_ServesEmbeddedTrackAsSRTAndVTT(t *testing.T) {
+	h := &subsHandler{embedded: map[string]*embeddedTrack{
+		"track3.eng": loadedTrack(3, subfile.Cue{Start: time.Second, End: 2 * time.Second, Text: "Hi"}),
+	}}
+
+	cases := map[string]string{
+		"/subs/track3.eng.srt": "1\n00:00:01,000 --> 00:00:02,000\nHi\n\n",
+		"/subs/track3.eng.vtt": "WEBVTT\n\n00:00:01.000 --> 00:00:02.000\nHi\n\n",
+	}
+	for url, want := range cases {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, url, nil))
+		if rec.Code != http.StatusOK {
+			t.Errorf("GET %s status = %d, want 200", url, rec.Code)
+		}
+		if rec.Body.String() != want {
+			t.Errorf("GET %s body = %q, want %q", url, rec.Body.String(), want)
+		}
+	}
+}
+
+func TestSubsHandler_ConvertsByExtension(t *testing.T) {
+	dir := t.TempDir()
+	ass := "[Script Info]\n\n[Events]\nFormat: Layer, Start, End, Style, Text\n" +
+		"Dialogue: 0,0:00:01.00,0:00:02.00,Default,{\\an8\\i1}Hi{\\i0}\n"
+	os.WriteFile(filepath.Join(dir, "ep01.ass"), []byte(ass), 0o644)
+	os.WriteFile(filepath.Join(dir, "ep01.sub"), []byte("x"), 0o644)
+	h := &subsHandler{dir: dir}
+
+	cases := []struct {
+		url    string
+		status int
+		body   string
+	}{
+		{"/subs/ep01.ass.vtt", http.StatusOK, "WEBVTT\n\n00:00:01.000 --> 00:00:02.000 line:0\n<i>Hi</i>\n\n"},
+		{"/subs/ep01.ass.srt", http.StatusOK, "1\n00:00:01,000 --> 00:00:02,000\n{\\an8}<i>Hi</i>\n\n"},
+		{"/subs/ep01.sub.srt", http.StatusUnsupportedMediaType, ""},
+		{"/subs/missing.ass.vtt", http.StatusNotFound, ""},
+		{"/subs/..%2f..%2fetc%2fpasswd.srt.vtt", http.StatusNotFound, ""},
+	}
+	for _, c := range cases {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, c.url, nil))
+		if rec.Code != c.status {
+			t.Errorf("GET %s status = %d, want %d", c.url, rec.Code, c.status)
+		}
+		if c.body != "" && rec.Body.String() != c.body {
+			t.Errorf("GET %s body = %q, want %q", c.url, rec.Body.String(), c.body)
+		}
+	}
+}
+
+func TestSubsHandler_NoDirMeansNotFound(t *testing.T) {
+	h := &subsHandler{embedded: map[string]*embeddedTrack{"track3.eng": loadedTrack(3)}}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/subs/other.srt", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("GET /subs/other.srt status = %d, want 404", rec.Code)
+	}
+	if strings.Contains(rec.Body.String(), "WEBVTT") {
+		t.Errorf("unknown name was served as the embedded track")
+	}
+}
+
+func TestSubsHandler_Upload(t *testing.T) {
+	dir := t.TempDir()
+	h := &subsHandler{dir: dir}
+	srt := "1\r\n00:00:01,000 --> 00:00:02,000\r\nOl\xe1\r\n"
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, _ := mw.CreateFormFile("file", "Movie.pt-BR.srt")
+	fw.Write([]byte(srt))
+	mw.WriteField("lang", "pt-BR")
+	mw.Close()
+	req := httptest.NewRequest(http.MethodPost, "/subs/", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("multipart upload status = %d (%s), want 201", rec.Code, rec.Body.String())
+	}
+	var got subEntry
+	json.NewDecoder(rec.Body).Decode(&got)
+	want := subEntry{Name: "Movie.pt-BR.srt", Format: "srt", Lang: "pt-BR", Provider: "user", Size: int64(len("1\n00:00:01,000 --> 00:00:02,000\nOlá\n"))}
+	if got != want {
+		t.Errorf("upload response = %+v, want %+v", got, want)
+	}
+
+	// Raw body, named by query parameter -- twice, so the second is
+	// renamed but keeps its language.
+	for _, want := range []string{"other.en.srt", "other-2.en.srt"} {
+		rec = httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/subs/?name=other.en.srt", strings.NewReader(srt)))
+		if rec.Code != http.StatusCreated {
+			t.Fatalf("raw upload status = %d (%s), want 201", rec.Code, rec.Body.String())
+		}
+		var got subEntry
+		json.NewDecoder(rec.Body).Decode(&got)
+		if got.Name != want || got.Lang != "en" {
+			t.Errorf("raw upload response = %+v, want %s in en", got, want)
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/subs/", nil))
+	var listing []subEntry
+	json.NewDecoder(rec.Body).Decode(&listing)
+	if len(listing) != 3 {
+		t.Fatalf("listing = %+v, want the 3 uploads", listing)
+	}
+	for _, e := range listing {
+		if e.Provider != "user" {
+			t.Errorf("listing entry %+v, want it attributed to user", e)
+		}
+	}
+}
+
+func TestSubsHandler_UploadRejectsJunk(t *testing.T) {
+	h := &subsHandler{dir: t.TempDir()}
+	cases := map[string]int{
+		"/subs/?name=movie.srt": http.StatusUnprocessableEntity,
+		"/subs/?name=movie.txt": http.StatusUnprocessableEntity,
+		"/subs/":                http.StatusBadRequest,
+	}
+	for url, want := range cases {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, url, strings.NewReader("<html><body>nope</body></html>")))
+		if rec.Code != want {
+			t.Errorf("POST %s status = %d, want %d", url, rec.Code, want)
+		}
+	}
+}
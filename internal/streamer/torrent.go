@@ -1,18 +1,23 @@
 package streamer
 
 import (
-	"encoding/json"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/anacrolix/torrent"
 
 	"go-watch-something/internal/memstorage"
+	"go-watch-something/internal/release"
+	"go-watch-something/internal/subtitles"
 	"go-watch-something/internal/utils"
 )
 
@@ -55,34 +60,130 @@ func SetupTorrentClient(magnet string, inMemory bool) (tmpDir string, client *to
 	return tmpDir, client, t
 }
 
-func SelectLargestVideo(t *torrent.Torrent) *torrent.File {
-	var largestFile *torrent.File
+// SelectVideo picks the video to play in t: the largest, passing over
+// samples, and -- when the torrent is named for one episode but carries
+// more than one -- that episode's if it's there.
+func SelectVideo(t *torrent.Torrent) *torrent.File {
+	f, ok := pickVideo(t.Files(), release.Parse(t.Name()), false)
+	if !ok {
+		log.Fatal("No video file found in torrent.")
+	}
+	return f
+}
+
+// FindFile returns the file at path in t -- for picking the same file
+// again, say on resume -- or nil if there's none. A path that isn't in
+// t but names an episode ("S02E05", "Show - 05") picks that episode's
+// video out of a season pack instead.
+func FindFile(t *torrent.Torrent, path string) *torrent.File {
 	for _, f := range t.Files() {
-		if utils.IsVideoFile(f.Path()) && (largestFile == nil || f.Length() > largestFile.Length()) {
-			largestFile = f
+		if f.Path() == path {
+			return f
 		}
 	}
-	if largestFile == nil {
-		log.Fatal("No video file found in torrent.")
+	if want := release.Parse(path); want.IsEpisode() {
+		if f, ok := pickVideo(t.Files(), want, true); ok {
+			return f
+		}
 	}
-	return largestFile
+	return nil
+}
+
+// videoFile is the part of a *torrent.File pickVideo looks at.
+type videoFile interface {
+	Path() string
+	Length() int64
 }
 
-func StartDownload(t *torrent.Torrent, largestFile *torrent.File) {
+// pickVideo ranks the videos in files and returns the best: not a
+// sample, then the episode want names (if it names one), then the
+// largest. With onlyEpisode, a video that isn't that episode isn't
+// picked at all.
+func pickVideo[F videoFile](files []F, want release.Info, onlyEpisode bool) (best F, ok bool) {
+	type rank struct {
+		sample, other bool
+		length        int64
+	}
+	less := func(a, b rank) bool {
+		if a.sample != b.sample {
+			return a.sample
+		}
+		if a.other != b.other {
+			return a.other
+		}
+		return a.length < b.length
+	}
+	var bestRank rank
+	for _, f := range files {
+		if !utils.IsVideoFile(f.Path()) {
+			continue
+		}
+		info := release.Parse(f.Path())
+		r := rank{sample: isSample(f.Path(), info), other: want.IsEpisode() && !sameEpisode(info, want), length: f.Length()}
+		if r.other && onlyEpisode {
+			continue
+		}
+		if !ok || less(bestRank, r) {
+			best, bestRank, ok = f, r, true
+		}
+	}
+	return best, ok
+}
+
+// isSample reports whether the video at path, parsed as info, is a
+// release's sample clip: named as one, or in a Sample directory.
+func isSample(path string, info release.Info) bool {
+	if info.Sample {
+		return true
+	}
+	for _, dir := range strings.Split(filepath.ToSlash(filepath.Dir(path)), "/") {
+		if strings.EqualFold(dir, "sample") || strings.EqualFold(dir, "samples") {
+			return true
+		}
+	}
+	return false
+}
+
+// sameEpisode reports whether got is the episode want names -- or a
+// multi-episode file including it. A season missing from either side,
+// as with absolute numbering, isn't held against it.
+func sameEpisode(got, want release.Info) bool {
+	if !got.IsEpisode() || (got.Season != want.Season && got.Season != 0 && want.Season != 0) {
+		return false
+	}
+	return slices.Contains(got.Episodes, want.Episodes[0])
+}
+
+// StartDownload downloads f, and waits until spans of it -- where
+// playback starts, and the container's index -- are in, fetching those
+// first.
+func StartDownload(t *torrent.Torrent, f *torrent.File, spans ...Span) {
 	t.DownloadAll()
-	largestFile.Download()
+	f.Download()
+	pieces := spanPieces(f, spans)
+	for _, i := range pieces {
+		t.Piece(i).SetPriority(torrent.PiecePriorityNow)
+	}
 
-	// Example buffering logic: wait until 2% of file is downloaded
-	bufferSize := int64(float64(largestFile.Length()) * 0.02)
 	fmt.Println("Buffering...")
-
-	for largestFile.BytesCompleted() < bufferSize {
+	for {
+		done := 0
+		for _, i := range pieces {
+			if t.Piece(i).State().Complete {
+				done++
+			}
+		}
+		if done == len(pieces) {
+			break
+		}
 		time.Sleep(500 * time.Millisecond)
 		stats := t.Stats()
 		progress := float64(t.BytesCompleted()) / float64(t.Length()) * 100
-		fmt.Printf("\rPeers: %d | Seeders: %d | Progress: %.2f%% | Buffer: %d / %d",
-			stats.ActivePeers, stats.ConnectedSeeders, progress,
-			largestFile.BytesCompleted(), bufferSize)
+		fmt.Printf("\rPeers: %d | Seeders: %d | Progress: %.2f%% | Buffer: %d / %d pieces",
+			stats.ActivePeers, stats.ConnectedSeeders, progress, done, len(pieces))
+	}
+	for _, i := range pieces {
+		t.Piece(i).SetPriority(torrent.PiecePriorityNormal)
 	}
 	fmt.Println("\nBuffering complete!")
 }
@@ -95,51 +196,100 @@ func CleanUp(tmpDir string, client *torrent.Client) {
 	}
 }
 
-// StartHTTPServer serves the video and optional subtitles over HTTP,
-// bound to host (default "127.0.0.1" -- previously bound all interfaces
-// implicitly via a bare ":port" address, so anyone else on the network
-// could reach the stream while it ran).
-func StartHTTPServer(host string, port uint, largestFile *torrent.File, tmpDir string, useSubs bool) {
-	// Serve /movie
+// ServerConfig is what StartHTTPServer serves.
+type ServerConfig struct {
+	// Host defaults to "127.0.0.1" -- previously bound all interfaces
+	// implicitly via a bare ":port" address, so anyone else on the
+	// network could reach the stream while it ran.
+	Host string
+	Port uint
+	File *torrent.File
+
+	// SubsDir is where fetched and uploaded subtitles land. Subs is
+	// the background fetch filling it, reported at /status; nil
+	// without -subs. UserSubs are the files given with -sub-file,
+	// already copied into SubsDir.
+	SubsDir  string
+	Subs     *subtitles.Job
+	UserSubs []subtitles.Subtitle
+
+	// ReadOffset, if set, is kept at the offset the latest /movie read
+	// ended at -- roughly where the player is, for the watch history.
+	ReadOffset *atomic.Int64
+
+	// ReadTimeout, if set, ends a /movie response whose read has waited
+	// this long on pieces, rather than leaving the player hanging.
+	// BusyAfter, if set, answers a /movie request whose first bytes
+	// haven't arrived after this long with 503 and a Retry-After.
+	ReadTimeout time.Duration
+	BusyAfter   time.Duration
+}
+
+// StartHTTPServer serves the video, optional subtitles and /status over
+// HTTP.
+func StartHTTPServer(cfg ServerConfig) {
+	largestFile := cfg.File
+	ra := newReadahead(largestFile, MediaDuration(largestFile))
+	go ra.sweepEvery(clientIdle / 2)
+	waiting := &waits{}
+	ready := func(off, n int64) bool { return rangeReady(largestFile, off, n) }
+
+	// Serve /movie, following where each client reads
 	http.HandleFunc("/movie", func(w http.ResponseWriter, r *http.Request) {
 		modTime := time.Now()
 		reader := largestFile.NewReader()
 		defer reader.Close() // torrent.Reader holds real resources (piece priority, buffering) -- leaked on every request otherwise
-		http.ServeContent(w, r, filepath.Base(largestFile.Path()), modTime, reader)
-	})
-
-	if useSubs {
-		subsDir := filepath.Join(tmpDir, filepath.Dir(largestFile.Path()))
-		http.HandleFunc("/subs/", func(w http.ResponseWriter, r *http.Request) {
-			subPath := strings.TrimPrefix(r.URL.Path, "/subs/")
-			if subPath == "" || subPath == "/" {
-				files, err := os.ReadDir(subsDir)
-				if err != nil {
-					http.Error(w, "Failed to list subtitles", http.StatusInternalServerError)
-					return
-				}
-				var subs []string
-				for _, f := range files {
-					if !f.IsDir() && strings.HasSuffix(strings.ToLower(f.Name()), ".srt") {
-						subs = append(subs, f.Name())
-					}
-				}
-				w.Header().Set("Content-Type", "application/json")
-				json.NewEncoder(w).Encode(subs)
+		stalls := &stallReader{
+			ReadSeeker: reader,
+			ctx:        r.Context(),
+			client:     r.RemoteAddr,
+			waits:      waiting,
+			timeout:    cfg.ReadTimeout,
+			ready:      ready,
+			progress:   func(off, n int64) string { return piecesProgress(largestFile, off, n) },
+		}
+		tracked := ra.open(r, stalls, reader.SetReadahead)
+		defer tracked.Close()
+		if cfg.BusyAfter > 0 {
+			start := rangeStart(r.Header.Get("Range"), largestFile.Length())
+			n := min(busyProbe, largestFile.Length()-start)
+			tracked.Seek(start, io.SeekStart)
+			tracked.begin() // the swarm goes there while this waits
+			wt := waiting.add(r.RemoteAddr, start, n)
+			ok := waitReady(r.Context(), ready, start, n, cfg.BusyAfter)
+			waiting.remove(wt)
+			if !ok {
+				w.Header().Set("Retry-After", strconv.Itoa(retryAfter(missingBytes(largestFile, start, n), ra.speed())))
+				http.Error(w, fmt.Sprintf("Byte %d isn't downloaded yet; try again shortly.", start), http.StatusServiceUnavailable)
 				return
 			}
+		}
+		var content io.ReadSeeker = tracked
+		if cfg.ReadOffset != nil {
+			content = &offsetReader{ReadSeeker: tracked, offset: cfg.ReadOffset}
+		}
+		http.ServeContent(w, r, filepath.Base(largestFile.Path()), modTime, content)
+	})
 
-			// Serve a specific .srt file
-			fullSubPath := filepath.Join(subsDir, subPath)
-			http.ServeFile(w, r, fullSubPath)
-		})
+	// Serve /subs/ -- fetched and user-supplied subtitle files, and
+	// any text tracks embedded in the video
+	embedded := embeddedSubtitles(largestFile)
+	hasSubs := cfg.SubsDir != "" || len(embedded) > 0
+	if hasSubs {
+		subs := &subsHandler{dir: cfg.SubsDir, embedded: embedded, fetch: cfg.Subs}
+		for _, s := range cfg.UserSubs {
+			subs.addUser(s)
+		}
+		http.Handle("/subs/", subs)
 	}
 
+	http.Handle("/status", &statusHandler{file: largestFile, subs: cfg.Subs, readahead: ra, waits: waiting})
+
 	// Start server
 	go func() {
-		addr := fmt.Sprintf("%s:%d", host, port)
+		addr := fmt.Sprintf("%s:%d", cfg.Host, cfg.Port)
 		fmt.Printf("Server running at http://%s/movie\n", addr)
-		if useSubs {
+		if hasSubs {
 			fmt.Printf("Subtitles at http://%s/subs/\n", addr)
 		}
 		if err := http.ListenAndServe(addr, nil); err != nil {
@@ -147,3 +297,29 @@ func StartHTTPServer(host string, port uint, largestFile *torrent.File, tmpDir s
 		}
 	}()
 }
+
+// offsetReader publishes where reads of a ReadSeeker got to.
+// http.ServeContent seeks to the end to size the file and back; only
+// reads count.
+type offsetReader struct {
+	io.ReadSeeker
+	pos    int64
+	offset *atomic.Int64
+}
+
+func (r *offsetReader) Seek(offset int64, whence int) (int64, error) {
+	pos, err := r.ReadSeeker.Seek(offset, whence)
+	if err == nil {
+		r.pos = pos
+	}
+	return pos, err
+}
+
+func (r *offsetReader) Read(p []byte) (int, error) {
+	n, err := r.ReadSeeker.Read(p)
+	r.pos += int64(n)
+	if n > 0 {
+		r.offset.Store(r.pos)
+	}
+	return n, err
+}
@@ -0,0 +1,88 @@
+package streamer
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go-watch-something/internal/release"
+)
+
+func TestOffsetReader_TracksServedRanges(t *testing.T) {
+	var offset atomic.Int64
+	content := strings.Repeat("x", 1000)
+	serve := func(rangeHeader string) {
+		req := httptest.NewRequest(http.MethodGet, "/movie", nil)
+		if rangeHeader != "" {
+			req.Header.Set("Range", rangeHeader)
+		}
+		rec := httptest.NewRecorder()
+		r := &offsetReader{ReadSeeker: strings.NewReader(content), offset: &offset}
+		http.ServeContent(rec, req, "movie.mkv", time.Time{}, r)
+		io.Copy(io.Discard, rec.Body)
+	}
+
+	cases := []struct {
+		rangeHeader string
+		want        int64
+	}{
+		{"bytes=0-99", 100},
+		{"bytes=500-", 1000},
+		{"bytes=200-299", 300},
+		{"", 1000},
+	}
+	for _, c := range cases {
+		serve(c.rangeHeader)
+		if got := offset.Load(); got != c.want {
+			t.Errorf("after Range %q offset = %d, want %d", c.rangeHeader, got, c.want)
+		}
+	}
+}
+
+type fakeFile struct {
+	path   string
+	length int64
+}
+
+func (f fakeFile) Path() string  { return f.path }
+func (f fakeFile) Length() int64 { return f.length }
+
+func TestPickVideo(t *testing.T) {
+	pack := []fakeFile{
+		{"Show.S02/Show.S02E01.1080p.mkv", 900},
+		{"Show.S02/Show.S02E05.1080p.mkv", 800},
+		{"Show.S02/Show.S02E06.1080p.mkv", 1000},
+		{"Show.S02/Sample/Show.S02E06.sample.mkv", 10},
+		{"Show.S02/Show.S02.nfo", 1},
+	}
+	cases := []struct {
+		name        string
+		files       []fakeFile
+		want        string // "" for none
+		onlyEpisode bool
+	}{
+		{"Show.S02.1080p", pack, "Show.S02/Show.S02E06.1080p.mkv", false},
+		{"Show.S02E05.1080p", pack, "Show.S02/Show.S02E05.1080p.mkv", false},
+		{"S02E01", pack, "Show.S02/Show.S02E01.1080p.mkv", true},
+		{"S02E09", pack, "", true},
+		{"S02E09", pack, "Show.S02/Show.S02E06.1080p.mkv", false},
+		// Bigger than the film, but a sample by name.
+		{"Movie.2024", []fakeFile{{"Movie.2024.mkv", 100}, {"Movie.2024-sample.mkv", 200}, {"Movie.2024.x264-GRP-sample.mkv", 300}}, "Movie.2024.mkv", false},
+		// Only a sample: better than nothing.
+		{"Movie.2024", []fakeFile{{"Sample/movie.mkv", 10}}, "Sample/movie.mkv", false},
+		// Absolute numbering, and a multi-episode file.
+		{"[Group] Show - 05", []fakeFile{{"Show - 04.mkv", 500}, {"Show - 05.mkv", 400}}, "Show - 05.mkv", true},
+		{"Show.S01E02", []fakeFile{{"Show.S01E01E02.mkv", 500}, {"Show.S01E03.mkv", 600}}, "Show.S01E01E02.mkv", false},
+		{"anything", []fakeFile{{"readme.txt", 5}}, "", false},
+	}
+	for _, c := range cases {
+		got, ok := pickVideo(c.files, release.Parse(c.name), c.onlyEpisode)
+		if ok != (c.want != "") || got.path != c.want {
+			t.Errorf("pickVideo(%q, only %v) = %q, %v; want %q", c.name, c.onlyEpisode, got.path, ok, c.want)
+		}
+	}
+}
@@ -0,0 +1,295 @@
+package subfile
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ASSStyle is the part of an ASS/SSA style that survives conversion to
+// SRT or WebVTT. Fonts, colours, outlines and the like don't.
+type ASSStyle struct {
+	Bold, Italic, Underline bool
+	Align                   int // numpad layout, as in Cue.Align
+}
+
+// ParseASSStyles reads the [V4+ Styles] (or SSA's [V4 Styles]) section
+// of an ASS/SSA script -- a whole file, or just the header Matroska
+// keeps in CodecPrivate -- keyed by style name.
+func ParseASSStyles(script []byte) map[string]ASSStyle {
+	styles := make(map[string]ASSStyle)
+	s := newASSScanner(script)
+	for s.scan() {
+		if !strings.HasSuffix(s.section, "styles]") || s.key != "style" {
+			continue
+		}
+		fields := s.fields(s.value)
+		style := ASSStyle{
+			Bold:      assBool(fields["bold"]),
+			Italic:    assBool(fields["italic"]),
+			Underline: assBool(fields["underline"]),
+		}
+		if n, err := strconv.Atoi(fields["alignment"]); err == nil {
+			if s.ssa {
+				n = legacyAlign(n)
+			}
+			style.Align = n
+		}
+		styles[fields["name"]] = style
+	}
+	return styles
+}
+
+// ParseASS reads the dialogue of an ASS/SSA script as cues, sorted by
+// start time. Override tags are converted (see ASSCue); lines that are
+// nothing but drawings, and exact duplicates left behind by layered
+// typesetting, are dropped.
+func ParseASS(data []byte) ([]Cue, error) {
+	if !bytes.Contains(bytes.ToLower(data[:min(len(data), 4096)]), []byte("[script info]")) {
+		return nil, errors.New("subfile: ASS/SSA file has no [Script Info] section")
+	}
+	styles := ParseASSStyles(data)
+
+	type key struct {
+		start, end time.Duration
+		text       string
+	}
+	seen := make(map[key]bool)
+	var cues []Cue
+	s := newASSScanner(data)
+	for s.scan() {
+		if s.section != "[events]" || s.key != "dialogue" {
+			continue
+		}
+		fields := s.fields(s.value)
+		start, err := assTime(fields["start"])
+		if err != nil {
+			return nil, err
+		}
+		end, err := assTime(fields["end"])
+		if err != nil {
+			return nil, err
+		}
+		style := styles[strings.TrimPrefix(fields["style"], "*")]
+		text, align := ASSCue(fields["text"], style)
+		k := key{start, end, text}
+		if text == "" || seen[k] {
+			continue
+		}
+		seen[k] = true
+		cues = append(cues, Cue{Start: start, End: end, Text: text, Align: align})
+	}
+	sort.SliceStable(cues, func(i, j int) bool { return cues[i].Start < cues[j].Start })
+	return cues, nil
+}
+
+// assScanner walks the "Key: value" lines of an ASS script, tracking
+// which [Section] they're in and the column order its Format: line
+// declared.
+type assScanner struct {
+	sc      *bufio.Scanner
+	section string // lowercased, brackets included
+	key     string // lowercased
+	value   string
+	format  []string
+	ssa     bool // ScriptType v4.00, which uses the legacy alignment values
+}
+
+func newASSScanner(data []byte) *assScanner {
+	sc := bufio.NewScanner(bytes.NewReader(Clean(data)))
+	sc.Buffer(nil, 1<<20)
+	return &assScanner{sc: sc}
+}
+
+func (s *assScanner) scan() bool {
+	for s.sc.Scan() {
+		line := strings.TrimSpace(s.sc.Text())
+		if strings.HasPrefix(line, "[") {
+			s.section, s.format = strings.ToLower(line), nil
+			continue
+		}
+		k, v, ok := strings.Cut(line, ":")
+		if !ok || strings.HasPrefix(line, ";") {
+			continue
+		}
+		s.key, s.value = strings.ToLower(strings.TrimSpace(k)), strings.TrimSpace(v)
+		switch s.key {
+		case "format":
+			s.format = strings.Split(strings.ToLower(s.value), ",")
+			for i := range s.format {
+				s.format[i] = strings.TrimSpace(s.format[i])
+			}
+			continue
+		case "scripttype":
+			s.ssa = strings.EqualFold(s.value, "v4.00")
+		}
+		return true
+	}
+	return false
+}
+
+// fields splits a Style: or Dialogue: value by the section's Format:
+// columns. The last column (Text, for events) keeps any commas.
+func (s *assScanner) fields(value string) map[string]string {
+	format := s.format
+	if len(format) == 0 {
+		// Missing Format: line -- assume the standard ASS event columns.
+		format = []string{"layer", "start", "end", "style", "name", "marginl", "marginr", "marginv", "effect", "text"}
+	}
+	parts := strings.SplitN(value, ",", len(format))
+	m := make(map[string]string, len(parts))
+	for i, p := range parts {
+		if format[i] == "text" {
+			m[format[i]] = p
+		} else {
+			m[format[i]] = strings.TrimSpace(p)
+		}
+	}
+	return m
+}
+
+// assTime parses an ASS timestamp, H:MM:SS.cc.
+func assTime(s string) (time.Duration, error) {
+	d, err := parseTimestamp(s)
+	if err != nil {
+		return 0, fmt.Errorf("subfile: bad ASS timestamp %q", s)
+	}
+	return d, nil
+}
+
+// assBool reads a style flag: -1 is true in ASS, 1 in some writers.
+func assBool(s string) bool {
+	return s != "" && s != "0"
+}
+
+// legacyAlign maps SSA's alignment values (1-3 bottom, 5-7 top, 9-11
+// middle, left to right) to the numpad layout \an and ASS styles use.
+func legacyAlign(n int) int {
+	switch {
+	case n >= 9:
+		return n - 5
+	case n >= 5:
+		return n + 2
+	}
+	return n
+}
+
+var (
+	assBlock    = regexp.MustCompile(`\{[^}]*\}`)
+	assBoolTag  = regexp.MustCompile(`^([ibu])(\d*)$`)
+	assAlignTag = regexp.MustCompile(`^(an?)(\d+)$`)
+	assDrawTag  = regexp.MustCompile(`^p(\d+)$`)
+)
+
+// ASSCue converts the Text field of an ASS/SSA dialogue line into cue
+// text, starting from style. Italic, bold and underline overrides
+// become <i>, <b> and <u>; \an and \a set the returned alignment;
+// \N, \n and \h become newlines and spaces. Everything else -- karaoke
+// timing, colours, transforms -- is dropped, as is text drawn in \p
+// drawing mode, which is vector shapes rather than words.
+func ASSCue(text string, style ASSStyle) (cue string, align int) {
+	align = style.Align
+	on := map[byte]bool{'i': style.Italic, 'b': style.Bold, 'u': style.Underline}
+	var order []byte // open tags, innermost last
+	var b strings.Builder
+	setTag := func(tag byte, want bool) {
+		if want == contains(order, tag) {
+			return
+		}
+		if want {
+			b.WriteString("<" + string(tag) + ">")
+			order = append(order, tag)
+			return
+		}
+		// Close innermost first, reopening whatever was inside it.
+		var reopen []byte
+		for len(order) > 0 {
+			t := order[len(order)-1]
+			order = order[:len(order)-1]
+			b.WriteString("</" + string(t) + ">")
+			if t == tag {
+				break
+			}
+			reopen = append(reopen, t)
+		}
+		for i := len(reopen) - 1; i >= 0; i-- {
+			b.WriteString("<" + string(reopen[i]) + ">")
+			order = append(order, reopen[i])
+		}
+	}
+	for _, t := range []byte("ibu") {
+		setTag(t, on[t])
+	}
+
+	drawing := false
+	rest := text
+	for rest != "" {
+		loc := assBlock.FindStringIndex(rest)
+		plain := rest
+		if loc != nil {
+			plain = rest[:loc[0]]
+		}
+		if !drawing {
+			b.WriteString(strings.NewReplacer(`\N`, "\n", `\n`, "\n", `\h`, " ").Replace(plain))
+		}
+		if loc == nil {
+			break
+		}
+		for _, tag := range strings.Split(rest[loc[0]+1:loc[1]-1], `\`) {
+			tag = strings.TrimSpace(tag)
+			if m := assBoolTag.FindStringSubmatch(tag); m != nil {
+				// \i1, \b1 and \b700 turn the style on; \i0 off; a bare
+				// \i goes back to the line's style.
+				want := on[m[1][0]]
+				if m[2] != "" {
+					want = m[2] != "0"
+				}
+				setTag(m[1][0], want)
+			} else if m := assAlignTag.FindStringSubmatch(tag); m != nil {
+				n, _ := strconv.Atoi(m[2])
+				if m[1] == "a" {
+					n = legacyAlign(n)
+				}
+				if n >= 1 && n <= 9 {
+					align = n
+				}
+			} else if m := assDrawTag.FindStringSubmatch(tag); m != nil {
+				drawing = m[1] != "0"
+			} else if tag == "r" {
+				for _, t := range []byte("ibu") {
+					setTag(t, on[t])
+				}
+			}
+		}
+		rest = rest[loc[1]:]
+	}
+	for len(order) > 0 {
+		setTag(order[len(order)-1], false)
+	}
+	return tidy(b.String()), align
+}
+
+// tidy trims each line and drops empty ones and empty tag pairs, which
+// stripped drawings and karaoke tend to leave behind.
+func tidy(s string) string {
+	for _, t := range []string{"i", "b", "u"} {
+		s = strings.ReplaceAll(s, "<"+t+"></"+t+">", "")
+	}
+	var lines []string
+	for _, l := range strings.Split(s, "\n") {
+		if l = strings.TrimSpace(l); l != "" {
+			lines = append(lines, l)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func contains(tags []byte, t byte) bool {
+	return bytes.IndexByte(tags, t) >= 0
+}
@@ -0,0 +1,90 @@
+package subfile
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestASSCue(t *testing.T) {
+	cases := []struct {
+		text      string
+		style     ASSStyle
+		want      string
+		wantAlign int
+	}{
+		{`Plain line`, ASSStyle{}, "Plain line", 0},
+		{`{\i1}Italic{\i0} text`, ASSStyle{}, "<i>Italic</i> text", 0},
+		{`First\NSecond`, ASSStyle{}, "First\nSecond", 0},
+		{`{\pos(10,20)\an8}Top\hof\hthe`, ASSStyle{}, "Top of the", 8},
+		{`  {\b1}padded{\b0}  `, ASSStyle{}, "<b>padded</b>", 0},
+		{`{\b700\i1}both{\b0} italic`, ASSStyle{}, "<b><i>both</i></b><i> italic</i>", 0},
+		{`styled {\i0}plain{\i} styled`, ASSStyle{Italic: true}, "<i>styled </i>plain<i> styled</i>", 0},
+		{`{\k20}ka{\k15}ra{\kf30}o{\ko10}ke`, ASSStyle{}, "karaoke", 0},
+		{`{\p1}m 0 0 l 100 0 100 100{\p0}`, ASSStyle{}, "", 0},
+		{`{\a6}legacy top`, ASSStyle{}, "legacy top", 8},
+		{`{TL note: pun}Joke`, ASSStyle{Align: 7}, "Joke", 7},
+		{`{\bord2\blur1\be1}Not bold`, ASSStyle{}, "Not bold", 0},
+	}
+	for _, c := range cases {
+		got, align := ASSCue(c.text, c.style)
+		if got != c.want || align != c.wantAlign {
+			t.Errorf("ASSCue(%q, %+v) = %q, %d; want %q, %d", c.text, c.style, got, align, c.want, c.wantAlign)
+		}
+	}
+}
+
+const testASS = `[Script Info]
+Title: Episode 1
+ScriptType: v4.00+
+
+[V4+ Styles]
+Format: Name, Fontname, Fontsize, Bold, Italic, Underline, Alignment
+Style: Default,Arial,20,0,0,0,2
+Style: Thoughts,Arial,20,0,-1,0,2
+Style: Sign,Arial,20,-1,0,0,8
+
+[Events]
+Format: Layer, Start, End, Style, Name, MarginL, MarginR, MarginV, Effect, Text
+Dialogue: 0,0:00:05.00,0:00:06.50,Default,,0,0,0,,Second, with a comma
+Dialogue: 0,0:00:01.00,0:00:02.00,Thoughts,,0,0,0,,First
+Comment: 0,0:00:01.00,0:00:02.00,Default,,0,0,0,,not shown
+Dialogue: 0,0:00:03.00,0:00:04.00,Sign,,0,0,0,,{\fad(100,100)}Sign
+Dialogue: 1,0:00:03.00,0:00:04.00,Sign,,0,0,0,,{\fad(100,100)}Sign
+Dialogue: 0,0:00:03.00,0:00:04.00,Default,,0,0,0,,{\p1}m 0 0 l 10 10{\p0}
+`
+
+func TestParseASS(t *testing.T) {
+	got, err := ParseASS([]byte(testASS))
+	if err != nil {
+		t.Fatalf("ParseASS: %v", err)
+	}
+	want := []Cue{
+		{Start: time.Second, End: 2 * time.Second, Text: "<i>First</i>", Align: 2},
+		{Start: 3 * time.Second, End: 4 * time.Second, Text: "<b>Sign</b>", Align: 8},
+		{Start: 5 * time.Second, End: 6500 * time.Millisecond, Text: "Second, with a comma", Align: 2},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseASS =\n%+v\nwant\n%+v", got, want)
+	}
+}
+
+func TestParseASS_SSALegacyAlignment(t *testing.T) {
+	ssa := "[Script Info]\r\nScriptType: v4.00\r\n\r\n[V4 Styles]\r\n" +
+		"Format: Name, Fontname, Alignment\r\nStyle: Top,Arial,6\r\n\r\n[Events]\r\n" +
+		"Format: Marked, Start, End, Style, Name, MarginL, MarginR, MarginV, Effect, Text\r\n" +
+		"Dialogue: Marked=0,0:00:01.00,0:00:02.00,Top,,0,0,0,,Hi\r\n"
+	got, err := ParseASS([]byte(ssa))
+	if err != nil {
+		t.Fatalf("ParseASS: %v", err)
+	}
+	if len(got) != 1 || got[0].Align != 8 {
+		t.Errorf("ParseASS = %+v, want one cue aligned top-centre", got)
+	}
+}
+
+func TestParseASS_NotASS(t *testing.T) {
+	if _, err := ParseASS([]byte(validSRT)); err == nil {
+		t.Error("ParseASS of an SRT = nil error")
+	}
+}
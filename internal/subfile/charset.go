@@ -0,0 +1,50 @@
+package subfile
+
+import (
+	"bytes"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// ToUTF8 re-encodes subtitle text as UTF-8. UTF-16 is recognised by its
+// byte order mark. Anything else that isn't valid UTF-8 is taken to be
+// Windows-1252, which is what most hand-made western subtitles that
+// aren't UTF-8 turn out to be -- a guess, but players would show
+// mojibake for it anyway.
+func ToUTF8(data []byte) []byte {
+	switch {
+	case bytes.HasPrefix(data, []byte{0xff, 0xfe}):
+		return utf16ToUTF8(data[2:], func(b []byte) uint16 { return uint16(b[0]) | uint16(b[1])<<8 })
+	case bytes.HasPrefix(data, []byte{0xfe, 0xff}):
+		return utf16ToUTF8(data[2:], func(b []byte) uint16 { return uint16(b[0])<<8 | uint16(b[1]) })
+	case utf8.Valid(data):
+		return data
+	}
+	var b strings.Builder
+	b.Grow(len(data) + len(data)/8)
+	for _, c := range data {
+		if c >= 0x80 && c < 0xa0 {
+			b.WriteRune(cp1252[c-0x80])
+		} else {
+			// 0xa0-0xff match Latin-1, i.e. the code point itself.
+			b.WriteRune(rune(c))
+		}
+	}
+	return []byte(b.String())
+}
+
+func utf16ToUTF8(data []byte, unit func([]byte) uint16) []byte {
+	units := make([]uint16, 0, len(data)/2)
+	for i := 0; i+1 < len(data); i += 2 {
+		units = append(units, unit(data[i:]))
+	}
+	return []byte(string(utf16.Decode(units)))
+}
+
+// cp1252 maps Windows-1252's 0x80-0x9f, where it differs from Latin-1.
+// The five unassigned bytes become U+FFFD.
+var cp1252 = [32]rune{
+	'€', '�', '‚', 'ƒ', '„', '…', '†', '‡', 'ˆ', '‰', 'Š', '‹', 'Œ', '�', 'Ž', '�',
+	'�', '‘', '’', '“', '”', '•', '–', '—', '˜', '™', 'š', '›', 'œ', '�', 'ž', 'Ÿ',
+}
@@ -0,0 +1,18 @@
+package subfile
+
+import "testing"
+
+func TestToUTF8(t *testing.T) {
+	cases := map[string]struct{ in, want string }{
+		"utf-8 untouched": {"Olá “mundo”", "Olá “mundo”"},
+		"utf-16le":        {"\xff\xfeO\x00l\x00\xe1\x00", "Olá"},
+		"utf-16be":        {"\xfe\xff\x00O\x00l\x00\xe1", "Olá"},
+		"latin-1":         {"Ol\xe1 a\xe7\xe3o", "Olá ação"},
+		"windows-1252":    {"\x93quoted\x94 \x80 \x81", "“quoted” € �"},
+	}
+	for name, c := range cases {
+		if got := string(ToUTF8([]byte(c.in))); got != c.want {
+			t.Errorf("%s: ToUTF8(%q) = %q, want %q", name, c.in, got, c.want)
+		}
+	}
+}
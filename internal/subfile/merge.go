@@ -0,0 +1,50 @@
+package subfile
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+// Merge stacks two languages' cues into one track: each second cue is
+// attached under the first cue it overlaps most, and shown for that
+// cue's timing. Second cues that overlap nothing (a line one
+// translation split differently, or left out of the other) keep their
+// own timing rather than being lost. first's positioning wins.
+func Merge(first, second []Cue) []Cue {
+	under := make([][]string, len(first))
+	var merged []Cue
+	for _, s := range second {
+		best, bestOverlap := -1, time.Duration(0)
+		// first is sorted, so only cues starting before s ends can
+		// overlap it.
+		end := sort.Search(len(first), func(i int) bool { return first[i].Start >= s.End })
+		for i := 0; i < end; i++ {
+			if o := overlap(first[i], s); o > bestOverlap {
+				best, bestOverlap = i, o
+			}
+		}
+		if best < 0 {
+			merged = append(merged, s)
+			continue
+		}
+		under[best] = append(under[best], s.Text)
+	}
+	for i, c := range first {
+		if len(under[i]) > 0 {
+			c.Text = c.Text + "\n" + strings.Join(under[i], "\n")
+		}
+		merged = append(merged, c)
+	}
+	sort.SliceStable(merged, func(i, j int) bool { return merged[i].Start < merged[j].Start })
+	return merged
+}
+
+// overlap is how long a and b are on screen together.
+func overlap(a, b Cue) time.Duration {
+	start, end := max(a.Start, b.Start), min(a.End, b.End)
+	if end <= start {
+		return 0
+	}
+	return end - start
+}
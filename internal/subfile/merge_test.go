@@ -0,0 +1,32 @@
+package subfile
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMerge(t *testing.T) {
+	s := time.Second
+	en := []Cue{
+		{Start: 1 * s, End: 3 * s, Text: "Hello there."},
+		{Start: 4 * s, End: 6 * s, Text: "How are you?", Align: 8},
+		{Start: 10 * s, End: 11 * s, Text: "Bye."},
+	}
+	pt := []Cue{
+		{Start: 1200 * time.Millisecond, End: 2 * s, Text: "Olá."},
+		{Start: 2500 * time.Millisecond, End: 4800 * time.Millisecond, Text: "Tudo bem?"}, // mostly under the second cue
+		{Start: 7 * s, End: 8 * s, Text: "(risos)"},                                       // overlaps nothing
+	}
+
+	got := Merge(en, pt)
+	want := []Cue{
+		{Start: 1 * s, End: 3 * s, Text: "Hello there.\nOlá."},
+		{Start: 4 * s, End: 6 * s, Text: "How are you?\nTudo bem?", Align: 8},
+		{Start: 7 * s, End: 8 * s, Text: "(risos)"},
+		{Start: 10 * s, End: 11 * s, Text: "Bye."},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Merge =\n%+v\nwant\n%+v", got, want)
+	}
+}
@@ -0,0 +1,242 @@
+package subfile
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrHTML is what an error page served in place of a subtitle
+	// (quota exceeded, expired link, ...) fails with.
+	ErrHTML = errors.New("subfile: got an HTML page, not a subtitle")
+	// ErrNoCues means the file parsed but has nothing to show.
+	ErrNoCues = errors.New("subfile: no cues")
+	// ErrUnsupported is returned by Parse for formats it can't read
+	// (VobSub, for one). Such files can still be served as-is.
+	ErrUnsupported = errors.New("subfile: unsupported format")
+)
+
+// maxUnpacked caps what Unpack will decompress, so a hostile archive
+// can't fill memory. Real subtitle files are well under a megabyte.
+const maxUnpacked = 16 << 20
+
+// Unpack returns the subtitle inside data if it's a zip or gzip
+// archive -- some providers hand those out in place of the file
+// itself -- or data unchanged if it isn't one. name is the file name
+// recorded in the archive, if any; its extension tells the real
+// format.
+func Unpack(data []byte) (content []byte, name string, err error) {
+	switch {
+	case bytes.HasPrefix(data, []byte("PK\x03\x04")):
+		zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			return nil, "", fmt.Errorf("subfile: reading zip: %w", err)
+		}
+		for _, f := range zr.File {
+			if f.FileInfo().IsDir() || Format(f.Name) == "" {
+				continue
+			}
+			rc, err := f.Open()
+			if err != nil {
+				return nil, "", fmt.Errorf("subfile: reading %s from zip: %w", f.Name, err)
+			}
+			defer rc.Close()
+			content, err := readCapped(rc)
+			if err != nil {
+				return nil, "", fmt.Errorf("subfile: reading %s from zip: %w", f.Name, err)
+			}
+			return content, f.Name, nil
+		}
+		return nil, "", errors.New("subfile: zip holds no subtitle file")
+	case bytes.HasPrefix(data, []byte{0x1f, 0x8b}):
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, "", fmt.Errorf("subfile: reading gzip: %w", err)
+		}
+		defer zr.Close()
+		content, err := readCapped(zr)
+		if err != nil {
+			return nil, "", fmt.Errorf("subfile: reading gzip: %w", err)
+		}
+		return content, zr.Name, nil
+	}
+	return data, "", nil
+}
+
+func readCapped(r io.Reader) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, maxUnpacked+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxUnpacked {
+		return nil, fmt.Errorf("more than %d bytes unpacked", maxUnpacked)
+	}
+	return data, nil
+}
+
+// Clean strips a UTF-8 byte order mark and turns CRLF (and lone CR)
+// line endings into LF. Players cope with either, but the parsers here
+// and a few web players don't.
+func Clean(data []byte) []byte {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	data = bytes.ReplaceAll(data, []byte("\r\n"), []byte("\n"))
+	return bytes.ReplaceAll(data, []byte("\r"), []byte("\n"))
+}
+
+// IsHTML reports whether data looks like a web page rather than a
+// subtitle. SRT allows <i> and friends, so only document-level tags
+// near the start count.
+func IsHTML(data []byte) bool {
+	head := data
+	if len(head) > 512 {
+		head = head[:512]
+	}
+	lower := bytes.ToLower(bytes.TrimSpace(head))
+	return bytes.HasPrefix(lower, []byte("<!doctype html")) ||
+		bytes.HasPrefix(lower, []byte("<html")) ||
+		bytes.Contains(lower, []byte("<head>")) ||
+		bytes.Contains(lower, []byte("<body"))
+}
+
+// Parse reads cues from data in the given format ("srt", "vtt", "ass",
+// "ssa"). data should already be Clean. Formats it doesn't know yield
+// ErrUnsupported.
+func Parse(data []byte, format string) ([]Cue, error) {
+	switch format {
+	case "ass", "ssa":
+		return ParseASS(data)
+	case "srt":
+		return parseBlocks(string(data))
+	case "vtt":
+		s := string(data)
+		if !strings.HasPrefix(s, "WEBVTT") {
+			return nil, errors.New("subfile: WebVTT file doesn't start with WEBVTT")
+		}
+		return parseBlocks(s)
+	}
+	return nil, fmt.Errorf("%w: %q", ErrUnsupported, format)
+}
+
+// cueTiming matches an SRT or WebVTT timing line. Hours are optional
+// (WebVTT allows "01:02.500"), and sloppy SRTs use '.' like WebVTT
+// does, or fewer than three millisecond digits.
+var cueTiming = regexp.MustCompile(`^\s*((?:\d+:)?\d{1,2}:\d{2}[,.]\d{1,3})\s*-->\s*((?:\d+:)?\d{1,2}:\d{2}[,.]\d{1,3})`)
+
+// parseBlocks reads the blank-line separated blocks SRT and WebVTT are
+// both made of. A block is a cue if one of its first two lines is a
+// timing line (the other being the cue number or identifier);
+// anything else -- the WebVTT header, NOTE and STYLE blocks, stray
+// junk -- is skipped.
+func parseBlocks(s string) ([]Cue, error) {
+	var cues []Cue
+	for _, block := range strings.Split(s, "\n\n") {
+		lines := strings.Split(strings.Trim(block, "\n"), "\n")
+		for i := 0; i < len(lines) && i < 2; i++ {
+			m := cueTiming.FindStringSubmatch(lines[i])
+			if m == nil {
+				continue
+			}
+			start, err := parseTimestamp(m[1])
+			if err != nil {
+				return nil, err
+			}
+			end, err := parseTimestamp(m[2])
+			if err != nil {
+				return nil, err
+			}
+			cues = append(cues, Cue{Start: start, End: end, Text: strings.Join(lines[i+1:], "\n")})
+			break
+		}
+	}
+	return cues, nil
+}
+
+func parseTimestamp(s string) (time.Duration, error) {
+	s = strings.Replace(s, ",", ".", 1)
+	clock, frac, _ := strings.Cut(s, ".")
+	parts := strings.Split(clock, ":")
+	var d time.Duration
+	for _, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil {
+			return 0, fmt.Errorf("subfile: bad timestamp %q", s)
+		}
+		d = d*60 + time.Duration(n)
+	}
+	d *= time.Second
+	// "5" after the separator is 500ms, not 5ms.
+	ms, err := strconv.Atoi((frac + "00")[:3])
+	if err != nil {
+		return 0, fmt.Errorf("subfile: bad timestamp %q", s)
+	}
+	return d + time.Duration(ms)*time.Millisecond, nil
+}
+
+// Validate checks cues are something a player can use: at least one
+// cue, each ending no earlier than it starts, in start-time order.
+func Validate(cues []Cue) error {
+	if len(cues) == 0 {
+		return ErrNoCues
+	}
+	for i, c := range cues {
+		if c.End < c.Start {
+			return fmt.Errorf("subfile: cue %d ends (%s) before it starts (%s)", i+1, c.End, c.Start)
+		}
+		if i > 0 && c.Start < cues[i-1].Start {
+			return fmt.Errorf("subfile: cue %d starts (%s) before cue %d (%s)", i+1, c.Start, i, cues[i-1].Start)
+		}
+	}
+	return nil
+}
+
+// Sanitize turns whatever a provider (or the user) handed over for a
+// subtitle named name into the subtitle itself: archives are unpacked,
+// the text is converted to UTF-8 (ToUTF8) and Clean-ed, and -- for
+// formats Parse understands -- the cues are checked with Validate.
+// format is the real format, which for an archive comes from the file
+// inside it and may not match name. Anything that isn't a usable
+// subtitle is an error.
+func Sanitize(data []byte, name string) (clean []byte, format string, err error) {
+	data, inner, err := Unpack(data)
+	if err != nil {
+		return nil, "", err
+	}
+	format = Format(name)
+	if f := Format(inner); f != "" {
+		format = f
+	}
+	// VobSub is binary (or an index for it); nothing to re-encode.
+	vobsub := format == "sub" || format == "idx"
+	if !vobsub {
+		data = ToUTF8(data)
+	}
+	if len(bytes.TrimSpace(data)) == 0 {
+		return nil, "", errors.New("subfile: empty file")
+	}
+	if IsHTML(data) {
+		return nil, "", ErrHTML
+	}
+	if vobsub {
+		return data, format, nil
+	}
+	data = Clean(data)
+	cues, err := Parse(data, format)
+	if errors.Is(err, ErrUnsupported) {
+		return data, format, nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	if err := Validate(cues); err != nil {
+		return nil, "", err
+	}
+	return data, format, nil
+}
@@ -0,0 +1,134 @@
+package subfile
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+	"unicode/utf16"
+)
+
+const validASS = "[Script Info]\n\n[Events]\nFormat: Layer, Start, End, Style, Text\nDialogue: 0,0:00:01.00,0:00:02.00,Default,Hi\n"
+
+const validSRT = "1\n00:00:01,000 --> 00:00:02,500\nHello\n\n2\n00:00:03,000 --> 00:00:04,000\n<i>Two</i>\nlines\n"
+
+func TestParse(t *testing.T) {
+	cases := []struct {
+		name, format, data string
+		want               []Cue
+	}{
+		{"srt", "srt", validSRT, []Cue{
+			{Start: time.Second, End: 2500 * time.Millisecond, Text: "Hello"},
+			{Start: 3 * time.Second, End: 4 * time.Second, Text: "<i>Two</i>\nlines"},
+		}},
+		{"srt without numbers, sloppy timestamps", "srt", "00:00:01.5 --> 0:00:02,25\nHi\n", []Cue{
+			{Start: 1500 * time.Millisecond, End: 2250 * time.Millisecond, Text: "Hi"},
+		}},
+		{"vtt with header, note and settings", "vtt",
+			"WEBVTT - title\n\nNOTE made by hand\n\nintro\n01:02.000 --> 01:03.000 align:start\nHi\n", []Cue{
+				{Start: 62 * time.Second, End: 63 * time.Second, Text: "Hi"},
+			}},
+	}
+	for _, c := range cases {
+		got, err := Parse([]byte(c.data), c.format)
+		if err != nil {
+			t.Errorf("%s: Parse: %v", c.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: Parse =\n%+v\nwant\n%+v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestParse_Errors(t *testing.T) {
+	if _, err := Parse([]byte("00:00:01.000 --> 00:00:02.000\nHi\n"), "vtt"); err == nil {
+		t.Errorf("Parse of a WebVTT file with no header = nil error")
+	}
+	if _, err := Parse([]byte("x"), "sub"); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("Parse of VobSub = %v, want ErrUnsupported", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	cases := map[string]struct {
+		cues []Cue
+		ok   bool
+	}{
+		"ok":                    {[]Cue{{Start: 1, End: 2}, {Start: 1, End: 3}, {Start: 5, End: 6}}, true},
+		"empty":                 {nil, false},
+		"ends before it starts": {[]Cue{{Start: 5, End: 2}}, false},
+		"out of order":          {[]Cue{{Start: 5, End: 6}, {Start: 1, End: 2}}, false},
+	}
+	for name, c := range cases {
+		if err := Validate(c.cues); (err == nil) != c.ok {
+			t.Errorf("%s: Validate = %v, want ok=%v", name, err, c.ok)
+		}
+	}
+}
+
+func TestSanitize(t *testing.T) {
+	var zipped bytes.Buffer
+	zw := zip.NewWriter(&zipped)
+	zw.Create("readme.txt")
+	w, _ := zw.Create("Movie.2024.en.ass")
+	w.Write([]byte(validASS))
+	zw.Close()
+
+	var gzipped bytes.Buffer
+	gw := gzip.NewWriter(&gzipped)
+	gw.Write([]byte(validSRT))
+	gw.Close()
+
+	cases := []struct {
+		name       string
+		data       []byte
+		wantData   string
+		wantFormat string
+	}{
+		{"plain", []byte(validSRT), validSRT, "srt"},
+		{"bom and crlf", []byte("\xef\xbb\xbf1\r\n00:00:01,000 --> 00:00:02,000\r\nHi\r\n"), "1\n00:00:01,000 --> 00:00:02,000\nHi\n", "srt"},
+		{"gzip", gzipped.Bytes(), validSRT, "srt"},
+		{"utf-16le", utf16le("1\n00:00:01,000 --> 00:00:02,000\né\n"), "1\n00:00:01,000 --> 00:00:02,000\né\n", "srt"},
+		{"windows-1252", []byte("1\n00:00:01,000 --> 00:00:02,000\n\x93Ol\xe1\x94\n"), "1\n00:00:01,000 --> 00:00:02,000\n“Olá”\n", "srt"},
+		{"zip holding an ass", zipped.Bytes(), validASS, "ass"},
+	}
+	for _, c := range cases {
+		data, format, err := Sanitize(c.data, "movie.en.srt")
+		if err != nil {
+			t.Errorf("%s: Sanitize: %v", c.name, err)
+			continue
+		}
+		if string(data) != c.wantData || format != c.wantFormat {
+			t.Errorf("%s: Sanitize = %q, %q; want %q, %q", c.name, data, format, c.wantData, c.wantFormat)
+		}
+	}
+}
+
+// utf16le encodes s as UTF-16LE with a byte order mark, as Windows
+// Notepad saves "Unicode" text.
+func utf16le(s string) []byte {
+	b := []byte{0xff, 0xfe}
+	for _, u := range utf16.Encode([]rune(s)) {
+		b = append(b, byte(u), byte(u>>8))
+	}
+	return b
+}
+
+func TestSanitize_RejectsJunk(t *testing.T) {
+	cases := map[string]string{
+		"empty":         "  \n",
+		"html":          "<!DOCTYPE html>\n<html><body>Download limit reached</body></html>",
+		"no cues":       "this is not a subtitle",
+		"not monotonic": "1\n00:00:05,000 --> 00:00:06,000\nB\n\n2\n00:00:01,000 --> 00:00:02,000\nA\n",
+		"broken zip":    "PK\x03\x04garbage",
+	}
+	for name, data := range cases {
+		if _, _, err := Sanitize([]byte(data), "movie.en.srt"); err == nil {
+			t.Errorf("%s: Sanitize = nil error, want rejection", name)
+		}
+	}
+}
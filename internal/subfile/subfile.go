@@ -0,0 +1,167 @@
+// Package subfile is the in-memory model for subtitle cues, plus the
+// writers that turn them back into files players understand, and the
+// file-name conventions subtitle files follow. It exists so subtitles
+// that don't start life as an .srt on disk (tracks embedded in an MKV,
+// for one) can still be served from /subs/.
+package subfile
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Cue is a single timed subtitle. Text may span multiple lines and may
+// carry the basic <i>/<b>/<u> tags both SRT and WebVTT understand.
+type Cue struct {
+	Start time.Duration
+	End   time.Duration
+	Text  string
+
+	// Align is where the cue sits on screen, in the numpad layout ASS
+	// uses: 1-3 along the bottom, 4-6 in the middle, 7-9 at the top,
+	// left to right. 0 means the default, bottom centre.
+	Align int
+}
+
+// WriteSRT writes cues in SubRip format, numbering them from 1. SRT has
+// no positioning of its own; a cue that isn't bottom-centre gets an
+// {\anN} prefix, which mpv, VLC and most TV players honour.
+func WriteSRT(w io.Writer, cues []Cue) error {
+	bw := bufio.NewWriter(w)
+	for i, c := range cues {
+		text := c.Text
+		if c.Align != 0 && c.Align != 2 {
+			text = fmt.Sprintf("{\\an%d}%s", c.Align, text)
+		}
+		fmt.Fprintf(bw, "%d\n%s --> %s\n%s\n\n", i+1, timestamp(c.Start, ','), timestamp(c.End, ','), text)
+	}
+	return bw.Flush()
+}
+
+// WriteVTT writes cues in WebVTT format -- the only text format browsers
+// render natively in a <track> element.
+func WriteVTT(w io.Writer, cues []Cue) error {
+	bw := bufio.NewWriter(w)
+	bw.WriteString("WEBVTT\n\n")
+	for _, c := range cues {
+		fmt.Fprintf(bw, "%s --> %s%s\n%s\n\n", timestamp(c.Start, '.'), timestamp(c.End, '.'), vttSettings(c.Align), c.Text)
+	}
+	return bw.Flush()
+}
+
+// vttSettings turns a numpad alignment into WebVTT cue settings.
+func vttSettings(align int) string {
+	if align < 1 || align > 9 {
+		return ""
+	}
+	var s string
+	switch (align - 1) / 3 {
+	case 1:
+		s += " line:50%"
+	case 2:
+		s += " line:0"
+	}
+	switch (align - 1) % 3 {
+	case 0:
+		s += " align:start"
+	case 2:
+		s += " align:end"
+	}
+	return s
+}
+
+// timestamp formats d as HH:MM:SS<sep>mmm. SRT wants ',' before the
+// milliseconds, WebVTT wants '.'.
+func timestamp(d time.Duration, sep byte) string {
+	if d < 0 {
+		d = 0
+	}
+	ms := d.Milliseconds()
+	return fmt.Sprintf("%02d:%02d:%02d%c%03d", ms/3600000, ms/60000%60, ms/1000%60, sep, ms%1000)
+}
+
+// formats maps the subtitle file extensions /subs/ serves to their
+// Content-Type. .sub is usually VobSub (binary, paired with an .idx),
+// occasionally MicroDVD text -- either way it's passed through as-is.
+var formats = map[string]string{
+	"srt": "application/x-subrip; charset=utf-8",
+	"vtt": "text/vtt; charset=utf-8",
+	"ass": "text/x-ssa; charset=utf-8",
+	"ssa": "text/x-ssa; charset=utf-8",
+	"idx": "text/plain; charset=utf-8",
+	"sub": "application/octet-stream",
+}
+
+// Format returns name's subtitle format ("srt", "ass", ...), or "" if
+// it isn't a subtitle file.
+func Format(name string) string {
+	ext := strings.ToLower(strings.TrimPrefix(path.Ext(name), "."))
+	if _, ok := formats[ext]; !ok {
+		return ""
+	}
+	return ext
+}
+
+// ContentType returns the Content-Type to serve format with.
+func ContentType(format string) string {
+	if ct, ok := formats[format]; ok {
+		return ct
+	}
+	return "application/octet-stream"
+}
+
+// langToken matches the language part of names like "movie.pt-BR.srt",
+// "movie.pt_BR.srt" or "movie.eng.srt". Only lowercase language codes
+// count, so release tags like ".WEB." don't.
+var langToken = regexp.MustCompile(`^([a-z]{2,3})(?:[-_]([A-Za-z]{2}|[0-9]{3}))?$`)
+
+// hiTokens mark a hearing-impaired subtitle (sound effects, speaker
+// names) in the file name.
+var hiTokens = map[string]bool{"hi": true, "sdh": true, "cc": true}
+
+// ParseName pulls the language and hearing-impaired marker out of a
+// subtitle file name, following the "<video>.<lang>[.hi].<ext>"
+// convention subliminal and most players use. lang is "" if there
+// isn't one.
+func ParseName(name string) (lang string, hearingImpaired bool) {
+	parts := strings.Split(strings.TrimSuffix(name, path.Ext(name)), ".")
+	for i := len(parts) - 1; i > 0; i-- {
+		p := parts[i]
+		switch {
+		case hiTokens[strings.ToLower(p)]:
+			hearingImpaired = true
+		case strings.EqualFold(p, "forced") || strings.EqualFold(p, "default"):
+		default:
+			if m := langToken.FindStringSubmatch(p); m != nil {
+				lang = m[1]
+				if m[2] != "" {
+					lang += "-" + strings.ToUpper(m[2])
+				}
+			}
+			return lang, hearingImpaired
+		}
+	}
+	return "", hearingImpaired
+}
+
+// BaseName is name minus its extension and the language, hearing
+// impaired and forced markers ParseName looks at -- the name of the
+// video the subtitle goes with: "Movie.2024.1080p.en.hi.srt" gives
+// "Movie.2024.1080p".
+func BaseName(name string) string {
+	parts := strings.Split(strings.TrimSuffix(name, path.Ext(name)), ".")
+	for len(parts) > 1 {
+		p := parts[len(parts)-1]
+		lower := strings.ToLower(p)
+		if !hiTokens[lower] && lower != "forced" && lower != "default" && !langToken.MatchString(p) {
+			break
+		}
+		parts = parts[:len(parts)-1]
+	}
+	return strings.Join(parts, ".")
+}
@@ -0,0 +1,120 @@
+package subfile
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+var testCues = []Cue{
+	{Start: 1 * time.Second, End: 2500 * time.Millisecond, Text: "Hello"},
+	{Start: time.Hour + 2*time.Minute + 3*time.Second + 4*time.Millisecond, End: time.Hour + 2*time.Minute + 5*time.Second, Text: "<i>Two</i>\nlines"},
+}
+
+func TestWriteSRT(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteSRT(&buf, testCues); err != nil {
+		t.Fatalf("WriteSRT: %v", err)
+	}
+	want := "1\n00:00:01,000 --> 00:00:02,500\nHello\n\n" +
+		"2\n01:02:03,004 --> 01:02:05,000\n<i>Two</i>\nlines\n\n"
+	if buf.String() != want {
+		t.Errorf("WriteSRT =\n%q\nwant\n%q", buf.String(), want)
+	}
+}
+
+func TestWriteVTT(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteVTT(&buf, testCues); err != nil {
+		t.Fatalf("WriteVTT: %v", err)
+	}
+	want := "WEBVTT\n\n" +
+		"00:00:01.000 --> 00:00:02.500\nHello\n\n" +
+		"01:02:03.004 --> 01:02:05.000\n<i>Two</i>\nlines\n\n"
+	if buf.String() != want {
+		t.Errorf("WriteVTT =\n%q\nwant\n%q", buf.String(), want)
+	}
+}
+
+func TestWriteAligned(t *testing.T) {
+	cues := []Cue{
+		{Start: time.Second, End: 2 * time.Second, Text: "Top", Align: 8},
+		{Start: 3 * time.Second, End: 4 * time.Second, Text: "Bottom", Align: 2},
+		{Start: 5 * time.Second, End: 6 * time.Second, Text: "Middle left", Align: 4},
+	}
+
+	var srt bytes.Buffer
+	WriteSRT(&srt, cues)
+	wantSRT := "1\n00:00:01,000 --> 00:00:02,000\n{\\an8}Top\n\n" +
+		"2\n00:00:03,000 --> 00:00:04,000\nBottom\n\n" +
+		"3\n00:00:05,000 --> 00:00:06,000\n{\\an4}Middle left\n\n"
+	if srt.String() != wantSRT {
+		t.Errorf("WriteSRT =\n%q\nwant\n%q", srt.String(), wantSRT)
+	}
+
+	var vtt bytes.Buffer
+	WriteVTT(&vtt, cues)
+	wantVTT := "WEBVTT\n\n" +
+		"00:00:01.000 --> 00:00:02.000 line:0\nTop\n\n" +
+		"00:00:03.000 --> 00:00:04.000\nBottom\n\n" +
+		"00:00:05.000 --> 00:00:06.000 line:50% align:start\nMiddle left\n\n"
+	if vtt.String() != wantVTT {
+		t.Errorf("WriteVTT =\n%q\nwant\n%q", vtt.String(), wantVTT)
+	}
+}
+
+func TestFormat(t *testing.T) {
+	cases := map[string]string{
+		"movie.en.srt": "srt",
+		"movie.ASS":    "ass",
+		"movie.vtt":    "vtt",
+		"movie.idx":    "idx",
+		"movie.sub":    "sub",
+		"movie.mkv":    "",
+		"readme.txt":   "",
+	}
+	for name, want := range cases {
+		if got := Format(name); got != want {
+			t.Errorf("Format(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestParseName(t *testing.T) {
+	cases := []struct {
+		name string
+		lang string
+		hi   bool
+	}{
+		{"movie.en.srt", "en", false},
+		{"Some.Movie.2024.pt-BR.srt", "pt-BR", false},
+		{"Some.Movie.2024.pt_br.srt", "pt-BR", false},
+		{"movie.eng.hi.srt", "eng", true},
+		{"movie.en.sdh.forced.srt", "en", true},
+		{"movie.es-419.srt", "es-419", false},
+		{"Show.S01E01.WEB.srt", "", false},
+		{"movie.srt", "", false},
+		{"track3.eng.srt", "eng", false},
+	}
+	for _, c := range cases {
+		lang, hi := ParseName(c.name)
+		if lang != c.lang || hi != c.hi {
+			t.Errorf("ParseName(%q) = %q, %v; want %q, %v", c.name, lang, hi, c.lang, c.hi)
+		}
+	}
+}
+
+func TestBaseName(t *testing.T) {
+	cases := map[string]string{
+		"Movie.2024.1080p.en.hi.srt":  "Movie.2024.1080p",
+		"Movie.2024.pt-BR.forced.ass": "Movie.2024",
+		"Movie.2024.WEB.srt":          "Movie.2024.WEB",
+		"movie.srt":                   "movie",
+		"en.srt":                      "en",
+	}
+	for name, want := range cases {
+		if got := BaseName(name); got != want {
+			t.Errorf("BaseName(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package subtitles
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"go-watch-something/internal/subfile"
+)
+
+// Result is what FetchAll gathered.
+type Result struct {
+	Subtitles []Subtitle // every distinct file, in provider order
+	Missing   []string   // requested languages no provider delivered
+}
+
+// FetchAll queries every provider concurrently, each under its own
+// timeout, and keeps everything they deliver -- unlike
+// FetchWithFallback, one provider finding a mediocre English subtitle
+// doesn't stop the others looking for the rest. Files with identical
+// content are de-duplicated (the copy from the earlier provider in the
+// list is kept, the other deleted), and files that fail checkFile are
+// dropped. A language counts as covered only once some provider has
+// actually delivered a usable file in it.
+//
+// Each provider writes into a directory of its own under v's, and what
+// is kept is moved up from there after -- two providers saving the
+// same file name would overwrite each other otherwise. When the name
+// is taken, the provider's goes in before the language (see claim).
+//
+// It returns an error only when no provider delivered anything.
+func FetchAll(ctx context.Context, providers []Provider, v Video, langs []string, timeout time.Duration) (Result, error) {
+	if len(providers) == 0 {
+		return Result{}, fmt.Errorf("subtitles: no providers configured")
+	}
+
+	type outcome struct {
+		subs []Subtitle
+		err  error
+	}
+	if v.Name == "" {
+		// Providers look for the video next to where they write.
+		name, err := findVideoName(v.Dir)
+		if err == nil {
+			v.Name = name
+		}
+	}
+	outcomes := make([]outcome, len(providers))
+	var wg sync.WaitGroup
+	for i, p := range providers {
+		dir, err := os.MkdirTemp(v.outDir(), "."+safeName(p.Name())+"-")
+		if err != nil {
+			return Result{}, fmt.Errorf("subtitles: %w", err)
+		}
+		defer os.RemoveAll(dir)
+		pv := v
+		pv.OutDir = dir
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			pctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+			subs, err := p.Fetch(pctx, pv, langs)
+			outcomes[i] = outcome{subs, err}
+		}()
+	}
+	wg.Wait()
+
+	var res Result
+	var failures []string
+	seen := make(map[[sha256.Size]byte]string) // content hash -> path kept
+	covered := make(map[string]bool)
+	for i, o := range outcomes {
+		if o.err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", providers[i].Name(), o.err))
+			continue
+		}
+		valid, invalid := keepValid(o.subs)
+		for _, reason := range invalid {
+			failures = append(failures, fmt.Sprintf("%s: %s", providers[i].Name(), reason))
+		}
+		for _, s := range valid {
+			sum, err := hashFile(s.Path)
+			if err != nil {
+				failures = append(failures, fmt.Sprintf("%s: %v", providers[i].Name(), err))
+				continue
+			}
+			if _, dup := seen[sum]; dup {
+				continue
+			}
+			if s.Path, err = claim(s.Path, v.outDir(), providers[i].Name()); err != nil {
+				failures = append(failures, fmt.Sprintf("%s: %v", providers[i].Name(), err))
+				continue
+			}
+			seen[sum] = s.Path
+			res.Subtitles = append(res.Subtitles, s)
+			covered[strings.ToLower(s.Lang)] = true
+		}
+	}
+	for _, l := range langs {
+		if !covered[strings.ToLower(l)] {
+			res.Missing = append(res.Missing, l)
+		}
+	}
+
+	if len(res.Subtitles) == 0 {
+		return res, fmt.Errorf("subtitles: no provider delivered anything:\n%s", strings.Join(failures, "\n"))
+	}
+	return res, nil
+}
+
+// claim moves a file a provider wrote into dir, under its own name if
+// that's free, otherwise with the provider's name before the language
+// and format: "Movie.en.srt" from subliminal becomes
+// "Movie.subliminal.en.srt" (then "Movie.subliminal-2.en.srt", ...).
+// Files already in dir are left alone.
+func claim(src, dir, provider string) (string, error) {
+	name := filepath.Base(src)
+	if filepath.Dir(src) == filepath.Clean(dir) {
+		return src, nil
+	}
+	base := subfile.BaseName(name)
+	suffix := strings.TrimPrefix(name, base) // ".en.hi.srt"
+	for i := 1; i < 100; i++ {
+		dest := filepath.Join(dir, name)
+		switch {
+		case i == 2:
+			dest = filepath.Join(dir, base+"."+safeName(provider)+suffix)
+		case i > 2:
+			dest = filepath.Join(dir, fmt.Sprintf("%s.%s-%d%s", base, safeName(provider), i-1, suffix))
+		}
+		if _, err := os.Lstat(dest); err == nil {
+			continue
+		}
+		if err := os.Rename(src, dest); err != nil {
+			return "", fmt.Errorf("subtitles: %w", err)
+		}
+		return dest, nil
+	}
+	return "", fmt.Errorf("subtitles: too many files named like %s", name)
+}
+
+// safeName is a provider name fit for a file name ("plugin/x" has a
+// slash).
+func safeName(provider string) string {
+	return strings.NewReplacer("/", "_", string(filepath.Separator), "_").Replace(provider)
+}
+
+func hashFile(path string) ([sha256.Size]byte, error) {
+	var sum [sha256.Size]byte
+	f, err := os.Open(path)
+	if err != nil {
+		return sum, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return sum, err
+	}
+	copy(sum[:], h.Sum(nil))
+	return sum, nil
+}
@@ -0,0 +1,193 @@
+package subtitles
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"go-watch-something/internal/subfile"
+)
+
+// writingProvider writes one file per entry in files (lang -> cue
+// text) into the output dir, named "<base>.<lang>.srt" (base defaults
+// to the name). Text starting with "raw:" is written as-is instead of
+// as a one-cue SRT.
+type writingProvider struct {
+	name  string
+	base  string
+	files map[string]string
+}
+
+func (p writingProvider) Name() string { return p.name }
+
+func (p writingProvider) Fetch(_ context.Context, v Video, langs []string) ([]Subtitle, error) {
+	var subs []Subtitle
+	for _, lang := range langs {
+		content, ok := p.files[lang]
+		if !ok {
+			continue
+		}
+		base := p.base
+		if base == "" {
+			base = p.name
+		}
+		path := filepath.Join(v.outDir(), base+"."+lang+".srt")
+		if raw, ok := strings.CutPrefix(content, "raw:"); ok {
+			content = raw
+		} else {
+			content = "1\n00:00:01,000 --> 00:00:02,000\n" + content + "\n"
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			return nil, err
+		}
+		subs = append(subs, Subtitle{Path: path, Lang: lang, Provider: p.name})
+	}
+	if len(subs) == 0 {
+		return nil, errors.New("nothing found")
+	}
+	return subs, nil
+}
+
+// blockingProvider never returns until its context is done.
+type blockingProvider struct{}
+
+func (blockingProvider) Name() string { return "slow" }
+
+func (blockingProvider) Fetch(ctx context.Context, _ Video, _ []string) ([]Subtitle, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestFetchAll_KeepsEveryProvidersResults(t *testing.T) {
+	dir := t.TempDir()
+	providers := []Provider{
+		writingProvider{name: "a", files: map[string]string{"en": "english from a"}},
+		writingProvider{name: "b", files: map[string]string{"en": "english from b", "pt-BR": "portugues"}},
+	}
+
+	res, err := FetchAll(context.Background(), providers, Video{Dir: dir}, []string{"en", "pt-BR", "fr"}, time.Second)
+	if err != nil {
+		t.Fatalf("FetchAll: %v", err)
+	}
+	if len(res.Subtitles) != 3 {
+		t.Errorf("FetchAll kept %d subtitles, want 3: %+v", len(res.Subtitles), res.Subtitles)
+	}
+	if len(res.Missing) != 1 || res.Missing[0] != "fr" {
+		t.Errorf("Missing = %v, want [fr]", res.Missing)
+	}
+}
+
+func TestFetchAll_DeduplicatesByContent(t *testing.T) {
+	dir := t.TempDir()
+	providers := []Provider{
+		writingProvider{name: "a", files: map[string]string{"en": "same bytes"}},
+		writingProvider{name: "b", files: map[string]string{"en": "same bytes"}},
+	}
+
+	res, err := FetchAll(context.Background(), providers, Video{Dir: dir}, []string{"en"}, time.Second)
+	if err != nil {
+		t.Fatalf("FetchAll: %v", err)
+	}
+	if len(res.Subtitles) != 1 || res.Subtitles[0].Provider != "a" {
+		t.Fatalf("FetchAll = %+v, want only provider a's copy", res.Subtitles)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "b.en.srt")); !os.IsNotExist(err) {
+		t.Errorf("duplicate b.en.srt still on disk (stat err = %v)", err)
+	}
+}
+
+func TestFetchAll_SlowProviderTimesOutWithoutLosingOthers(t *testing.T) {
+	providers := []Provider{
+		blockingProvider{},
+		writingProvider{name: "fast", files: map[string]string{"en": "hi"}},
+	}
+
+	start := time.Now()
+	res, err := FetchAll(context.Background(), providers, Video{Dir: t.TempDir()}, []string{"en"}, 100*time.Millisecond)
+	if err != nil {
+		t.Fatalf("FetchAll: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("FetchAll took %v, want it bounded by the 100ms per-provider timeout", elapsed)
+	}
+	if len(res.Subtitles) != 1 || res.Subtitles[0].Provider != "fast" {
+		t.Errorf("FetchAll = %+v, want the fast provider's subtitle", res.Subtitles)
+	}
+}
+
+func TestFetchAll_NothingDeliveredIsAnError(t *testing.T) {
+	providers := []Provider{
+		fakeProvider{name: "unconfigured", err: ErrNotConfigured},
+		fakeProvider{name: "broken", err: errors.New("boom")},
+	}
+	res, err := FetchAll(context.Background(), providers, Video{Dir: t.TempDir()}, []string{"en"}, time.Second)
+	if err == nil {
+		t.Fatal("FetchAll with no deliveries = nil error, want error")
+	}
+	if len(res.Missing) != 1 {
+		t.Errorf("Missing = %v, want [en]", res.Missing)
+	}
+}
+
+func TestFetchAll_DropsInvalidFiles(t *testing.T) {
+	dir := t.TempDir()
+	providers := []Provider{
+		writingProvider{name: "junk", files: map[string]string{"en": "raw:<html><body>Quota exceeded</body></html>"}},
+		writingProvider{name: "good", files: map[string]string{"en": "hello", "fr": "raw:"}},
+	}
+
+	res, err := FetchAll(context.Background(), providers, Video{Dir: dir}, []string{"en", "fr"}, time.Second)
+	if err != nil {
+		t.Fatalf("FetchAll: %v", err)
+	}
+	if len(res.Subtitles) != 1 || res.Subtitles[0].Provider != "good" {
+		t.Errorf("FetchAll = %+v, want only good's en subtitle", res.Subtitles)
+	}
+	if len(res.Missing) != 1 || res.Missing[0] != "fr" {
+		t.Errorf("Missing = %v, want [fr] -- an empty file doesn't cover it", res.Missing)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "junk.en.srt")); !os.IsNotExist(err) {
+		t.Errorf("invalid junk.en.srt still on disk (stat err = %v)", err)
+	}
+}
+
+func TestFetchAll_SameFileNameFromTwoProviders(t *testing.T) {
+	dir := t.TempDir()
+	providers := []Provider{
+		writingProvider{name: "a", base: "Movie", files: map[string]string{"en": "english from a"}},
+		writingProvider{name: "b", base: "Movie", files: map[string]string{"en": "english from b"}},
+	}
+
+	res, err := FetchAll(context.Background(), providers, Video{Dir: dir}, []string{"en"}, time.Second)
+	if err != nil {
+		t.Fatalf("FetchAll: %v", err)
+	}
+	want := map[string]string{
+		filepath.Join(dir, "Movie.en.srt"):   "english from a",
+		filepath.Join(dir, "Movie.b.en.srt"): "english from b",
+	}
+	if len(res.Subtitles) != 2 {
+		t.Fatalf("FetchAll kept %+v, want both", res.Subtitles)
+	}
+	for _, s := range res.Subtitles {
+		data, err := os.ReadFile(s.Path)
+		if err != nil || !strings.Contains(string(data), want[s.Path]) || want[s.Path] == "" {
+			t.Errorf("%s from %s: %q, %v; want one of %v", s.Path, s.Provider, data, err, want)
+		}
+		if lang, _ := subfile.ParseName(filepath.Base(s.Path)); lang != "en" {
+			t.Errorf("%s reads as language %q, want en", s.Path, lang)
+		}
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 2 {
+		var names []string
+		for _, e := range entries {
+			names = append(names, e.Name())
+		}
+		t.Errorf("left in the dir: %v, want just the two subtitles", names)
+	}
+}
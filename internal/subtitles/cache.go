@@ -0,0 +1,226 @@
+package subtitles
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"go-watch-something/internal/lang"
+	"go-watch-something/internal/subfile"
+)
+
+// CacheProvider is the Provider name of subtitles served from a Cache.
+const CacheProvider = "cache"
+
+// Cache keeps every subtitle fetched for a torrent's file, keyed by
+// info-hash, the file's path in the torrent and language, so watching
+// the same torrent again doesn't spend provider quota. It's a Provider
+// (run it first -- see FetchLocalFirst) and a Storer. Entries older
+// than TTL are ignored and removed; 0 means they never expire.
+type Cache struct {
+	Dir string
+	TTL time.Duration
+}
+
+// DefaultCacheDir is where the cache lives unless told otherwise:
+// go-watch-something/subtitles under the user's cache dir
+// (~/.cache on Linux).
+func DefaultCacheDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "go-watch-something", "subtitles"), nil
+}
+
+// CacheEntry is one cached subtitle.
+type CacheEntry struct {
+	InfoHash        string    `json:"info_hash"`
+	File            string    `json:"file"` // path within the torrent
+	Lang            string    `json:"lang"`
+	HearingImpaired bool      `json:"hearing_impaired,omitempty"`
+	Provider        string    `json:"provider"` // who originally fetched it
+	Path            string    `json:"path"`     // relative to the cache dir
+	StoredAt        time.Time `json:"stored_at"`
+}
+
+const cacheIndex = "index.json"
+
+func (Cache) Name() string { return CacheProvider }
+
+func (c Cache) Fetch(_ context.Context, v Video, langs []string) ([]Subtitle, error) {
+	if c.Dir == "" || v.InfoHash == "" {
+		return nil, fmt.Errorf("%w: no cache dir or info-hash", ErrNotConfigured)
+	}
+	entries, err := c.load()
+	if err != nil {
+		return nil, err
+	}
+	var subs []Subtitle
+	for _, lang := range langs {
+		e, ok := findEntry(entries, v, lang)
+		if !ok || c.expired(e) {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(c.Dir, filepath.FromSlash(e.Path)))
+		if err != nil {
+			continue
+		}
+		base := v.Name
+		if base == "" {
+			base = filepath.Base(v.TorrentFile)
+		}
+		path, err := create(filepath.Join(v.outDir(), subtitleName(base, lang, e.HearingImpaired, subfile.Format(e.Path))), data)
+		if err != nil {
+			return subs, err
+		}
+		subs = append(subs, Subtitle{Path: path, Lang: lang, Provider: CacheProvider, HearingImpaired: e.HearingImpaired})
+	}
+	if len(subs) == 0 {
+		return nil, fmt.Errorf("cache: nothing cached for this file")
+	}
+	fmt.Printf("Using %d cached subtitle(s).\n", len(subs))
+	return subs, nil
+}
+
+// Store caches subs for v, replacing whatever was cached for the same
+// file and language. Expired entries are dropped while at it.
+func (c Cache) Store(v Video, subs []Subtitle) error {
+	if c.Dir == "" || v.InfoHash == "" {
+		return nil
+	}
+	entries, err := c.load()
+	if err != nil {
+		return err
+	}
+	fileDir := filepath.Join(strings.ToLower(v.InfoHash), fileKey(v.TorrentFile))
+	if err := os.MkdirAll(filepath.Join(c.Dir, fileDir), 0o755); err != nil {
+		return err
+	}
+	for _, s := range subs {
+		if s.Provider == CacheProvider {
+			continue
+		}
+		data, err := os.ReadFile(s.Path)
+		if err != nil {
+			return err
+		}
+		rel := filepath.Join(fileDir, subtitleName("sub", s.Lang, s.HearingImpaired, subfile.Format(s.Path)))
+		e := CacheEntry{
+			InfoHash: strings.ToLower(v.InfoHash), File: v.TorrentFile, Lang: s.Lang,
+			HearingImpaired: s.HearingImpaired, Provider: s.Provider,
+			Path: filepath.ToSlash(rel), StoredAt: time.Now(),
+		}
+		// The old entry goes (with its file) before the new file lands,
+		// as they may share a name.
+		entries = removeEntries(entries, func(old CacheEntry) bool {
+			return old.Path == e.Path || (sameFile(old, v) && lang.Equal(old.Lang, s.Lang))
+		}, c.Dir)
+		if err := os.WriteFile(filepath.Join(c.Dir, rel), data, 0o644); err != nil {
+			return err
+		}
+		entries = append(entries, e)
+	}
+	entries = removeEntries(entries, c.expired, c.Dir)
+	return c.save(entries)
+}
+
+// Entries lists everything cached, oldest first, including expired
+// entries not yet cleaned up.
+func (c Cache) Entries() ([]CacheEntry, error) {
+	entries, err := c.load()
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].StoredAt.Before(entries[j].StoredAt) })
+	return entries, err
+}
+
+// Expired reports whether e is past the TTL.
+func (c Cache) Expired(e CacheEntry) bool { return c.expired(e) }
+
+// Clear removes the cached subtitles for infoHash, or everything if
+// it's "", and reports how many went.
+func (c Cache) Clear(infoHash string) (int, error) {
+	entries, err := c.load()
+	if err != nil {
+		return 0, err
+	}
+	kept := removeEntries(entries, func(e CacheEntry) bool {
+		return infoHash == "" || strings.EqualFold(e.InfoHash, infoHash)
+	}, c.Dir)
+	return len(entries) - len(kept), c.save(kept)
+}
+
+func (c Cache) expired(e CacheEntry) bool {
+	return c.TTL > 0 && time.Since(e.StoredAt) > c.TTL
+}
+
+func findEntry(entries []CacheEntry, v Video, tag string) (CacheEntry, bool) {
+	for _, e := range entries {
+		if sameFile(e, v) && lang.Match(e.Lang, tag) {
+			return e, true
+		}
+	}
+	return CacheEntry{}, false
+}
+
+func sameFile(e CacheEntry, v Video) bool {
+	return strings.EqualFold(e.InfoHash, v.InfoHash) && e.File == v.TorrentFile
+}
+
+// fileKey names a torrent file's cache dir: a hash of its path, since
+// the path itself may nest or be too long for one.
+func fileKey(path string) string {
+	sum := sha1.Sum([]byte(path))
+	return hex.EncodeToString(sum[:8])
+}
+
+// removeEntries drops the entries drop matches, deleting their files.
+func removeEntries(entries []CacheEntry, drop func(CacheEntry) bool, dir string) []CacheEntry {
+	var kept []CacheEntry
+	for _, e := range entries {
+		if drop(e) {
+			os.Remove(filepath.Join(dir, filepath.FromSlash(e.Path)))
+			continue
+		}
+		kept = append(kept, e)
+	}
+	return kept
+}
+
+func (c Cache) load() ([]CacheEntry, error) {
+	data, err := os.ReadFile(filepath.Join(c.Dir, cacheIndex))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var entries []CacheEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("cache: reading %s: %w", cacheIndex, err)
+	}
+	return entries, nil
+}
+
+func (c Cache) save(entries []CacheEntry) error {
+	if err := os.MkdirAll(c.Dir, 0o755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(c.Dir, cacheIndex+".tmp")
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(c.Dir, cacheIndex))
+}
@@ -0,0 +1,145 @@
+package subtitles
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeSub(t *testing.T, dir, name string) Subtitle {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(librarySRT), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return Subtitle{Path: path}
+}
+
+func TestCache_StoreThenFetch(t *testing.T) {
+	c := Cache{Dir: t.TempDir()}
+	v := Video{Dir: t.TempDir(), Name: "Movie.mkv", InfoHash: "ABCDEF", TorrentFile: "Movie/Movie.mkv"}
+
+	en := writeSub(t, v.Dir, "Movie.en.srt")
+	en.Lang, en.Provider = "en", "opensubtitles"
+	if err := c.Store(v, []Subtitle{en}); err != nil {
+		t.Fatalf("Store: %v", err)
+	}
+
+	// A new session: a fresh video dir, same torrent and file.
+	v.Dir = t.TempDir()
+	subs, err := c.Fetch(context.Background(), v, []string{"en", "pt"})
+	if err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+	if len(subs) != 1 || subs[0].Lang != "en" || subs[0].Provider != CacheProvider {
+		t.Fatalf("Fetch = %+v, want one en subtitle from the cache", subs)
+	}
+	if got := filepath.Base(subs[0].Path); got != "Movie.en.srt" {
+		t.Errorf("cached subtitle written as %q, want Movie.en.srt", got)
+	}
+	if data, _ := os.ReadFile(subs[0].Path); string(data) != librarySRT {
+		t.Errorf("cached content = %q", data)
+	}
+}
+
+func TestCache_KeyedByInfoHashAndFile(t *testing.T) {
+	c := Cache{Dir: t.TempDir()}
+	v := Video{Dir: t.TempDir(), Name: "E01.mkv", InfoHash: "abc", TorrentFile: "S01/E01.mkv"}
+	sub := writeSub(t, v.Dir, "E01.en.srt")
+	sub.Lang, sub.Provider = "en", "subliminal"
+	if err := c.Store(v, []Subtitle{sub}); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, other := range map[string]Video{
+		"other file":    {Dir: t.TempDir(), Name: "E01.mkv", InfoHash: "abc", TorrentFile: "S02/E01.mkv"},
+		"other torrent": {Dir: t.TempDir(), Name: "E01.mkv", InfoHash: "def", TorrentFile: "S01/E01.mkv"},
+	} {
+		if subs, err := c.Fetch(context.Background(), other, []string{"en"}); err == nil {
+			t.Errorf("%s: Fetch = %+v, want nothing", name, subs)
+		}
+	}
+}
+
+func TestCache_Expiry(t *testing.T) {
+	c := Cache{Dir: t.TempDir(), TTL: time.Hour}
+	v := Video{Dir: t.TempDir(), Name: "Movie.mkv", InfoHash: "abc", TorrentFile: "Movie.mkv"}
+	sub := writeSub(t, v.Dir, "Movie.en.srt")
+	sub.Lang = "en"
+	if err := c.Store(v, []Subtitle{sub}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Age the entry past the TTL.
+	entries, _ := c.load()
+	entries[0].StoredAt = time.Now().Add(-2 * time.Hour)
+	if err := c.save(entries); err != nil {
+		t.Fatal(err)
+	}
+	if subs, err := c.Fetch(context.Background(), v, []string{"en"}); err == nil {
+		t.Errorf("Fetch of an expired entry = %+v, want nothing", subs)
+	}
+	if !c.Expired(entries[0]) {
+		t.Error("Expired = false for an entry past the TTL")
+	}
+	if (Cache{Dir: c.Dir}).Expired(entries[0]) {
+		t.Error("Expired = true with no TTL")
+	}
+}
+
+func TestCache_Clear(t *testing.T) {
+	c := Cache{Dir: t.TempDir()}
+	for _, hash := range []string{"aaa", "bbb"} {
+		v := Video{Dir: t.TempDir(), Name: "Movie.mkv", InfoHash: hash, TorrentFile: "Movie.mkv"}
+		sub := writeSub(t, v.Dir, "Movie.en.srt")
+		sub.Lang = "en"
+		if err := c.Store(v, []Subtitle{sub}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	n, err := c.Clear("AAA")
+	if err != nil || n != 1 {
+		t.Fatalf("Clear(AAA) = %d, %v; want 1, nil", n, err)
+	}
+	entries, _ := c.Entries()
+	if len(entries) != 1 || entries[0].InfoHash != "bbb" {
+		t.Fatalf("after Clear(AAA), Entries = %+v", entries)
+	}
+	if n, _ := c.Clear(""); n != 1 {
+		t.Errorf("Clear(\"\") = %d, want 1", n)
+	}
+	if _, err := os.Stat(filepath.Join(c.Dir, filepath.FromSlash(entries[0].Path))); !os.IsNotExist(err) {
+		t.Errorf("cleared file still there: %v", err)
+	}
+}
+
+func TestCache_RunsBeforeTheNetwork(t *testing.T) {
+	c := Cache{Dir: t.TempDir()}
+	v := Video{Dir: t.TempDir(), Name: "Movie.mkv", InfoHash: "abc", TorrentFile: "Movie.mkv"}
+
+	fetches := 0
+	fetch := func(langs []string) ([]Subtitle, error) {
+		fetches++
+		var subs []Subtitle
+		for _, l := range langs {
+			s := writeSub(t, v.Dir, "Movie."+l+".srt")
+			s.Lang, s.Provider = l, "opensubtitles"
+			subs = append(subs, s)
+		}
+		return subs, nil
+	}
+	if _, err := FetchLocalFirst(context.Background(), []Provider{c}, v, []string{"en"}, fetch); err != nil {
+		t.Fatal(err)
+	}
+	v.Dir = t.TempDir()
+	subs, err := FetchLocalFirst(context.Background(), []Provider{c}, v, []string{"en"}, fetch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fetches != 1 || len(subs) != 1 || subs[0].Provider != CacheProvider {
+		t.Errorf("second session: %d fetches, subs %+v; want the cache to answer", fetches, subs)
+	}
+}
@@ -0,0 +1,43 @@
+package subtitles
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// hashChunk is how much of each end of the file MovieHash reads.
+const hashChunk = 64 << 10
+
+// MovieHash computes the OpenSubtitles movie hash of a video size bytes
+// long: the size plus every little-endian uint64 in the first and last
+// 64 KiB, wrapping on overflow. It identifies a release exactly, unlike
+// its name, and needs only 128 KiB of it -- cheap enough even for a
+// torrent that's still downloading.
+func MovieHash(r io.ReadSeeker, size int64) (string, error) {
+	if size <= 0 {
+		return "", fmt.Errorf("subtitles: can't hash an empty file")
+	}
+	hash := uint64(size)
+	sum := func(offset int64) error {
+		n := min(int64(hashChunk), size)
+		if _, err := r.Seek(offset, io.SeekStart); err != nil {
+			return err
+		}
+		buf := make([]byte, n)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return err
+		}
+		for i := 0; i+8 <= len(buf); i += 8 {
+			hash += binary.LittleEndian.Uint64(buf[i:])
+		}
+		return nil
+	}
+	if err := sum(0); err != nil {
+		return "", fmt.Errorf("subtitles: hashing video: %w", err)
+	}
+	if err := sum(max(size-hashChunk, 0)); err != nil {
+		return "", fmt.Errorf("subtitles: hashing video: %w", err)
+	}
+	return fmt.Sprintf("%016x", hash), nil
+}
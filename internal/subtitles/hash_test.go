@@ -0,0 +1,34 @@
+package subtitles
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestMovieHash(t *testing.T) {
+	// 200 KiB where every uint64 is 1: the size plus 8192 words from
+	// each end.
+	const size = 200 << 10
+	data := make([]byte, size)
+	for i := 0; i < size; i += 8 {
+		binary.LittleEndian.PutUint64(data[i:], 1)
+	}
+	got, err := MovieHash(bytes.NewReader(data), size)
+	if err != nil {
+		t.Fatalf("MovieHash: %v", err)
+	}
+	if want := "0000000000036000"; got != want { // 204800 + 2*8192
+		t.Errorf("MovieHash = %s, want %s", got, want)
+	}
+
+	// Smaller than a chunk: the whole file, twice.
+	got, _ = MovieHash(bytes.NewReader(data[:16]), 16)
+	if want := "0000000000000014"; got != want { // 16 + 2*2
+		t.Errorf("MovieHash of 16 bytes = %s, want %s", got, want)
+	}
+
+	if _, err := MovieHash(bytes.NewReader(nil), 0); err == nil {
+		t.Error("MovieHash of an empty file = nil error")
+	}
+}
@@ -0,0 +1,79 @@
+package subtitles
+
+import (
+	"sync"
+	"time"
+)
+
+// Job states, as reported by State and /status.
+const (
+	StateFetching = "fetching"
+	StateReady    = "ready"
+	StateFailed   = "failed"
+)
+
+// Job is a subtitle fetch running in the background, so a slow
+// provider doesn't hold up buffering and playback. Files land in the
+// subs directory as providers deliver them; Done is the "subtitles
+// ready" signal for anything that wants to wait for them.
+type Job struct {
+	done chan struct{}
+
+	mu   sync.Mutex
+	subs []Subtitle
+	err  error
+}
+
+// Start runs fetch in a new goroutine.
+func Start(fetch func() ([]Subtitle, error)) *Job {
+	j := &Job{done: make(chan struct{})}
+	go func() {
+		subs, err := fetch()
+		j.mu.Lock()
+		j.subs, j.err = subs, err
+		j.mu.Unlock()
+		close(j.done)
+	}()
+	return j
+}
+
+// Done is closed once the fetch has finished, successfully or not.
+func (j *Job) Done() <-chan struct{} { return j.done }
+
+// Wait blocks until the fetch finishes or timeout passes, and reports
+// whether it finished.
+func (j *Job) Wait(timeout time.Duration) bool {
+	select {
+	case <-j.done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// State is StateFetching, StateReady or StateFailed.
+func (j *Job) State() string {
+	select {
+	case <-j.done:
+	default:
+		return StateFetching
+	}
+	if j.Err() != nil {
+		return StateFailed
+	}
+	return StateReady
+}
+
+// Subtitles returns what the fetch delivered; nil until it's done.
+func (j *Job) Subtitles() []Subtitle {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.subs
+}
+
+// Err returns the fetch's error; nil until it's done.
+func (j *Job) Err() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.err
+}
@@ -0,0 +1,44 @@
+package subtitles
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestJob_ReadyAfterSuccess(t *testing.T) {
+	release := make(chan struct{})
+	j := Start(func() ([]Subtitle, error) {
+		<-release
+		return []Subtitle{{Path: "movie.en.srt", Lang: "en"}}, nil
+	})
+
+	if got := j.State(); got != StateFetching {
+		t.Errorf("State before the fetch returns = %q, want %q", got, StateFetching)
+	}
+	if j.Wait(20 * time.Millisecond) {
+		t.Errorf("Wait returned true while the fetch was still running")
+	}
+
+	close(release)
+	if !j.Wait(time.Second) {
+		t.Fatal("Wait timed out after the fetch returned")
+	}
+	if got := j.State(); got != StateReady {
+		t.Errorf("State = %q, want %q", got, StateReady)
+	}
+	if subs := j.Subtitles(); len(subs) != 1 || subs[0].Lang != "en" {
+		t.Errorf("Subtitles = %+v, want the fetched en subtitle", subs)
+	}
+}
+
+func TestJob_FailedAfterError(t *testing.T) {
+	j := Start(func() ([]Subtitle, error) { return nil, errors.New("boom") })
+	<-j.Done()
+	if got := j.State(); got != StateFailed {
+		t.Errorf("State = %q, want %q", got, StateFailed)
+	}
+	if j.Err() == nil {
+		t.Errorf("Err = nil, want the fetch's error")
+	}
+}
@@ -0,0 +1,343 @@
+package subtitles
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+
+	"go-watch-something/internal/lang"
+	"go-watch-something/internal/release"
+	"go-watch-something/internal/subfile"
+	"go-watch-something/internal/utils"
+)
+
+// LibraryProvider is the Provider name of subtitles found in a Library.
+const LibraryProvider = "library"
+
+// indexName is the index file kept at the root of each library dir.
+const indexName = ".gws-index.json"
+
+// Library finds subtitles in local folders of previously downloaded
+// ones -- fully offline, so it's meant to run before any network
+// provider (see FetchLocalFirst). A subtitle matches a video by its
+// OpenSubtitles movie hash when one was recorded, otherwise by parsed
+// title, year and season/episode, with the closest release name
+// winning. Matches are copied into the video's dir.
+//
+// It's also a Storer: subtitles other providers fetch are stored into
+// the first dir, with the video's hash, so the next viewing finds them
+// here.
+type Library struct {
+	Dirs []string
+}
+
+func (Library) Name() string { return LibraryProvider }
+
+// libEntry is one subtitle file in a library's index. Size and ModTime
+// tell whether a file changed since it was indexed.
+type libEntry struct {
+	Path            string    `json:"path"` // relative to the library dir
+	Size            int64     `json:"size"`
+	ModTime         time.Time `json:"mod_time"`
+	Lang            string    `json:"lang,omitempty"`
+	HearingImpaired bool      `json:"hearing_impaired,omitempty"`
+	Release         string    `json:"release"`        // the release name it's for
+	Hash            string    `json:"hash,omitempty"` // movie hash of that release, when known
+}
+
+func (l Library) Fetch(_ context.Context, v Video, langs []string) ([]Subtitle, error) {
+	if len(l.Dirs) == 0 {
+		return nil, fmt.Errorf("%w: no library dirs", ErrNotConfigured)
+	}
+	name, err := v.releaseName()
+	if err != nil {
+		return nil, err
+	}
+	want := release.Parse(name)
+
+	// Matches from every dir compete; the first dir wins ties.
+	type match struct {
+		dir   string
+		entry libEntry
+		score int
+	}
+	best := make(map[string]match)
+	for _, dir := range l.Dirs {
+		entries, err := indexDir(dir)
+		if err != nil {
+			log.Printf("Subtitle library %s: %v", dir, err)
+			continue
+		}
+		for _, e := range entries {
+			for _, tag := range langs {
+				// File names carry all sorts of codes ("eng", "pt-BR",
+				// "pob"); the same language beats a regional variant.
+				if !lang.Match(e.Lang, tag) {
+					continue
+				}
+				score := matchScore(e, v.Hash, name, want)
+				if score > 0 && lang.Equal(e.Lang, tag) {
+					score += 2
+				}
+				if score > best[tag].score {
+					best[tag] = match{dir, e, score}
+				}
+			}
+		}
+	}
+
+	var subs []Subtitle
+	for _, lang := range langs {
+		m, ok := best[lang]
+		if !ok {
+			continue
+		}
+		src := filepath.Join(m.dir, filepath.FromSlash(m.entry.Path))
+		data, err := os.ReadFile(src)
+		if err != nil {
+			log.Printf("Subtitle library: %v", err)
+			continue
+		}
+		// Named after the video file itself, so players pick it up.
+		base := name
+		if v.Name != "" {
+			base = v.Name
+		}
+		dest := subtitleName(base, lang, m.entry.HearingImpaired, subfile.Format(src))
+		path, err := create(filepath.Join(v.outDir(), dest), data)
+		if err != nil {
+			return subs, err
+		}
+		subs = append(subs, Subtitle{Path: path, Lang: lang, Provider: LibraryProvider, HearingImpaired: m.entry.HearingImpaired})
+	}
+	if len(subs) == 0 {
+		return nil, fmt.Errorf("library: nothing matching %q", name)
+	}
+	fmt.Printf("Found %d subtitle(s) in the local library.\n", len(subs))
+	return subs, nil
+}
+
+// Store copies subs into the first library dir, recording them in its
+// index against v's hash and release name. Subtitles that came from a
+// library in the first place are skipped.
+func (l Library) Store(v Video, subs []Subtitle) error {
+	if len(l.Dirs) == 0 {
+		return nil
+	}
+	name, err := v.releaseName()
+	if err != nil {
+		return err
+	}
+	dir := l.Dirs[0]
+	idx, err := loadIndex(dir)
+	if err != nil {
+		return err
+	}
+	stored := 0
+	for _, s := range subs {
+		if s.Provider == LibraryProvider {
+			continue
+		}
+		data, err := os.ReadFile(s.Path)
+		if err != nil {
+			return err
+		}
+		dest := subtitleName(name, s.Lang, s.HearingImpaired, subfile.Format(s.Path))
+		path, err := create(filepath.Join(dir, dest), data)
+		if err != nil {
+			return err
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		rel := filepath.ToSlash(filepath.Base(path))
+		idx[rel] = libEntry{
+			Path: rel, Size: info.Size(), ModTime: info.ModTime(),
+			Lang: s.Lang, HearingImpaired: s.HearingImpaired,
+			Release: filepath.Base(name), Hash: v.Hash,
+		}
+		stored++
+	}
+	if stored == 0 {
+		return nil
+	}
+	return saveIndex(dir, idx)
+}
+
+// subtitleName is "<video>.<lang>[.hi].<ext>", the naming players
+// look for next to a video.
+func subtitleName(video, lang string, hi bool, format string) string {
+	base := filepath.Base(video)
+	if utils.IsVideoFile(base) {
+		base = strings.TrimSuffix(base, filepath.Ext(base))
+	}
+	parts := []string{base}
+	if lang != "" {
+		parts = append(parts, lang)
+	}
+	if hi {
+		parts = append(parts, "hi")
+	}
+	return strings.Join(append(parts, format), ".")
+}
+
+// matchScore rates how well e fits the video: a movie hash match beats
+// everything; otherwise title, year and season/episode have to agree
+// and the closer the release name, the better. 0 is no match.
+func matchScore(e libEntry, hash, name string, want release.Info) int {
+	if hash != "" && e.Hash == hash {
+		return 1000
+	}
+	got := release.Parse(e.Release)
+	if normTitle(got.Title) == "" || normTitle(got.Title) != normTitle(want.Title) {
+		return 0
+	}
+	if got.Year > 0 && want.Year > 0 && got.Year != want.Year {
+		return 0
+	}
+	if got.IsEpisode() != want.IsEpisode() {
+		return 0
+	}
+	if want.IsEpisode() && (got.Season != want.Season || !slices.Contains(got.Episodes, want.Episodes[0])) {
+		return 0
+	}
+	score := 100 + int(100*similarity(e.Release, name))
+	if got.Year > 0 && got.Year == want.Year {
+		score += 10
+	}
+	if !e.HearingImpaired {
+		score++
+	}
+	return score
+}
+
+// normTitle folds case and punctuation, so "Spider-Man: No Way Home"
+// and "Spider Man No Way Home" compare equal.
+func normTitle(s string) string {
+	return strings.Join(words(s), "")
+}
+
+// similarity is the Jaccard index of two release names' word sets:
+// 1 for the same words, 0 for none in common.
+func similarity(a, b string) float64 {
+	wa, wb := make(map[string]bool), make(map[string]bool)
+	for _, w := range words(a) {
+		wa[w] = true
+	}
+	for _, w := range words(b) {
+		wb[w] = true
+	}
+	common := 0
+	for w := range wa {
+		if wb[w] {
+			common++
+		}
+	}
+	if all := len(wa) + len(wb) - common; all > 0 {
+		return float64(common) / float64(all)
+	}
+	return 0
+}
+
+func words(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// indexDir brings dir's index up to date with the subtitle files
+// actually in it, and returns its entries. Files the index already
+// knows, unchanged, keep what was recorded -- notably their hash;
+// anything new is described from its file name alone.
+func indexDir(dir string) ([]libEntry, error) {
+	idx, err := loadIndex(dir)
+	if err != nil {
+		return nil, err
+	}
+	fresh := make(map[string]libEntry, len(idx))
+	changed := false
+	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || subfile.Format(d.Name()) == "" {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if e, ok := idx[rel]; ok && e.Size == info.Size() && e.ModTime.Equal(info.ModTime()) {
+			fresh[rel] = e
+			return nil
+		}
+		lang, hi := subfile.ParseName(d.Name())
+		fresh[rel] = libEntry{
+			Path: rel, Size: info.Size(), ModTime: info.ModTime(),
+			Lang: lang, HearingImpaired: hi, Release: subfile.BaseName(d.Name()),
+		}
+		changed = true
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if changed || len(fresh) != len(idx) {
+		if err := saveIndex(dir, fresh); err != nil {
+			// The index only saves re-parsing names next time; carry on.
+			log.Printf("Subtitle library %s: %v", dir, err)
+		}
+	}
+	entries := make([]libEntry, 0, len(fresh))
+	for _, e := range fresh {
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+func loadIndex(dir string) (map[string]libEntry, error) {
+	idx := make(map[string]libEntry)
+	data, err := os.ReadFile(filepath.Join(dir, indexName))
+	if errors.Is(err, fs.ErrNotExist) {
+		return idx, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var entries []libEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", indexName, err)
+	}
+	for _, e := range entries {
+		idx[e.Path] = e
+	}
+	return idx, nil
+}
+
+func saveIndex(dir string, idx map[string]libEntry) error {
+	entries := make([]libEntry, 0, len(idx))
+	for _, e := range idx {
+		entries = append(entries, e)
+	}
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(dir, indexName+".tmp")
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(dir, indexName))
+}
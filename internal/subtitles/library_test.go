@@ -0,0 +1,137 @@
+package subtitles
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+const librarySRT = "1\n00:00:01,000 --> 00:00:02,000\nHi\n"
+
+func writeLibrary(t *testing.T, names ...string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for _, n := range names {
+		path := filepath.Join(dir, filepath.FromSlash(n))
+		os.MkdirAll(filepath.Dir(path), 0o755)
+		if err := os.WriteFile(path, []byte(librarySRT+"\n"+n+"\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestLibrary_MatchesByTitleYearAndRelease(t *testing.T) {
+	lib := writeLibrary(t,
+		"Some.Movie.1999.DVDRip.en.srt",
+		"Some Movie (2024)/Some.Movie.2024.720p.BluRay.x264-OTHER.en.srt",
+		"Some Movie (2024)/Some.Movie.2024.1080p.WEB-DL.x264-GRP.en.srt",
+		"Some.Movie.2024.1080p.WEB-DL.x264-GRP.fr.srt",
+		"Different.Movie.2024.1080p.WEB-DL.x264-GRP.pt-BR.srt",
+	)
+	videoDir := t.TempDir()
+	v := Video{Dir: videoDir, Name: "Some.Movie.2024.1080p.WEB-DL.H264-GRP.mkv"}
+
+	subs, err := Library{Dirs: []string{lib}}.Fetch(context.Background(), v, []string{"en", "pt-BR"})
+	if err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+	if len(subs) != 1 || subs[0].Lang != "en" || subs[0].Provider != LibraryProvider {
+		t.Fatalf("Fetch = %+v, want just the en subtitle", subs)
+	}
+	if want := filepath.Join(videoDir, "Some.Movie.2024.1080p.WEB-DL.H264-GRP.en.srt"); subs[0].Path != want {
+		t.Errorf("copied to %s, want %s", subs[0].Path, want)
+	}
+	data, _ := os.ReadFile(subs[0].Path)
+	if want := librarySRT + "\nSome Movie (2024)/Some.Movie.2024.1080p.WEB-DL.x264-GRP.en.srt\n"; string(data) != want {
+		t.Errorf("copied %q, want the closest release's file", data)
+	}
+	if _, err := os.Stat(filepath.Join(lib, indexName)); err != nil {
+		t.Errorf("no index written: %v", err)
+	}
+}
+
+func TestLibrary_MatchesEpisodes(t *testing.T) {
+	lib := writeLibrary(t, "Show.S01E02.720p.HDTV.en.srt", "Show.S01E03.720p.HDTV.en.srt", "Show.S02E03.720p.HDTV.en.srt")
+	v := Video{Dir: t.TempDir(), Name: "Show.S01E03.1080p.WEB.mkv"}
+
+	subs, err := Library{Dirs: []string{lib}}.Fetch(context.Background(), v, []string{"en"})
+	if err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+	data, _ := os.ReadFile(subs[0].Path)
+	if want := librarySRT + "\nShow.S01E03.720p.HDTV.en.srt\n"; string(data) != want {
+		t.Errorf("copied %q, want S01E03's subtitle", data)
+	}
+}
+
+func TestLibrary_MatchesAnyLanguageCode(t *testing.T) {
+	lib := writeLibrary(t,
+		"Some.Movie.2024.1080p.WEB-DL.x264-GRP.eng.srt",
+		"Some.Movie.2024.1080p.WEB-DL.x264-GRP.por.srt",
+		"Some.Movie.2024.1080p.WEB-DL.x264-GRP.pt-BR.srt",
+	)
+	v := Video{Dir: t.TempDir(), Name: "Some.Movie.2024.1080p.WEB-DL.x264-GRP.mkv"}
+
+	subs, err := Library{Dirs: []string{lib}}.Fetch(context.Background(), v, []string{"en", "pt-BR"})
+	if err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+	if len(subs) != 2 {
+		t.Fatalf("Fetch = %+v, want en and pt-BR", subs)
+	}
+	for _, c := range []struct{ lang, file string }{
+		{"en", "Some.Movie.2024.1080p.WEB-DL.x264-GRP.eng.srt"},
+		{"pt-BR", "Some.Movie.2024.1080p.WEB-DL.x264-GRP.pt-BR.srt"}, // over .por
+	} {
+		i := slices.IndexFunc(subs, func(s Subtitle) bool { return s.Lang == c.lang })
+		if i < 0 {
+			t.Errorf("no %s subtitle in %+v", c.lang, subs)
+			continue
+		}
+		if data, _ := os.ReadFile(subs[i].Path); string(data) != librarySRT+"\n"+c.file+"\n" {
+			t.Errorf("%s: copied %q, want %s", c.lang, data, c.file)
+		}
+	}
+}
+
+func TestLibrary_StoreThenMatchByHash(t *testing.T) {
+	lib := t.TempDir()
+	videoDir := t.TempDir()
+	fetched := filepath.Join(videoDir, "whatever.en.srt")
+	os.WriteFile(fetched, []byte(librarySRT), 0o644)
+
+	l := Library{Dirs: []string{lib}}
+	v := Video{Dir: videoDir, Name: "Some.Movie.2024.1080p.mkv", Hash: "8e245d9679d31e12"}
+	if err := l.Store(v, []Subtitle{{Path: fetched, Lang: "en", Provider: "opensubtitles"}}); err != nil {
+		t.Fatalf("Store: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(lib, "Some.Movie.2024.1080p.en.srt")); err != nil {
+		t.Fatalf("stored file missing: %v", err)
+	}
+
+	// Same video under a name that parses to nothing useful: only the
+	// hash can match it.
+	renamed := Video{Dir: t.TempDir(), Name: "a8f3e1c94b2d7710.mkv", Hash: v.Hash}
+	subs, err := l.Fetch(context.Background(), renamed, []string{"en"})
+	if err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+	if len(subs) != 1 {
+		t.Errorf("Fetch = %+v, want the stored subtitle", subs)
+	}
+
+	other := Video{Dir: t.TempDir(), Name: "a8f3e1c94b2d7710.mkv", Hash: "0000000000000001"}
+	if _, err := l.Fetch(context.Background(), other, []string{"en"}); err == nil {
+		t.Error("Fetch for a different hash and no usable name = nil error")
+	}
+}
+
+func TestLibrary_NotConfiguredWithoutDirs(t *testing.T) {
+	_, err := Library{}.Fetch(context.Background(), Video{Dir: t.TempDir(), Name: "m.mkv"}, []string{"en"})
+	if !isNotConfigured(err) {
+		t.Errorf("Fetch with no dirs = %v, want ErrNotConfigured", err)
+	}
+}
@@ -0,0 +1,74 @@
+package subtitles
+
+import (
+	"context"
+	"log"
+
+	"go-watch-something/internal/lang"
+)
+
+// Storer is a Provider that can also keep subtitles other providers
+// fetched, to serve them itself next time -- a Library, for one.
+type Storer interface {
+	Store(v Video, subs []Subtitle) error
+}
+
+// FetchLocalFirst runs the local (offline) providers before touching
+// the network: each in order, for the languages the ones before it
+// didn't cover, then fetch for whatever is still missing. Only if some
+// language is still uncovered does fetch run at all, and what it
+// delivers is handed to every local provider that's a Storer. A fetch
+// failure isn't an error if the local providers found something.
+func FetchLocalFirst(ctx context.Context, local []Provider, v Video, langs []string, fetch func(langs []string) ([]Subtitle, error)) ([]Subtitle, error) {
+	var subs []Subtitle
+	missing := langs
+	for _, p := range local {
+		if len(missing) == 0 {
+			break
+		}
+		found, err := p.Fetch(ctx, v, missing)
+		if err != nil {
+			continue
+		}
+		found, _ = keepValid(found)
+		subs = append(subs, found...)
+		missing = uncovered(missing, found)
+	}
+	if len(missing) == 0 {
+		return subs, nil
+	}
+
+	fetched, err := fetch(missing)
+	if err != nil {
+		if len(subs) > 0 {
+			return subs, nil
+		}
+		return nil, err
+	}
+	for _, p := range local {
+		if s, ok := p.(Storer); ok {
+			if err := s.Store(v, fetched); err != nil {
+				log.Printf("Storing subtitles in %s: %v", p.Name(), err)
+			}
+		}
+	}
+	return append(subs, fetched...), nil
+}
+
+// uncovered is langs minus those some subtitle in subs is in.
+func uncovered(langs []string, subs []Subtitle) []string {
+	var out []string
+	for _, l := range langs {
+		covered := false
+		for _, s := range subs {
+			if lang.Match(s.Lang, l) {
+				covered = true
+				break
+			}
+		}
+		if !covered {
+			out = append(out, l)
+		}
+	}
+	return out
+}
@@ -0,0 +1,69 @@
+package subtitles
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// recordingStorer is a writingProvider that remembers what it was
+// asked to store.
+type recordingStorer struct {
+	writingProvider
+	stored *[]Subtitle
+}
+
+func (r recordingStorer) Store(_ Video, subs []Subtitle) error {
+	*r.stored = append(*r.stored, subs...)
+	return nil
+}
+
+func TestFetchLocalFirst_NetworkOnlyForMissingLanguages(t *testing.T) {
+	var stored []Subtitle
+	local := recordingStorer{writingProvider{name: "lib", files: map[string]string{"en": "hello"}}, &stored}
+	network := writingProvider{name: "net", files: map[string]string{"en": "hello", "pt-BR": "olá"}}
+	v := Video{Dir: t.TempDir()}
+
+	var asked []string
+	subs, err := FetchLocalFirst(context.Background(), []Provider{local}, v, []string{"en", "pt-BR"}, func(langs []string) ([]Subtitle, error) {
+		asked = langs
+		return network.Fetch(context.Background(), v, langs)
+	})
+	if err != nil {
+		t.Fatalf("FetchLocalFirst: %v", err)
+	}
+	if !reflect.DeepEqual(asked, []string{"pt-BR"}) {
+		t.Errorf("network asked for %v, want [pt-BR]", asked)
+	}
+	if len(subs) != 2 || subs[0].Provider != "lib" || subs[1].Provider != "net" {
+		t.Errorf("FetchLocalFirst = %+v, want lib's en and net's pt-BR", subs)
+	}
+	if len(stored) != 1 || stored[0].Lang != "pt-BR" {
+		t.Errorf("stored %+v, want the network's pt-BR", stored)
+	}
+}
+
+func TestFetchLocalFirst_SkipsNetworkWhenCovered(t *testing.T) {
+	local := writingProvider{name: "lib", files: map[string]string{"en": "hello"}}
+	subs, err := FetchLocalFirst(context.Background(), []Provider{local}, Video{Dir: t.TempDir()}, []string{"en"}, func([]string) ([]Subtitle, error) {
+		t.Error("network fetch called though the library covered every language")
+		return nil, nil
+	})
+	if err != nil || len(subs) != 1 {
+		t.Errorf("FetchLocalFirst = %+v, %v; want lib's en", subs, err)
+	}
+}
+
+func TestFetchLocalFirst_NetworkFailureKeepsLocal(t *testing.T) {
+	local := writingProvider{name: "lib", files: map[string]string{"en": "hello"}}
+	fail := func([]string) ([]Subtitle, error) { return nil, errors.New("offline") }
+
+	subs, err := FetchLocalFirst(context.Background(), []Provider{local}, Video{Dir: t.TempDir()}, []string{"en", "fr"}, fail)
+	if err != nil || len(subs) != 1 {
+		t.Errorf("FetchLocalFirst = %+v, %v; want lib's en and no error", subs, err)
+	}
+	if _, err := FetchLocalFirst(context.Background(), nil, Video{Dir: t.TempDir()}, []string{"fr"}, fail); err == nil {
+		t.Error("FetchLocalFirst with nothing local and a failed fetch = nil error")
+	}
+}
@@ -1,17 +1,22 @@
 package subtitles
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
-	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
+	"go-watch-something/internal/lang"
+	"go-watch-something/internal/release"
+	"go-watch-something/internal/subfile"
 	"go-watch-something/internal/utils"
 )
 
@@ -38,35 +43,46 @@ func NewOpenSubtitles() OpenSubtitles {
 
 func (o OpenSubtitles) Name() string { return "opensubtitles" }
 
-func (o OpenSubtitles) Fetch(videoDir string, langs []string) error {
+func (o OpenSubtitles) Fetch(ctx context.Context, v Video, langs []string) ([]Subtitle, error) {
 	if o.APIKey == "" {
-		return fmt.Errorf("%w: OPENSUBTITLES_API_KEY not set", ErrNotConfigured)
+		return nil, fmt.Errorf("%w: OPENSUBTITLES_API_KEY not set", ErrNotConfigured)
 	}
 
-	videoName, err := findVideoName(videoDir)
+	name, err := v.releaseName()
 	if err != nil {
-		return err
+		return nil, err
 	}
-	query := guessTitle(videoName)
+	info := release.Parse(name)
 
-	fmt.Printf("Fetching subtitles via OpenSubtitles for %q...\n", query)
-
-	fileID, err := o.search(query, langs)
-	if err != nil {
-		return fmt.Errorf("opensubtitles search: %w", err)
+	label := info.String()
+	if id := firstNonEmpty(v.IMDbID, v.TMDbID); id != "" {
+		label += " [" + id + "]"
 	}
+	fmt.Printf("Fetching subtitles via OpenSubtitles for %q...\n", label)
 
-	link, fileName, err := o.requestDownload(fileID)
+	results, err := o.search(ctx, searchParams(v, info, langs), label)
 	if err != nil {
-		return fmt.Errorf("opensubtitles download request: %w", err)
+		return nil, fmt.Errorf("opensubtitles search: %w", err)
 	}
 
-	if err := o.saveSubtitle(link, filepath.Join(videoDir, fileName)); err != nil {
-		return fmt.Errorf("opensubtitles fetch subtitle: %w", err)
+	// One file per requested language -- the best-ranked one, since the
+	// API sorts by download count. Each download spends daily quota.
+	var subs []Subtitle
+	var failures []string
+	for _, lang := range langs {
+		sub, err := o.fetchLang(ctx, v, results, lang, len(langs) == 1)
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", lang, err))
+			continue
+		}
+		subs = append(subs, sub)
+	}
+	if len(subs) == 0 {
+		return nil, fmt.Errorf("opensubtitles: %s", strings.Join(failures, "; "))
 	}
 
 	fmt.Println("Subtitles downloaded successfully via OpenSubtitles.")
-	return nil
+	return subs, nil
 }
 
 func (o OpenSubtitles) headers(req *http.Request) {
@@ -76,42 +92,144 @@ func (o OpenSubtitles) headers(req *http.Request) {
 }
 
 type searchResponse struct {
-	Data []struct {
-		Attributes struct {
-			Files []struct {
-				FileID int `json:"file_id"`
-			} `json:"files"`
-		} `json:"attributes"`
-	} `json:"data"`
+	Data []searchResult `json:"data"`
+}
+
+type searchResult struct {
+	Attributes struct {
+		Language        string `json:"language"`
+		HearingImpaired bool   `json:"hearing_impaired"`
+		Files           []struct {
+			FileID int `json:"file_id"`
+		} `json:"files"`
+	} `json:"attributes"`
+}
+
+// maxCandidates bounds how many files fetchLang downloads for one
+// language before giving up -- every download spends daily quota.
+const maxCandidates = 3
+
+// fetchLang downloads the best-ranked result in lang. A download that
+// turns out not to be a usable subtitle (an HTML error page, an empty
+// or garbled file -- see subfile.Sanitize) isn't saved; the next
+// result down is tried instead.
+func (o OpenSubtitles) fetchLang(ctx context.Context, v Video, results []searchResult, lang string, only bool) (Subtitle, error) {
+	candidates := pickFiles(results, lang, only)
+	if len(candidates) == 0 {
+		return Subtitle{}, fmt.Errorf("no results")
+	}
+	if len(candidates) > maxCandidates {
+		candidates = candidates[:maxCandidates]
+	}
+	var failures []string
+	for _, result := range candidates {
+		fileID := result.Attributes.Files[0].FileID
+		link, fileName, err := o.requestDownload(ctx, fileID, lang)
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("file %d: download request: %v", fileID, err))
+			continue
+		}
+		data, err := o.download(ctx, link)
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("file %d: fetch subtitle: %v", fileID, err))
+			continue
+		}
+		data, format, err := subfile.Sanitize(data, fileName)
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("file %d: %v", fileID, err))
+			continue
+		}
+		path := withFormat(filepath.Join(v.outDir(), fileName), format)
+		if err := os.WriteFile(path, data, 0o644); err != nil {
+			return Subtitle{}, err
+		}
+		return Subtitle{Path: path, Lang: lang, Provider: o.Name(), HearingImpaired: result.Attributes.HearingImpaired}, nil
+	}
+	return Subtitle{}, errors.New(strings.Join(failures, "; "))
+}
+
+// pickFiles returns the results in tag (as OpenSubtitles names it),
+// best-ranked first. Results the API didn't tag with a language are
+// taken as a match only when just one language was asked for.
+func pickFiles(results []searchResult, tag string, only bool) []searchResult {
+	want := lang.OpenSubtitles(tag)
+	var picked []searchResult
+	for _, r := range results {
+		l := r.Attributes.Language
+		if len(r.Attributes.Files) > 0 && (strings.EqualFold(l, want) || (l == "" && only)) {
+			picked = append(picked, r)
+		}
+	}
+	return picked
+}
+
+// searchParams builds the search query. An IMDb or TMDb ID from an NFO
+// replaces the title query outright -- it's exact, where the title is
+// a guess from a filename. Otherwise the parsed title is sent along
+// with the year. For episodes the NFO's ID is the show's rather than
+// the episode's, so it goes in as the parent ID, and the season and
+// episode numbers are sent either way -- an episode searched by show
+// alone matches every episode of the show.
+func searchParams(v Video, info release.Info, langs []string) url.Values {
+	params := url.Values{}
+	codes := make([]string, len(langs))
+	for i, l := range langs {
+		codes[i] = lang.OpenSubtitles(l)
+	}
+	params.Set("languages", strings.Join(codes, ","))
+	idPrefix := ""
+	if info.IsEpisode() {
+		idPrefix = "parent_"
+	}
+	switch {
+	case v.IMDbID != "":
+		// The API wants the numeric part only, without leading zeros.
+		params.Set(idPrefix+"imdb_id", strings.TrimLeft(strings.TrimPrefix(v.IMDbID, "tt"), "0"))
+	case v.TMDbID != "":
+		params.Set(idPrefix+"tmdb_id", v.TMDbID)
+	default:
+		params.Set("query", info.Title)
+		if info.Year > 0 {
+			params.Set("year", strconv.Itoa(info.Year))
+		}
+	}
+	if info.Season > 0 {
+		params.Set("season_number", strconv.Itoa(info.Season))
+	}
+	if info.IsEpisode() {
+		params.Set("episode_number", strconv.Itoa(info.Episodes[0]))
+	}
+	return params
 }
 
-func (o OpenSubtitles) search(query string, langs []string) (int, error) {
-	u := fmt.Sprintf("%s/subtitles?query=%s&languages=%s",
-		o.BaseURL, url.QueryEscape(query), url.QueryEscape(strings.Join(langs, ",")))
+func (o OpenSubtitles) search(ctx context.Context, params url.Values, label string) ([]searchResult, error) {
+	// Encode sorts the keys, which the API asks for (it redirects
+	// unsorted query strings).
+	u := o.BaseURL + "/subtitles?" + params.Encode()
 
-	req, err := http.NewRequest(http.MethodGet, u, nil)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
 	o.headers(req)
 
 	resp, err := o.Client.Do(req)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
-		return 0, fmt.Errorf("unexpected status %s", resp.Status)
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
 	}
 
 	var parsed searchResponse
 	if err := json.NewDecoder(resp.Body).Decode(&parsed); err != nil {
-		return 0, err
+		return nil, err
 	}
-	if len(parsed.Data) == 0 || len(parsed.Data[0].Attributes.Files) == 0 {
-		return 0, fmt.Errorf("no subtitles found for %q", query)
+	if len(parsed.Data) == 0 {
+		return nil, fmt.Errorf("no subtitles found for %q", label)
 	}
-	return parsed.Data[0].Attributes.Files[0].FileID, nil
+	return parsed.Data, nil
 }
 
 type downloadResponse struct {
@@ -119,13 +237,13 @@ type downloadResponse struct {
 	FileName string `json:"file_name"`
 }
 
-func (o OpenSubtitles) requestDownload(fileID int) (link, fileName string, err error) {
+func (o OpenSubtitles) requestDownload(ctx context.Context, fileID int, lang string) (link, fileName string, err error) {
 	body, err := json.Marshal(map[string]int{"file_id": fileID})
 	if err != nil {
 		return "", "", err
 	}
 
-	req, err := http.NewRequest(http.MethodPost, o.BaseURL+"/download", strings.NewReader(string(body)))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, o.BaseURL+"/download", strings.NewReader(string(body)))
 	if err != nil {
 		return "", "", err
 	}
@@ -148,26 +266,36 @@ func (o OpenSubtitles) requestDownload(fileID int) (link, fileName string, err e
 		return "", "", fmt.Errorf("response had no download link")
 	}
 	if parsed.FileName == "" {
-		parsed.FileName = "subtitle.srt"
+		parsed.FileName = "subtitle." + lang + ".srt"
 	}
 	return parsed.Link, parsed.FileName, nil
 }
 
-func (o OpenSubtitles) saveSubtitle(link, destPath string) error {
-	resp, err := o.Client.Get(link)
+// download fetches the subtitle file itself. Nothing is written until
+// the caller has checked the bytes are worth keeping.
+func (o OpenSubtitles) download(ctx context.Context, link string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, link, nil)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	resp, err := o.Client.Do(req)
+	if err != nil {
+		return nil, err
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("unexpected status %s", resp.Status)
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
 	}
+	return io.ReadAll(resp.Body)
+}
 
-	data, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return err
+func firstNonEmpty(ss ...string) string {
+	for _, s := range ss {
+		if s != "" {
+			return s
+		}
 	}
-	return os.WriteFile(destPath, data, 0o644)
+	return ""
 }
 
 // findVideoName returns the name of the first video file in dir.
@@ -183,22 +311,3 @@ func findVideoName(dir string) (string, error) {
 	}
 	return "", fmt.Errorf("no video file found in %s", dir)
 }
-
-// No trailing \b: "_" is a word character in regex terms, so a boundary
-// check right after the marker would fail to match underscore-separated
-// release names like "Movie_720p_BluRay" (verified -- an earlier version
-// with \b silently skipped past "_720p" and only stripped "_BluRay").
-// The leading [.\-_] delimiter is discrimination enough for this purpose.
-var releaseNoise = regexp.MustCompile(`(?i)[.\-_](720p|1080p|2160p|4k|x264|x265|hevc|web[- ]?dl|webrip|bluray|brrip|dvdrip|hdtv|yify|rarbg|proper|repack).*$`)
-
-// guessTitle turns a release-style filename into something closer to a
-// searchable movie title. This is inherently approximate -- release-name
-// parsing is a genuinely messy problem -- so it only strips the extension
-// and common scene-release tags/quality markers, then normalizes
-// separators to spaces.
-func guessTitle(fileName string) string {
-	name := strings.TrimSuffix(fileName, filepath.Ext(fileName))
-	name = releaseNoise.ReplaceAllString(name, "")
-	name = strings.NewReplacer(".", " ", "_", " ").Replace(name)
-	return strings.TrimSpace(name)
-}
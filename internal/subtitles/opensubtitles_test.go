@@ -1,17 +1,23 @@
 package subtitles
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+
+	"go-watch-something/internal/release"
 )
 
 func TestOpenSubtitles_NotConfiguredWithoutAPIKey(t *testing.T) {
 	o := OpenSubtitles{APIKey: ""}
-	err := o.Fetch(t.TempDir(), []string{"en"})
+	_, err := o.Fetch(context.Background(), Video{Dir: t.TempDir()}, []string{"en"})
 	if err == nil {
 		t.Fatal("Fetch with no API key = nil, want error")
 	}
@@ -36,7 +42,7 @@ func isNotConfigured(err error) bool {
 
 func TestOpenSubtitles_FullSearchDownloadFlow(t *testing.T) {
 	// The final fetch of the actual .srt bytes deliberately doesn't send
-	// Api-Key (saveSubtitle uses a plain Client.Get) -- the real API
+	// Api-Key (download doesn't call o.headers) -- the real API
 	// returns that download link on a different host (dl.opensubtitles.org
 	// vs api.opensubtitles.com), so sending the key there would leak it to
 	// an unrelated domain. Capture the key per-endpoint rather than in one
@@ -49,26 +55,13 @@ func TestOpenSubtitles_FullSearchDownloadFlow(t *testing.T) {
 		switch {
 		case r.Method == http.MethodGet && r.URL.Path == "/subtitles":
 			searchAPIKey = r.Header.Get("Api-Key")
-			if got := r.URL.Query().Get("query"); got != "Some Movie 2024" {
-				t.Errorf("search query = %q, want %q", got, "Some Movie 2024")
+			if got := r.URL.Query().Get("query"); got != "Some Movie" {
+				t.Errorf("search query = %q, want %q", got, "Some Movie")
 			}
-			json.NewEncoder(w).Encode(searchResponse{
-				Data: []struct {
-					Attributes struct {
-						Files []struct {
-							FileID int `json:"file_id"`
-						} `json:"files"`
-					} `json:"attributes"`
-				}{{
-					Attributes: struct {
-						Files []struct {
-							FileID int `json:"file_id"`
-						} `json:"files"`
-					}{Files: []struct {
-						FileID int `json:"file_id"`
-					}{{FileID: 42}}},
-				}},
-			})
+			if got := r.URL.Query().Get("year"); got != "2024" {
+				t.Errorf("search year = %q, want %q", got, "2024")
+			}
+			json.NewEncoder(w).Encode(searchResponse{Data: []searchResult{result("en", 42)}})
 		case r.Method == http.MethodPost && r.URL.Path == "/download":
 			downloadAPIKey = r.Header.Get("Api-Key")
 			var body map[string]int
@@ -95,9 +88,13 @@ func TestOpenSubtitles_FullSearchDownloadFlow(t *testing.T) {
 	}
 
 	o := OpenSubtitles{APIKey: "test-key", BaseURL: srv.URL, Client: http.DefaultClient}
-	if err := o.Fetch(videoDir, []string{"en"}); err != nil {
+	subs, err := o.Fetch(context.Background(), Video{Dir: videoDir}, []string{"en"})
+	if err != nil {
 		t.Fatalf("Fetch: %v", err)
 	}
+	if len(subs) != 1 || subs[0].Lang != "en" || subs[0].Provider != "opensubtitles" {
+		t.Errorf("Fetch = %+v, want one en subtitle from opensubtitles", subs)
+	}
 
 	if searchAPIKey != "test-key" {
 		t.Errorf("search Api-Key header = %q, want %q", searchAPIKey, "test-key")
@@ -127,21 +124,169 @@ func TestOpenSubtitles_NoResultsIsAnError(t *testing.T) {
 	os.WriteFile(filepath.Join(videoDir, "movie.mkv"), []byte("x"), 0o644)
 
 	o := OpenSubtitles{APIKey: "test-key", BaseURL: srv.URL, Client: http.DefaultClient}
-	if err := o.Fetch(videoDir, []string{"en"}); err == nil {
+	if _, err := o.Fetch(context.Background(), Video{Dir: videoDir}, []string{"en"}); err == nil {
 		t.Fatal("Fetch with no search results = nil, want error")
 	}
 }
 
-func TestGuessTitle(t *testing.T) {
-	cases := map[string]string{
-		"Some.Movie.2024.1080p.WEB-DL.x264.mkv": "Some Movie 2024",
-		"Another_Movie_720p_BluRay.mp4":         "Another Movie",
-		"plain name.mkv":                        "plain name",
+func TestOpenSubtitles_SearchSendsSeasonAndEpisode(t *testing.T) {
+	var query url.Values
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(searchResponse{})
+	}))
+	defer srv.Close()
+
+	videoDir := t.TempDir()
+	os.WriteFile(filepath.Join(videoDir, "Some.Show.S02E05.1080p.WEB.h264-GRP.mkv"), []byte("x"), 0o644)
+
+	o := OpenSubtitles{APIKey: "test-key", BaseURL: srv.URL, Client: http.DefaultClient}
+	o.Fetch(context.Background(), Video{Dir: videoDir}, []string{"en"})
+
+	want := map[string]string{"query": "Some Show", "season_number": "2", "episode_number": "5", "year": ""}
+	for k, v := range want {
+		if got := query.Get(k); got != v {
+			t.Errorf("search %s = %q, want %q", k, got, v)
+		}
+	}
+}
+
+func TestOpenSubtitles_OneFilePerLanguage(t *testing.T) {
+	var srv *httptest.Server
+	var downloaded []int
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/subtitles":
+			json.NewEncoder(w).Encode(searchResponse{Data: []searchResult{
+				result("en", 1), result("pt-BR", 2), result("en", 3),
+			}})
+		case "/download":
+			var body map[string]int
+			json.NewDecoder(r.Body).Decode(&body)
+			downloaded = append(downloaded, body["file_id"])
+			json.NewEncoder(w).Encode(downloadResponse{Link: srv.URL + "/file"})
+		case "/file":
+			w.Write([]byte("1\n00:00:01,000 --> 00:00:02,000\nHi\n"))
+		}
+	}))
+	defer srv.Close()
+
+	videoDir := t.TempDir()
+	o := OpenSubtitles{APIKey: "test-key", BaseURL: srv.URL, Client: http.DefaultClient}
+	subs, err := o.Fetch(context.Background(), Video{Dir: videoDir, Name: "movie.mkv"}, []string{"en", "pt-BR", "fr"})
+	if err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+	if len(downloaded) != 2 || downloaded[0] != 1 || downloaded[1] != 2 {
+		t.Errorf("downloaded file IDs %v, want [1 2] (best-ranked per language, nothing for fr)", downloaded)
+	}
+	if len(subs) != 2 || subs[0].Lang != "en" || subs[1].Lang != "pt-BR" {
+		t.Fatalf("Fetch = %+v, want en and pt-BR", subs)
 	}
-	for input, want := range cases {
-		if got := guessTitle(input); got != want {
-			t.Errorf("guessTitle(%q) = %q, want %q", input, got, want)
+	if subs[0].Path == subs[1].Path {
+		t.Errorf("both languages were written to %s", subs[0].Path)
+	}
+}
+
+func TestOpenSubtitles_InvalidDownloadTriesNextResult(t *testing.T) {
+	var srv *httptest.Server
+	var downloaded []int
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/subtitles":
+			json.NewEncoder(w).Encode(searchResponse{Data: []searchResult{
+				result("en", 1), result("en", 2), result("en", 3),
+			}})
+		case "/download":
+			var body map[string]int
+			json.NewDecoder(r.Body).Decode(&body)
+			downloaded = append(downloaded, body["file_id"])
+			json.NewEncoder(w).Encode(downloadResponse{Link: fmt.Sprintf("%s/file/%d", srv.URL, body["file_id"])})
+		case "/file/1":
+			w.Write([]byte("<!DOCTYPE html><html><body>Download limit reached</body></html>"))
+		case "/file/2":
+			w.Write([]byte("1\n00:00:01,000 --> 00:00:02,000\nHi\n"))
 		}
+	}))
+	defer srv.Close()
+
+	videoDir := t.TempDir()
+	o := OpenSubtitles{APIKey: "test-key", BaseURL: srv.URL, Client: http.DefaultClient}
+	subs, err := o.Fetch(context.Background(), Video{Dir: videoDir, Name: "movie.mkv"}, []string{"en"})
+	if err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+	if len(downloaded) != 2 || downloaded[1] != 2 {
+		t.Errorf("downloaded file IDs %v, want [1 2] (the HTML page skipped)", downloaded)
+	}
+	data, _ := os.ReadFile(subs[0].Path)
+	if !strings.HasPrefix(string(data), "1\n00:00:01,000") {
+		t.Errorf("saved %q, want file 2's subtitle", data)
+	}
+}
+
+// result is a search hit in lang with a single file.
+func result(lang string, fileID int) searchResult {
+	var r searchResult
+	r.Attributes.Language = lang
+	r.Attributes.Files = append(r.Attributes.Files, struct {
+		FileID int `json:"file_id"`
+	}{fileID})
+	return r
+}
+
+func TestSearchParams(t *testing.T) {
+	cases := []struct {
+		name  string
+		video Video
+		file  string
+		want  map[string]string
+	}{
+		{
+			"imdb id replaces the title query",
+			Video{IMDbID: "tt0133093"},
+			"The.Matrix.1999.1080p.mkv",
+			map[string]string{"imdb_id": "133093", "query": "", "year": ""},
+		},
+		{
+			"tmdb id when there's no imdb id",
+			Video{TMDbID: "603"},
+			"The.Matrix.1999.1080p.mkv",
+			map[string]string{"tmdb_id": "603", "query": ""},
+		},
+		{
+			"episode ids are the show's",
+			Video{IMDbID: "tt0944947"},
+			"Show.S02E05.mkv",
+			map[string]string{"parent_imdb_id": "944947", "imdb_id": "", "season_number": "2", "episode_number": "5"},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := searchParams(c.video, release.Parse(c.file), []string{"en"})
+			for k, v := range c.want {
+				if got.Get(k) != v {
+					t.Errorf("%s = %q, want %q (all params: %v)", k, got.Get(k), v, got)
+				}
+			}
+		})
+	}
+}
+
+func TestOpenSubtitles_ObfuscatedNameFallsBackToDisplayName(t *testing.T) {
+	var query string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query().Get("query")
+		json.NewEncoder(w).Encode(searchResponse{})
+	}))
+	defer srv.Close()
+
+	o := OpenSubtitles{APIKey: "test-key", BaseURL: srv.URL, Client: http.DefaultClient}
+	o.Fetch(context.Background(), Video{Dir: t.TempDir(), Name: "a8f3e1c94b2d7710.mkv", DisplayName: "Real.Movie.2021.1080p.WEB-DL"}, []string{"en"})
+
+	if query != "Real Movie" {
+		t.Errorf("search query = %q, want the display name's title %q", query, "Real Movie")
 	}
 }
 
@@ -0,0 +1,209 @@
+package subtitles
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"go-watch-something/internal/subfile"
+)
+
+// PluginPrefix is what an executable's name starts with for FindPlugins
+// to pick it up from PATH: gws-subs-<name>.
+const PluginPrefix = "gws-subs-"
+
+// pluginTimeout bounds a plugin run when Plugin.Timeout isn't set.
+const pluginTimeout = 60 * time.Second
+
+// maxPluginStderr is how much of a plugin's stderr is kept for error
+// messages.
+const maxPluginStderr = 8 << 10
+
+// Plugin is a subtitle provider in an external executable, so new
+// sources can be scripted in any language. It's run once per Fetch,
+// with a PluginRequest as JSON on stdin, and answers with a
+// PluginResponse as JSON on stdout. A non-zero exit status is a
+// failure; stderr ends up in the error.
+type Plugin struct {
+	Path    string
+	Timeout time.Duration // 0 is pluginTimeout
+}
+
+// PluginRequest is what a plugin gets on stdin. It should write
+// subtitles into OutputDir, though any readable path will do.
+type PluginRequest struct {
+	Video     PluginVideo `json:"video"`
+	Languages []string    `json:"languages"`
+	OutputDir string      `json:"output_dir"`
+}
+
+// PluginVideo is Video as plugins see it; empty fields are left out.
+type PluginVideo struct {
+	Name        string `json:"name,omitempty"`
+	DisplayName string `json:"display_name,omitempty"`
+	IMDbID      string `json:"imdb_id,omitempty"`
+	TMDbID      string `json:"tmdb_id,omitempty"`
+	Hash        string `json:"hash,omitempty"`
+	InfoHash    string `json:"info_hash,omitempty"`
+	TorrentFile string `json:"torrent_file,omitempty"`
+}
+
+// PluginResponse is what a plugin writes to stdout. Relative paths are
+// taken relative to OutputDir. Error, if set, fails the fetch with it.
+type PluginResponse struct {
+	Subtitles []PluginSubtitle `json:"subtitles"`
+	Error     string           `json:"error,omitempty"`
+}
+
+type PluginSubtitle struct {
+	Path            string `json:"path"`
+	Lang            string `json:"lang"`
+	HearingImpaired bool   `json:"hearing_impaired,omitempty"`
+}
+
+// Name is the executable's name without the gws-subs- prefix.
+func (p Plugin) Name() string {
+	name := strings.TrimSuffix(filepath.Base(p.Path), ".exe")
+	return strings.TrimPrefix(name, PluginPrefix)
+}
+
+func (p Plugin) Fetch(ctx context.Context, v Video, langs []string) ([]Subtitle, error) {
+	if _, err := exec.LookPath(p.Path); err != nil {
+		return nil, fmt.Errorf("%w: plugin %s: %v", ErrNotConfigured, p.Path, err)
+	}
+	dir, err := filepath.Abs(v.outDir())
+	if err != nil {
+		return nil, err
+	}
+	req, err := json.Marshal(PluginRequest{
+		Video: PluginVideo{
+			Name: v.Name, DisplayName: v.DisplayName, IMDbID: v.IMDbID, TMDbID: v.TMDbID,
+			Hash: v.Hash, InfoHash: v.InfoHash, TorrentFile: v.TorrentFile,
+		},
+		Languages: langs,
+		OutputDir: dir,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	timeout := p.Timeout
+	if timeout <= 0 {
+		timeout = pluginTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	fmt.Printf("Fetching subtitles via plugin %s...\n", p.Name())
+	cmd := exec.CommandContext(ctx, p.Path)
+	cmd.Stdin = bytes.NewReader(req)
+	var stdout bytes.Buffer
+	stderr := &cappedBuffer{max: maxPluginStderr}
+	cmd.Stdout, cmd.Stderr = &stdout, stderr
+	// Don't wait on children it left holding stdout once it's killed.
+	cmd.WaitDelay = time.Second
+	if err := cmd.Run(); err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			err = fmt.Errorf("timed out after %s", timeout)
+		}
+		return nil, fmt.Errorf("plugin %s: %w\nstderr: %s", p.Name(), err, stderr)
+	}
+
+	var resp PluginResponse
+	if err := json.Unmarshal(stdout.Bytes(), &resp); err != nil {
+		return nil, fmt.Errorf("plugin %s: bad response: %w\nstderr: %s", p.Name(), err, stderr)
+	}
+	if resp.Error != "" {
+		return nil, fmt.Errorf("plugin %s: %s", p.Name(), resp.Error)
+	}
+
+	var subs []Subtitle
+	for _, s := range resp.Subtitles {
+		path := s.Path
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(dir, path)
+		}
+		// Subtitles are served from the video's dir; bring in any the
+		// plugin left elsewhere.
+		if filepath.Dir(path) != dir {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return subs, fmt.Errorf("plugin %s: %w", p.Name(), err)
+			}
+			base := v.Name
+			if base == "" {
+				base = filepath.Base(path)
+			}
+			if path, err = create(filepath.Join(dir, subtitleName(base, s.Lang, s.HearingImpaired, subfile.Format(path))), data); err != nil {
+				return subs, err
+			}
+		}
+		subs = append(subs, Subtitle{Path: path, Lang: s.Lang, Provider: p.Name(), HearingImpaired: s.HearingImpaired})
+	}
+	if len(subs) == 0 {
+		return nil, fmt.Errorf("plugin %s found no subtitles", p.Name())
+	}
+	fmt.Printf("Plugin %s delivered %d subtitle(s).\n", p.Name(), len(subs))
+	return subs, nil
+}
+
+// FindPlugins returns a Plugin for every gws-subs-* executable on PATH,
+// followed by those in extra (from the config file). A name found
+// twice is only run once -- whichever came first wins, as with PATH
+// itself.
+func FindPlugins(extra []string) []Plugin {
+	var plugins []Plugin
+	seen := make(map[string]bool)
+	add := func(path string) {
+		p := Plugin{Path: path}
+		if seen[p.Name()] {
+			return
+		}
+		seen[p.Name()] = true
+		plugins = append(plugins, p)
+	}
+	for _, dir := range filepath.SplitList(os.Getenv("PATH")) {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, e := range entries {
+			if !strings.HasPrefix(e.Name(), PluginPrefix) || e.IsDir() {
+				continue
+			}
+			path := filepath.Join(dir, e.Name())
+			if _, err := exec.LookPath(path); err == nil {
+				add(path)
+			}
+		}
+	}
+	for _, path := range extra {
+		add(path)
+	}
+	return plugins
+}
+
+// cappedBuffer keeps the first max bytes written to it and drops the
+// rest, so a chatty plugin can't fill memory.
+type cappedBuffer struct {
+	buf bytes.Buffer
+	max int
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if room := b.max - b.buf.Len(); room > 0 {
+		b.buf.Write(p[:min(len(p), room)])
+	}
+	return len(p), nil
+}
+
+func (b *cappedBuffer) String() string {
+	return strings.TrimSpace(b.buf.String())
+}
@@ -0,0 +1,123 @@
+package subtitles
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writePlugin creates an executable named name in dir that runs script
+// (POSIX shell; its stdin is the request).
+func writePlugin(t *testing.T, dir, name, script string) string {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("stub scripts are POSIX shell, not written for windows")
+	}
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+script+"\n"), 0o755); err != nil {
+		t.Fatalf("writing plugin: %v", err)
+	}
+	return path
+}
+
+func TestPlugin_Fetch(t *testing.T) {
+	bin, out := t.TempDir(), t.TempDir()
+	// Echo the request to a file, to check what the plugin was given,
+	// then write one subtitle in the output dir and one elsewhere.
+	elsewhere := filepath.Join(t.TempDir(), "found.srt")
+	os.WriteFile(elsewhere, []byte(librarySRT), 0o644)
+	path := writePlugin(t, bin, "gws-subs-test", `
+cat > "`+bin+`/request.json"
+printf '%s' "`+librarySRT+`" > "`+out+`/Movie.en.srt"
+echo '{"subtitles": [{"path": "Movie.en.srt", "lang": "en"}, {"path": "`+elsewhere+`", "lang": "pt", "hearing_impaired": true}]}'`)
+
+	v := Video{Dir: out, Name: "Movie.mkv", IMDbID: "tt0133093", InfoHash: "abc"}
+	subs, err := Plugin{Path: path}.Fetch(context.Background(), v, []string{"en", "pt"})
+	if err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+	if len(subs) != 2 {
+		t.Fatalf("Fetch = %+v, want 2 subtitles", subs)
+	}
+	if subs[0].Path != filepath.Join(out, "Movie.en.srt") || subs[0].Lang != "en" || subs[0].Provider != "test" {
+		t.Errorf("first = %+v", subs[0])
+	}
+	if subs[1].Path != filepath.Join(out, "Movie.pt.hi.srt") || !subs[1].HearingImpaired {
+		t.Errorf("second = %+v, want it copied into the video dir", subs[1])
+	}
+
+	req, _ := os.ReadFile(filepath.Join(bin, "request.json"))
+	for _, want := range []string{`"name":"Movie.mkv"`, `"imdb_id":"tt0133093"`, `"languages":["en","pt"]`, `"output_dir":"` + out + `"`} {
+		if !strings.Contains(string(req), want) {
+			t.Errorf("request %s lacks %s", req, want)
+		}
+	}
+}
+
+func TestPlugin_Failures(t *testing.T) {
+	bin := t.TempDir()
+	cases := []struct {
+		name, script, wantErr string
+	}{
+		{"exit status", "echo 'no API key' >&2; exit 3", "no API key"},
+		{"bad json", "echo nope", "bad response"},
+		{"reported error", `echo '{"error": "rate limited"}'`, "rate limited"},
+		{"nothing found", `echo '{"subtitles": []}'`, "found no subtitles"},
+	}
+	for i, c := range cases {
+		path := writePlugin(t, bin, "gws-subs-"+string(rune('a'+i)), c.script)
+		_, err := Plugin{Path: path}.Fetch(context.Background(), Video{Dir: t.TempDir()}, []string{"en"})
+		if err == nil || !strings.Contains(err.Error(), c.wantErr) {
+			t.Errorf("%s: Fetch = %v, want an error mentioning %q", c.name, err, c.wantErr)
+		}
+	}
+}
+
+func TestPlugin_Timeout(t *testing.T) {
+	path := writePlugin(t, t.TempDir(), "gws-subs-slow", "exec sleep 5")
+	start := time.Now()
+	_, err := Plugin{Path: path, Timeout: 100 * time.Millisecond}.Fetch(context.Background(), Video{Dir: t.TempDir()}, []string{"en"})
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("Fetch = %v, want a timeout", err)
+	}
+	if time.Since(start) > 3*time.Second {
+		t.Errorf("Fetch took %s, want it cut off", time.Since(start))
+	}
+}
+
+func TestPlugin_NotConfiguredWhenMissing(t *testing.T) {
+	_, err := Plugin{Path: filepath.Join(t.TempDir(), "gws-subs-gone")}.Fetch(context.Background(), Video{Dir: t.TempDir()}, []string{"en"})
+	if !isNotConfigured(err) {
+		t.Errorf("Fetch = %v, want it to wrap ErrNotConfigured", err)
+	}
+}
+
+func TestFindPlugins(t *testing.T) {
+	first, second := t.TempDir(), t.TempDir()
+	writePlugin(t, first, "gws-subs-one", "exit 0")
+	writePlugin(t, second, "gws-subs-one", "exit 0") // shadowed
+	writePlugin(t, second, "gws-subs-two", "exit 0")
+	writePlugin(t, second, "unrelated", "exit 0")
+	os.WriteFile(filepath.Join(second, "gws-subs-notexec"), nil, 0o644)
+	original := os.Getenv("PATH")
+	os.Setenv("PATH", first+string(os.PathListSeparator)+second)
+	t.Cleanup(func() { os.Setenv("PATH", original) })
+
+	extra := writePlugin(t, t.TempDir(), "my-source", "exit 0")
+	var got []string
+	for _, p := range FindPlugins([]string{extra}) {
+		got = append(got, p.Name()+"="+p.Path)
+	}
+	want := []string{
+		"one=" + filepath.Join(first, "gws-subs-one"),
+		"two=" + filepath.Join(second, "gws-subs-two"),
+		"my-source=" + extra,
+	}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("FindPlugins = %q, want %q", got, want)
+	}
+}
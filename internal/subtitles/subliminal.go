@@ -1,47 +1,260 @@
 package subtitles
 
 import (
+	"bufio"
 	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
 	"io"
+	"maps"
+	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"go-watch-something/internal/lang"
 )
 
 // Subliminal fetches subtitles via the external `subliminal` CLI
 // (https://github.com/Diaoul/subliminal). Requires it to be installed
-// separately -- see README.
-type Subliminal struct{}
+// separately -- see README. The zero value uses subliminal's own
+// defaults; the fields map onto its command line options, and come
+// from the config file's "subliminal" object.
+type Subliminal struct {
+	Providers       []string              `json:"providers,omitempty"` // -p, e.g. "opensubtitles", "podnapisi"
+	Refiners        []string              `json:"refiners,omitempty"`  // -r, e.g. "omdb", "tvdb"
+	Credentials     map[string]Credential `json:"credentials,omitempty"`
+	HearingImpaired bool                  `json:"hearing_impaired,omitempty"` // -hi: prefer HI subtitles
+	MinScore        int                   `json:"min_score,omitempty"`        // -m, as a percentage
+}
+
+// Credential is a provider login, passed to subliminal in a config
+// file (see config) rather than on its command line.
+type Credential struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
 
 func (Subliminal) Name() string { return "subliminal" }
 
-func (Subliminal) Fetch(videoDir string, langs []string) error {
+func (s Subliminal) Fetch(ctx context.Context, v Video, langs []string) ([]Subtitle, error) {
 	if _, err := exec.LookPath("subliminal"); err != nil {
-		return fmt.Errorf("%w: subliminal binary not found on PATH", ErrNotConfigured)
+		return nil, fmt.Errorf("%w: subliminal binary not found on PATH", ErrNotConfigured)
 	}
 
 	fmt.Println("Fetching subtitles via subliminal...")
 
-	absPath, err := filepath.Abs(videoDir)
+	absPath, err := filepath.Abs(v.Dir)
 	if err != nil {
-		return fmt.Errorf("failed to get absolute path: %w", err)
+		return nil, fmt.Errorf("failed to get absolute path: %w", err)
 	}
-
-	args := []string{"download"}
-	for _, lang := range langs {
-		args = append(args, "-l", lang)
+	outDir, err := filepath.Abs(v.outDir())
+	if err != nil {
+		return nil, fmt.Errorf("failed to get absolute path: %w", err)
+	}
+	target, cleanup, err := s.target(v, absPath)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+	config, err := s.config()
+	if err != nil {
+		return nil, err
+	}
+	if config != "" {
+		defer os.Remove(config)
 	}
-	args = append(args, absPath)
 
-	cmd := exec.Command("subliminal", args...)
+	started := time.Now()
+	cmd := exec.CommandContext(ctx, "subliminal", s.args(langs, outDir, target, config)...)
 	cmd.Stdout = io.Discard
+	// --debug logs go to stderr, and that's where what was saved, from
+	// which provider, is reported.
 	var stderr bytes.Buffer
 	cmd.Stderr = &stderr
 
 	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("subliminal download failed: %w\nstderr: %s", err, stderr.String())
+		return nil, fmt.Errorf("subliminal download failed: %w\nstderr: %s", err, tail(stderr.String(), 2048))
+	}
+
+	subs := s.saved(stderr.String(), outDir, langs)
+	if len(subs) == 0 {
+		// Its log format isn't an interface; fall back to looking for
+		// the files it would have written.
+		subs = s.written(v, outDir, langs, started)
+	}
+	if len(subs) == 0 {
+		return nil, fmt.Errorf("subliminal found no subtitles")
+	}
+	for _, sub := range subs {
+		fmt.Printf("subliminal: %s from %s -> %s\n", sub.Lang, sub.Provider, filepath.Base(sub.Path))
+	}
+	return subs, nil
+}
+
+// args builds subliminal's command line: global options (the config
+// file, if there is one, and --debug) come before the download
+// command, its own after.
+func (s Subliminal) args(langs []string, dir, target, config string) []string {
+	var args []string
+	if config != "" {
+		args = append(args, "-c", config)
+	}
+	args = append(args, "--debug", "download")
+	for _, l := range langs {
+		args = append(args, "-l", lang.Subliminal(l))
+	}
+	for _, p := range s.Providers {
+		args = append(args, "-p", p)
+	}
+	for _, r := range s.Refiners {
+		args = append(args, "-r", r)
+	}
+	if s.HearingImpaired {
+		args = append(args, "-hi")
+	}
+	if s.MinScore > 0 {
+		args = append(args, "-m", strconv.Itoa(s.MinScore))
+	}
+	return append(args, "-d", dir, target)
+}
+
+// config writes the provider logins to a subliminal config file, and
+// returns its path ("" without any) for the caller to remove. Given as
+// --<provider> <username> <password>, they'd be in the process list
+// for every user on the machine to see; the file is readable by its
+// owner only. It's TOML, and a JSON string is a valid TOML one.
+func (s Subliminal) config() (string, error) {
+	if len(s.Credentials) == 0 {
+		return "", nil
 	}
+	var b strings.Builder
+	for _, provider := range slices.Sorted(maps.Keys(s.Credentials)) {
+		c := s.Credentials[provider]
+		user, _ := json.Marshal(c.Username)
+		pass, _ := json.Marshal(c.Password)
+		fmt.Fprintf(&b, "[provider.%s]\nusername = %s\npassword = %s\n\n", provider, user, pass)
+	}
+	f, err := os.CreateTemp("", "gws-subliminal-*.toml")
+	if err != nil {
+		return "", fmt.Errorf("writing subliminal config: %w", err)
+	}
+	_, err = f.WriteString(b.String())
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", fmt.Errorf("writing subliminal config: %w", err)
+	}
+	return f.Name(), nil
+}
 
-	fmt.Println("Subtitles downloaded successfully via subliminal.")
-	return nil
+// target is what to point subliminal at: the video file itself when
+// it's on disk, so it only looks at that one file and can hash it.
+// Without one (in-memory storage, or nothing written yet) it gets a
+// placeholder with the video's name and size, in a temp dir, which
+// is enough for name-based matching; -d sends the subtitles to
+// v.OutDir either way. Without a name at all, it's given the dir.
+func (s Subliminal) target(v Video, dir string) (string, func(), error) {
+	nothing := func() {}
+	if v.Name == "" {
+		return dir, nothing, nil
+	}
+	path := filepath.Join(dir, v.Name)
+	if _, err := os.Stat(path); err == nil {
+		return path, nothing, nil
+	}
+	tmp, err := os.MkdirTemp("", "gws-subliminal-")
+	if err != nil {
+		return "", nothing, err
+	}
+	cleanup := func() { os.RemoveAll(tmp) }
+	placeholder := filepath.Join(tmp, v.Name)
+	f, err := os.Create(placeholder)
+	if err == nil {
+		err = f.Truncate(v.Size) // sparse, so no disk is spent on it
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		cleanup()
+		return "", nothing, fmt.Errorf("creating subliminal placeholder: %w", err)
+	}
+	return placeholder, cleanup, nil
+}
+
+// savedLine matches subliminal's debug log of a saved subtitle:
+//
+//	INFO:subliminal.core:Saving <OpenSubtitlesSubtitle '1954' [pt-BR]> to '/dir/movie.pt-BR.srt'
+var savedLine = regexp.MustCompile(`Saving <(\w+?)Subtitle .*\[([^\]]+)\]> to (?:'(.+)'|"(.+)")\s*$`)
+
+// saved parses the subtitles subliminal reports saving out of its
+// debug log. Languages are given back as they were asked for ("pt-BR"
+// rather than whatever subliminal printed), and only files in dir are
+// taken. The provider is reported as "subliminal/<its provider>".
+func (Subliminal) saved(log, dir string, langs []string) []Subtitle {
+	var subs []Subtitle
+	sc := bufio.NewScanner(strings.NewReader(log))
+	sc.Buffer(nil, 1<<20)
+	for sc.Scan() {
+		m := savedLine.FindStringSubmatch(sc.Text())
+		if m == nil {
+			continue
+		}
+		path := m[3] + m[4] // Python quotes with whichever it can
+		if filepath.Dir(path) != dir {
+			continue
+		}
+		lang := m[2]
+		for _, l := range langs {
+			if strings.EqualFold(l, lang) || strings.EqualFold(strings.SplitN(l, "-", 2)[0], lang) {
+				lang = l
+				break
+			}
+		}
+		subs = append(subs, Subtitle{Path: path, Lang: lang, Provider: "subliminal/" + strings.ToLower(m[1])})
+	}
+	return subs
+}
+
+// written finds the files subliminal just saved. It exits 0 whether or
+// not it found anything, and names what it saves
+// "<video name minus extension>.<lang>.srt", so that's what's looked
+// for -- modified since the run started, in case an older file with
+// the same name was already there.
+func (Subliminal) written(v Video, dir string, langs []string, since time.Time) []Subtitle {
+	name := v.Name
+	if name == "" {
+		var err error
+		if name, err = findVideoName(v.Dir); err != nil {
+			return nil
+		}
+	}
+	base := strings.TrimSuffix(name, filepath.Ext(name))
+
+	var subs []Subtitle
+	for _, lang := range langs {
+		path := filepath.Join(dir, base+"."+lang+".srt")
+		info, err := os.Stat(path)
+		if err != nil || info.ModTime().Before(since.Add(-time.Second)) {
+			continue
+		}
+		subs = append(subs, Subtitle{Path: path, Lang: lang, Provider: "subliminal"})
+	}
+	return subs
+}
+
+// tail is the last n bytes of s, for error messages.
+func tail(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return "..." + s[len(s)-n:]
 }
@@ -1,7 +1,11 @@
 package subtitles
 
 import (
+	"context"
 	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
 	"testing"
 )
 
@@ -10,7 +14,7 @@ func TestSubliminal_NotConfiguredWhenBinaryMissing(t *testing.T) {
 	os.Setenv("PATH", t.TempDir()) // empty dir -- subliminal definitely not here
 	defer os.Setenv("PATH", original)
 
-	err := Subliminal{}.Fetch(t.TempDir(), []string{"en"})
+	_, err := Subliminal{}.Fetch(context.Background(), Video{Dir: t.TempDir()}, []string{"en"})
 	if err == nil {
 		t.Fatal("Fetch with no subliminal on PATH = nil, want error")
 	}
@@ -18,3 +22,138 @@ func TestSubliminal_NotConfiguredWhenBinaryMissing(t *testing.T) {
 		t.Errorf("Fetch with no subliminal on PATH = %v, want it to wrap ErrNotConfigured", err)
 	}
 }
+
+// withStubSubliminal puts a fake `subliminal` on PATH that runs script
+// (POSIX shell; "$dir" is the -d directory it was given, "$target" the
+// video or directory it was pointed at, and "$args" all of it).
+func withStubSubliminal(t *testing.T, script string) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("stub scripts are POSIX shell, not written for windows")
+	}
+	bin := t.TempDir()
+	stub := "#!/bin/sh\nargs=\"$*\"\nfor a; do [ \"$prev\" = -d ] && dir=$a; prev=$a; target=$a; done\n" + script + "\n"
+	if err := os.WriteFile(filepath.Join(bin, "subliminal"), []byte(stub), 0o755); err != nil {
+		t.Fatalf("writing stub: %v", err)
+	}
+	original := os.Getenv("PATH")
+	os.Setenv("PATH", bin)
+	t.Cleanup(func() { os.Setenv("PATH", original) })
+}
+
+func TestSubliminal_ReportsFilesItWrote(t *testing.T) {
+	withStubSubliminal(t, `echo sub > "$dir/movie.en.srt"`)
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "movie.mkv"), []byte("x"), 0o644)
+
+	subs, err := Subliminal{}.Fetch(context.Background(), Video{Dir: dir}, []string{"en", "fr"})
+	if err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+	if len(subs) != 1 || subs[0].Lang != "en" || subs[0].Path != filepath.Join(dir, "movie.en.srt") {
+		t.Errorf("Fetch = %+v, want just movie.en.srt", subs)
+	}
+}
+
+func TestSubliminal_NothingFoundIsAnError(t *testing.T) {
+	withStubSubliminal(t, "exit 0")
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "movie.mkv"), []byte("x"), 0o644)
+
+	if _, err := (Subliminal{}).Fetch(context.Background(), Video{Dir: dir}, []string{"en"}); err == nil {
+		t.Error("Fetch when subliminal saved nothing = nil error, want error")
+	}
+}
+
+func TestSubliminal_ReportsWhatItsLogSaysItSaved(t *testing.T) {
+	dir := t.TempDir()
+	withStubSubliminal(t, `
+echo sub > "$dir/movie.pt-BR.srt"
+echo "DEBUG:subliminal.core:Listing subtitles" >&2
+echo "INFO:subliminal.core:Saving <PodnapisiSubtitle 'abc' [pt-BR]> to '$dir/movie.pt-BR.srt'" >&2
+echo "INFO:subliminal.core:Saving <Addic7edSubtitle \"it's\" [en]> to \"/elsewhere/movie.en.srt\"" >&2`)
+	os.WriteFile(filepath.Join(dir, "movie.mkv"), []byte("x"), 0o644)
+
+	subs, err := Subliminal{}.Fetch(context.Background(), Video{Dir: dir, Name: "movie.mkv"}, []string{"en", "pt-br"})
+	if err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+	want := Subtitle{Path: filepath.Join(dir, "movie.pt-BR.srt"), Lang: "pt-br", Provider: "subliminal/podnapisi"}
+	if len(subs) != 1 || subs[0] != want {
+		t.Errorf("Fetch = %+v, want [%+v]", subs, want)
+	}
+}
+
+func TestSubliminal_Args(t *testing.T) {
+	s := Subliminal{
+		Providers:       []string{"podnapisi", "opensubtitles"},
+		Refiners:        []string{"omdb"},
+		Credentials:     map[string]Credential{"opensubtitles": {"me", "secret"}, "addic7ed": {"a", "b"}},
+		HearingImpaired: true,
+		MinScore:        70,
+	}
+	got := strings.Join(s.args([]string{"en", "pt-BR"}, "/subs", "/subs/movie.mkv", "/tmp/config.toml"), " ")
+	want := "-c /tmp/config.toml --debug download -l en -l pt-BR -p podnapisi -p opensubtitles -r omdb -hi -m 70 -d /subs /subs/movie.mkv"
+	if got != want {
+		t.Errorf("args =\n%s\nwant\n%s", got, want)
+	}
+	if got := strings.Join(Subliminal{}.args([]string{"en"}, "/subs", "/subs", ""), " "); got != "--debug download -l en -d /subs /subs" {
+		t.Errorf("zero value args = %s", got)
+	}
+}
+
+func TestSubliminal_CredentialsInConfigFile(t *testing.T) {
+	withStubSubliminal(t, `PATH=/usr/bin:/bin; echo "$args" > "$dir/args"; [ "$1" = -c ] && cat "$2" > "$dir/config" && ls -l "$2" | cut -c1-10 > "$dir/mode"; exit 0`)
+	dir := t.TempDir()
+	s := Subliminal{Credentials: map[string]Credential{"opensubtitles": {"me", `se"cret`}, "addic7ed": {"a", "b"}}}
+	s.Fetch(context.Background(), Video{Dir: dir, Name: "movie.mkv"}, []string{"en"})
+
+	args, _ := os.ReadFile(filepath.Join(dir, "args"))
+	if strings.Contains(string(args), "cret") || !strings.HasPrefix(string(args), "-c ") {
+		t.Errorf("args = %q, want the config file and no password", args)
+	}
+	config, _ := os.ReadFile(filepath.Join(dir, "config"))
+	want := "[provider.addic7ed]\nusername = \"a\"\npassword = \"b\"\n\n" +
+		"[provider.opensubtitles]\nusername = \"me\"\npassword = \"se\\\"cret\"\n\n"
+	if string(config) != want {
+		t.Errorf("config =\n%s\nwant\n%s", config, want)
+	}
+	if mode, _ := os.ReadFile(filepath.Join(dir, "mode")); strings.TrimSpace(string(mode)) != "-rw-------" {
+		t.Errorf("config mode = %q, want -rw-------", mode)
+	}
+	path := strings.Fields(string(args))[1]
+	if _, err := os.Stat(path); err == nil {
+		t.Errorf("config %s left behind after the run", path)
+	}
+}
+
+func TestSubliminal_TargetsTheVideo(t *testing.T) {
+	withStubSubliminal(t, `PATH=/usr/bin:/bin; echo "$target" > "$dir/target"; echo "$args" > "$dir/args"; [ -f "$target" ] && wc -c < "$target" > "$dir/size"; exit 0`)
+	read := func(path string) string {
+		data, _ := os.ReadFile(path)
+		return strings.TrimSpace(string(data))
+	}
+
+	// On disk: pointed straight at it.
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "movie.mkv"), []byte("x"), 0o644)
+	Subliminal{}.Fetch(context.Background(), Video{Dir: dir, Name: "movie.mkv"}, []string{"en"})
+	if got := read(filepath.Join(dir, "target")); got != filepath.Join(dir, "movie.mkv") {
+		t.Errorf("target with the video on disk = %q", got)
+	}
+
+	// Not on disk: a placeholder of the same name and size elsewhere,
+	// with subtitles still sent to the video's dir.
+	dir = t.TempDir()
+	Subliminal{}.Fetch(context.Background(), Video{Dir: dir, Name: "movie.mkv", Size: 12345}, []string{"en"})
+	target := read(filepath.Join(dir, "target"))
+	if filepath.Base(target) != "movie.mkv" || filepath.Dir(target) == dir {
+		t.Errorf("target without the video on disk = %q, want a placeholder named movie.mkv elsewhere", target)
+	}
+	if got := read(filepath.Join(dir, "size")); got != "12345" {
+		t.Errorf("placeholder size = %q, want 12345", got)
+	}
+	if _, err := os.Stat(target); !os.IsNotExist(err) {
+		t.Errorf("placeholder left behind: %v", err)
+	}
+}
@@ -1,41 +1,119 @@
-// Package subtitles fetches subtitle files for a downloaded video,
-// trying multiple providers in order so one provider's absence or failure
-// doesn't mean giving up on subtitles entirely.
+// Package subtitles fetches subtitle files for a downloaded video from
+// multiple providers -- either in order, stopping at the first that
+// delivers (FetchWithFallback), or all at once (FetchAll) -- so one
+// provider's absence or failure doesn't mean giving up on subtitles
+// entirely.
 package subtitles
 
 import (
+	"context"
 	"fmt"
 	"strings"
+
+	"go-watch-something/internal/release"
 )
 
-// Provider fetches subtitle files for the video(s) in videoDir, in the
-// given languages, writing them next to the video. ErrNotConfigured
-// signals the provider is unavailable in this environment (missing
-// binary, missing API key, ...) rather than a real failure -- callers
-// move on to the next provider without logging it as an error.
+// Video describes the video subtitles are wanted for.
+type Video struct {
+	Dir  string // where the video is (or would be) on disk; subtitles are written here
+	Name string // the video's file name, "" to look for one in Dir
+	Size int64  // the video's size in bytes, 0 if unknown
+
+	// DisplayName is the torrent's own name (the magnet's dn, or the
+	// info name), for when Name is obfuscated.
+	DisplayName string
+
+	// IMDbID ("tt0133093") and TMDbID ("603") come from .nfo files in
+	// the torrent, when it has any. Either may be empty.
+	IMDbID string
+	TMDbID string
+
+	// Hash is the OpenSubtitles movie hash of the video (see
+	// MovieHash), "" if it couldn't be computed.
+	Hash string
+
+	// InfoHash is the torrent's info-hash and TorrentFile the video's
+	// path within it -- what a Cache keys on. Either may be empty.
+	InfoHash    string
+	TorrentFile string
+
+	// OutDir is where subtitles are written, "" for Dir. FetchAll
+	// gives each provider its own, so files with the same name don't
+	// overwrite each other.
+	OutDir string
+}
+
+// outDir is where providers write v's subtitles.
+func (v Video) outDir() string {
+	if v.OutDir != "" {
+		return v.OutDir
+	}
+	return v.Dir
+}
+
+// releaseName is the name to parse for title, year and episode: the
+// video's file name, or the torrent's display name when the file name
+// is obfuscated.
+func (v Video) releaseName() (string, error) {
+	name := v.Name
+	if name == "" {
+		var err error
+		if name, err = findVideoName(v.Dir); err != nil {
+			return "", err
+		}
+	}
+	if release.Obfuscated(name) && v.DisplayName != "" {
+		return v.DisplayName, nil
+	}
+	return name, nil
+}
+
+// Subtitle is one file a provider delivered.
+type Subtitle struct {
+	Path            string
+	Lang            string // as requested, e.g. "pt-BR"
+	Provider        string
+	HearingImpaired bool
+}
+
+// Provider fetches subtitle files for v, in the given languages,
+// writing them into v.OutDir (v.Dir, next to the video, by default),
+// and reports the files it wrote. Finding nothing is an error, not an
+// empty result. ErrNotConfigured signals the provider is unavailable
+// in this environment (missing binary, missing API key, ...) rather
+// than a real failure -- callers move on to the next provider without
+// logging it as an error.
 type Provider interface {
 	Name() string
-	Fetch(videoDir string, langs []string) error
+	Fetch(ctx context.Context, v Video, langs []string) ([]Subtitle, error)
 }
 
 // ErrNotConfigured is returned by a Provider whose prerequisites (a
 // binary on PATH, an API key, ...) aren't met.
 var ErrNotConfigured = fmt.Errorf("subtitles: provider not configured")
 
-// FetchWithFallback tries each provider in order, returning nil on the
-// first success. If every provider fails or is unconfigured, it returns
-// an error summarizing what was tried.
-func FetchWithFallback(providers []Provider, videoDir string, langs []string) error {
+// FetchWithFallback tries each provider in order, returning the first
+// success's subtitles. Every file is checked before it's accepted (see
+// checkFile); a provider whose files all turn out to be junk counts as
+// a failure, and the next one is tried. If every provider fails or is
+// unconfigured, it returns an error summarizing what was tried.
+func FetchWithFallback(ctx context.Context, providers []Provider, v Video, langs []string) ([]Subtitle, error) {
 	var failures []string
 	for _, p := range providers {
-		err := p.Fetch(videoDir, langs)
-		if err == nil {
-			return nil
+		subs, err := p.Fetch(ctx, v, langs)
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", p.Name(), err))
+			continue
+		}
+		valid, invalid := keepValid(subs)
+		if len(subs) > 0 && len(valid) == 0 {
+			failures = append(failures, fmt.Sprintf("%s: %s", p.Name(), strings.Join(invalid, "; ")))
+			continue
 		}
-		failures = append(failures, fmt.Sprintf("%s: %v", p.Name(), err))
+		return valid, nil
 	}
 	if len(providers) == 0 {
-		return fmt.Errorf("subtitles: no providers configured")
+		return nil, fmt.Errorf("subtitles: no providers configured")
 	}
-	return fmt.Errorf("subtitles: all providers failed:\n%s", strings.Join(failures, "\n"))
+	return nil, fmt.Errorf("subtitles: all providers failed:\n%s", strings.Join(failures, "\n"))
 }
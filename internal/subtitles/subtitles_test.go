@@ -1,6 +1,7 @@
 package subtitles
 
 import (
+	"context"
 	"errors"
 	"testing"
 )
@@ -10,8 +11,10 @@ type fakeProvider struct {
 	err  error
 }
 
-func (f fakeProvider) Name() string                 { return f.name }
-func (f fakeProvider) Fetch(string, []string) error { return f.err }
+func (f fakeProvider) Name() string { return f.name }
+func (f fakeProvider) Fetch(context.Context, Video, []string) ([]Subtitle, error) {
+	return nil, f.err
+}
 
 func TestFetchWithFallback_FirstSuccessWins(t *testing.T) {
 	calledSecond := false
@@ -20,7 +23,7 @@ func TestFetchWithFallback_FirstSuccessWins(t *testing.T) {
 		fakeProviderFunc{name: "second", fn: func() error { calledSecond = true; return nil }},
 	}
 
-	if err := FetchWithFallback(providers, "/tmp/whatever", []string{"en"}); err != nil {
+	if _, err := FetchWithFallback(context.Background(), providers, Video{Dir: "/tmp/whatever"}, []string{"en"}); err != nil {
 		t.Fatalf("FetchWithFallback: %v", err)
 	}
 	if calledSecond {
@@ -34,9 +37,25 @@ func TestFetchWithFallback_FallsThroughOnNotConfigured(t *testing.T) {
 		fakeProvider{name: "works", err: nil},
 	}
 
-	if err := FetchWithFallback(providers, "/tmp/whatever", []string{"en"}); err != nil {
+	if _, err := FetchWithFallback(context.Background(), providers, Video{Dir: "/tmp/whatever"}, []string{"en"}); err != nil {
+		t.Fatalf("FetchWithFallback: %v", err)
+	}
+}
+
+func TestFetchWithFallback_InvalidFilesFallThrough(t *testing.T) {
+	dir := t.TempDir()
+	providers := []Provider{
+		writingProvider{name: "junk", files: map[string]string{"en": "raw:not a subtitle"}},
+		writingProvider{name: "good", files: map[string]string{"en": "hello"}},
+	}
+
+	subs, err := FetchWithFallback(context.Background(), providers, Video{Dir: dir}, []string{"en"})
+	if err != nil {
 		t.Fatalf("FetchWithFallback: %v", err)
 	}
+	if len(subs) != 1 || subs[0].Provider != "good" {
+		t.Errorf("FetchWithFallback = %+v, want good's subtitle", subs)
+	}
 }
 
 func TestFetchWithFallback_AllFail(t *testing.T) {
@@ -45,14 +64,14 @@ func TestFetchWithFallback_AllFail(t *testing.T) {
 		fakeProvider{name: "b", err: errors.New("boom b")},
 	}
 
-	err := FetchWithFallback(providers, "/tmp/whatever", []string{"en"})
+	_, err := FetchWithFallback(context.Background(), providers, Video{Dir: "/tmp/whatever"}, []string{"en"})
 	if err == nil {
 		t.Fatal("FetchWithFallback = nil, want error when every provider fails")
 	}
 }
 
 func TestFetchWithFallback_NoProviders(t *testing.T) {
-	err := FetchWithFallback(nil, "/tmp/whatever", []string{"en"})
+	_, err := FetchWithFallback(context.Background(), nil, Video{Dir: "/tmp/whatever"}, []string{"en"})
 	if err == nil {
 		t.Fatal("FetchWithFallback with no providers = nil, want error")
 	}
@@ -64,5 +83,7 @@ type fakeProviderFunc struct {
 	fn   func() error
 }
 
-func (f fakeProviderFunc) Name() string                 { return f.name }
-func (f fakeProviderFunc) Fetch(string, []string) error { return f.fn() }
+func (f fakeProviderFunc) Name() string { return f.name }
+func (f fakeProviderFunc) Fetch(context.Context, Video, []string) ([]Subtitle, error) {
+	return nil, f.fn()
+}
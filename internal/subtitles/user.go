@@ -0,0 +1,85 @@
+package subtitles
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"go-watch-something/internal/subfile"
+)
+
+// UserProvider is the Provider name given to subtitles the user
+// supplied themselves, with -sub-file or by uploading to /subs/.
+const UserProvider = "user"
+
+// Add stores data -- a subtitle file the user supplied, originally
+// called name -- in dir, alongside whatever the providers fetched. It
+// goes through the same checks and clean-up as a download (see
+// subfile.Sanitize), so a file in the wrong encoding or inside a zip
+// works as well. lang defaults to the one in name, if there is one.
+// An existing file is never overwritten; the new one is renamed
+// instead.
+func Add(dir, name string, data []byte, lang string) (Subtitle, error) {
+	name = filepath.Base(filepath.Clean("/" + name))
+	if subfile.Format(name) == "" {
+		return Subtitle{}, fmt.Errorf("subtitles: %q isn't a subtitle file name (.srt, .vtt, .ass, ...)", name)
+	}
+	clean, format, err := subfile.Sanitize(data, name)
+	if err != nil {
+		return Subtitle{}, fmt.Errorf("subtitles: %s: %w", name, err)
+	}
+	nameLang, hi := subfile.ParseName(name)
+	if lang == "" {
+		lang = nameLang
+	}
+
+	path, err := create(withFormat(filepath.Join(dir, name), format), clean)
+	if err != nil {
+		return Subtitle{}, err
+	}
+	return Subtitle{Path: path, Lang: lang, Provider: UserProvider, HearingImpaired: hi}, nil
+}
+
+// AddFile is Add for a file on disk.
+func AddFile(dir, src, lang string) (Subtitle, error) {
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return Subtitle{}, fmt.Errorf("subtitles: %w", err)
+	}
+	return Add(dir, filepath.Base(src), data, lang)
+}
+
+// create writes data to path, or to "name-2.en.srt", "name-3.en.srt",
+// ... if path is taken, and returns where it went. The number goes
+// before the language and extension so subfile.ParseName still finds
+// them.
+func create(path string, data []byte) (string, error) {
+	dir, name := filepath.Split(path)
+	base := subfile.BaseName(name)
+	suffix := strings.TrimPrefix(name, base)
+	for i := 1; i < 100; i++ {
+		p := path
+		if i > 1 {
+			p = filepath.Join(dir, fmt.Sprintf("%s-%d%s", base, i, suffix))
+		}
+		f, err := os.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+		if errors.Is(err, os.ErrExist) {
+			continue
+		}
+		if err != nil {
+			return "", fmt.Errorf("subtitles: %w", err)
+		}
+		_, err = f.Write(data)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			os.Remove(p)
+			return "", fmt.Errorf("subtitles: %w", err)
+		}
+		return p, nil
+	}
+	return "", fmt.Errorf("subtitles: too many files named like %s", filepath.Base(path))
+}
@@ -0,0 +1,66 @@
+package subtitles
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go-watch-something/internal/subfile"
+)
+
+func TestAdd(t *testing.T) {
+	dir := t.TempDir()
+	latin1 := []byte("1\r\n00:00:01,000 --> 00:00:02,000\r\nOl\xe1\r\n")
+
+	s, err := Add(dir, "../../Movie.pt-BR.srt", latin1, "")
+	if err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	want := Subtitle{Path: filepath.Join(dir, "Movie.pt-BR.srt"), Lang: "pt-BR", Provider: UserProvider}
+	if s != want {
+		t.Errorf("Add = %+v, want %+v", s, want)
+	}
+	data, _ := os.ReadFile(s.Path)
+	if string(data) != "1\n00:00:01,000 --> 00:00:02,000\nOlá\n" {
+		t.Errorf("stored %q, want it as UTF-8 with LF line endings", data)
+	}
+
+	// Same name again: kept alongside, not overwritten.
+	s, err = Add(dir, "Movie.pt-BR.srt", latin1, "pt")
+	if err != nil {
+		t.Fatalf("second Add: %v", err)
+	}
+	if s.Path != filepath.Join(dir, "Movie-2.pt-BR.srt") || s.Lang != "pt" {
+		t.Errorf("second Add = %+v, want Movie-2.pt-BR.srt in pt", s)
+	}
+	if lang, _ := subfile.ParseName(filepath.Base(s.Path)); lang != "pt-BR" {
+		t.Errorf("ParseName(%q) lang = %q, want pt-BR", filepath.Base(s.Path), lang)
+	}
+}
+
+func TestCreate_NumbersBeforeSuffix(t *testing.T) {
+	dir := t.TempDir()
+	want := []string{"Movie.en.hi.srt", "Movie-2.en.hi.srt", "Movie-3.en.hi.srt"}
+	for _, w := range want {
+		p, err := create(filepath.Join(dir, "Movie.en.hi.srt"), []byte("x"))
+		if err != nil {
+			t.Fatalf("create: %v", err)
+		}
+		if filepath.Base(p) != w {
+			t.Errorf("create = %s, want %s", filepath.Base(p), w)
+		}
+	}
+}
+
+func TestAdd_Rejects(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := Add(dir, "movie.txt", []byte("hello"), ""); err == nil {
+		t.Error("Add of a .txt = nil error")
+	}
+	if _, err := Add(dir, "movie.srt", []byte("not a subtitle"), ""); err == nil {
+		t.Error("Add of garbage = nil error")
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("rejected files left %d entries in dir", len(entries))
+	}
+}
@@ -0,0 +1,62 @@
+package subtitles
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"go-watch-something/internal/subfile"
+)
+
+// checkFile makes sure s is a subtitle a player can use -- see
+// subfile.Sanitize -- rewriting it in place with the cleaned-up
+// content. A file that turned out to be an archive of another format
+// is renamed to match, so s.Path may change. A file that isn't usable
+// is deleted, so it's never served.
+func checkFile(s Subtitle) (Subtitle, error) {
+	data, err := os.ReadFile(s.Path)
+	if err != nil {
+		return s, err
+	}
+	clean, format, err := subfile.Sanitize(data, filepath.Base(s.Path))
+	if err != nil {
+		os.Remove(s.Path)
+		return s, fmt.Errorf("%s: %w", filepath.Base(s.Path), err)
+	}
+	path := withFormat(s.Path, format)
+	if path == s.Path && string(clean) == string(data) {
+		return s, nil
+	}
+	if err := os.WriteFile(path, clean, 0o644); err != nil {
+		return s, err
+	}
+	if path != s.Path {
+		os.Remove(s.Path)
+	}
+	s.Path = path
+	return s, nil
+}
+
+// withFormat gives path the extension for format, if it doesn't
+// already have it.
+func withFormat(path, format string) string {
+	if format == "" || subfile.Format(path) == format {
+		return path
+	}
+	return strings.TrimSuffix(path, filepath.Ext(path)) + "." + format
+}
+
+// keepValid runs checkFile over subs, returning the ones that passed
+// and why the others didn't.
+func keepValid(subs []Subtitle) (valid []Subtitle, failures []string) {
+	for _, s := range subs {
+		s, err := checkFile(s)
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("invalid subtitle %v", err))
+			continue
+		}
+		valid = append(valid, s)
+	}
+	return valid, failures
+}
@@ -0,0 +1,45 @@
+package subtitles
+
+import (
+	"archive/zip"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckFile_UnzipsAndRenames(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "movie.en.srt")
+	f, _ := os.Create(path)
+	zw := zip.NewWriter(f)
+	w, _ := zw.Create("Movie.en.vtt")
+	w.Write([]byte("WEBVTT\r\n\r\n00:01.000 --> 00:02.000\r\nHi\r\n"))
+	zw.Close()
+	f.Close()
+
+	s, err := checkFile(Subtitle{Path: path, Lang: "en"})
+	if err != nil {
+		t.Fatalf("checkFile: %v", err)
+	}
+	if want := filepath.Join(dir, "movie.en.vtt"); s.Path != want {
+		t.Errorf("Path = %s, want %s", s.Path, want)
+	}
+	data, _ := os.ReadFile(s.Path)
+	if want := "WEBVTT\n\n00:01.000 --> 00:02.000\nHi\n"; string(data) != want {
+		t.Errorf("content = %q, want %q", data, want)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("zip left behind at %s", path)
+	}
+}
+
+func TestCheckFile_DeletesInvalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "movie.en.srt")
+	os.WriteFile(path, nil, 0o644)
+	if _, err := checkFile(Subtitle{Path: path}); err == nil {
+		t.Fatal("checkFile of an empty file = nil error")
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("invalid file still on disk")
+	}
+}
@@ -26,11 +26,17 @@ func IsValidMagnetLink(magnet string) bool {
 	return isHex || isBase32
 }
 
+// MagnetDisplayName returns the magnet link's "dn" (display name)
+// parameter, or "" if it has none.
+func MagnetDisplayName(magnet string) string {
+	u, err := url.Parse(magnet)
+	if err != nil {
+		return ""
+	}
+	return u.Query().Get("dn")
+}
+
 func IsVideoFile(path string) bool {
 	ext := strings.ToLower(filepath.Ext(path))
 	return ext == ".mp4" || ext == ".mkv" || ext == ".avi" || ext == ".mov"
 }
-
-func ParseLangs(langStr string) []string {
-	return strings.Split(langStr, ",")
-}
@@ -39,6 +39,19 @@ func TestIsValidMagnetLink(t *testing.T) {
 	}
 }
 
+func TestMagnetDisplayName(t *testing.T) {
+	cases := map[string]string{
+		"magnet:?xt=urn:btih:0123456789abcdef0123456789abcdef01234567&dn=Some.Movie.2024.1080p": "Some.Movie.2024.1080p",
+		"magnet:?xt=urn:btih:0123456789abcdef0123456789abcdef01234567&dn=Two+Words%21":          "Two Words!",
+		"magnet:?xt=urn:btih:0123456789abcdef0123456789abcdef01234567":                          "",
+	}
+	for magnet, want := range cases {
+		if got := MagnetDisplayName(magnet); got != want {
+			t.Errorf("MagnetDisplayName(%q) = %q, want %q", magnet, got, want)
+		}
+	}
+}
+
 func TestIsVideoFile(t *testing.T) {
 	cases := map[string]bool{
 		"movie.mp4":         true,
@@ -57,22 +70,3 @@ func TestIsVideoFile(t *testing.T) {
 		}
 	}
 }
-
-func TestParseLangs(t *testing.T) {
-	cases := map[string][]string{
-		"en":          {"en"},
-		"en,pt-BR":    {"en", "pt-BR"},
-		"en,pt-BR,fr": {"en", "pt-BR", "fr"},
-	}
-	for input, want := range cases {
-		got := ParseLangs(input)
-		if len(got) != len(want) {
-			t.Fatalf("ParseLangs(%q) = %v, want %v", input, got, want)
-		}
-		for i := range want {
-			if got[i] != want[i] {
-				t.Errorf("ParseLangs(%q) = %v, want %v", input, got, want)
-			}
-		}
-	}
-}